@@ -0,0 +1,511 @@
+// Package bt_ad parses and builds Bluetooth AD structures (Length-Type-Value encoded advertising data), as used
+// by LE advertising data, scan responses and the Extended Inquiry Response (EIR) of BR/EDR. It is shared by the
+// EIR data of mgmt-api events, the advertising commands of mgmt-api and the AdvertisingData property of DBus
+// devices.
+package bt_ad
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mame82/mblue-toolz/bt_cod"
+)
+
+var (
+	ErrPayloadFormat = errors.New("AD structures exceed the payload")
+	ErrInvalidUUID   = errors.New("Invalid UUID format")
+	ErrFieldLength   = errors.New("AD structure exceeds 254 octets of data")
+	ErrDataLength    = errors.New("AD structures exceed the maximum data length")
+)
+
+// Maximum length of the encoded data
+const (
+	MAX_LEN_LEGACY   = 31  // legacy advertising data and scan response
+	MAX_LEN_EIR      = 240 // Extended Inquiry Response
+	MAX_LEN_EXTENDED = 251 // extended advertising data and scan response, as set by the kernel in one HCI command
+)
+
+// AD / EIR data types
+// see: https://www.bluetooth.com/specifications/assigned-numbers/generic-access-profile
+type ADType byte
+
+const (
+	AD_FLAGS                       ADType = 0x01
+	AD_UUID16_INCOMPLETE           ADType = 0x02
+	AD_UUID16_COMPLETE             ADType = 0x03
+	AD_UUID32_INCOMPLETE           ADType = 0x04
+	AD_UUID32_COMPLETE             ADType = 0x05
+	AD_UUID128_INCOMPLETE          ADType = 0x06
+	AD_UUID128_COMPLETE            ADType = 0x07
+	AD_NAME_SHORT                  ADType = 0x08
+	AD_NAME_COMPLETE               ADType = 0x09
+	AD_TX_POWER                    ADType = 0x0A
+	AD_CLASS_OF_DEVICE             ADType = 0x0D
+	AD_SSP_HASH_P192               ADType = 0x0E
+	AD_SSP_RANDOMIZER_P192         ADType = 0x0F
+	AD_DEVICE_ID                   ADType = 0x10
+	AD_SOLICIT_UUID16              ADType = 0x14
+	AD_SOLICIT_UUID128             ADType = 0x15
+	AD_SERVICE_DATA_UUID16         ADType = 0x16
+	AD_PUBLIC_TARGET_ADDRESS       ADType = 0x17
+	AD_RANDOM_TARGET_ADDRESS       ADType = 0x18
+	AD_APPEARANCE                  ADType = 0x19
+	AD_LE_BLUETOOTH_DEVICE_ADDRESS ADType = 0x1B
+	AD_LE_ROLE                     ADType = 0x1C
+	AD_SSP_HASH_P256               ADType = 0x1D
+	AD_SSP_RANDOMIZER_P256         ADType = 0x1E
+	AD_SOLICIT_UUID32              ADType = 0x1F
+	AD_SERVICE_DATA_UUID32         ADType = 0x20
+	AD_SERVICE_DATA_UUID128        ADType = 0x21
+	AD_LE_SC_CONFIRMATION_VALUE    ADType = 0x22
+	AD_LE_SC_RANDOM_VALUE          ADType = 0x23
+	AD_URI                         ADType = 0x24
+	AD_MANUFACTURER_DATA           ADType = 0xFF
+)
+
+// Bits of the Flags AD structure
+type Flags byte
+
+const (
+	FLAG_LE_LIMITED_DISCOVERABLE Flags = 1 << 0
+	FLAG_LE_GENERAL_DISCOVERABLE Flags = 1 << 1
+	FLAG_BR_EDR_NOT_SUPPORTED    Flags = 1 << 2
+	FLAG_LE_BR_EDR_CONTROLLER    Flags = 1 << 3 // simultaneous LE and BR/EDR (controller)
+	FLAG_LE_BR_EDR_HOST          Flags = 1 << 4 // simultaneous LE and BR/EDR (host), deprecated
+)
+
+var flagNameMap = genFlagNameMap()
+
+func genFlagNameMap() (nMap map[Flags]string) {
+	nMap = make(map[Flags]string)
+	nMap[FLAG_LE_LIMITED_DISCOVERABLE] = "LE Limited Discoverable"
+	nMap[FLAG_LE_GENERAL_DISCOVERABLE] = "LE General Discoverable"
+	nMap[FLAG_BR_EDR_NOT_SUPPORTED] = "BR/EDR Not Supported"
+	nMap[FLAG_LE_BR_EDR_CONTROLLER] = "LE and BR/EDR Controller"
+	nMap[FLAG_LE_BR_EDR_HOST] = "LE and BR/EDR Host"
+	return nMap
+}
+
+// Names of all set flags, separated by "|"
+func (f Flags) String() string {
+	var names []string
+	for bit := uint(0); bit < 8; bit++ {
+		if f&(1<<bit) == 0 {
+			continue
+		}
+		if name, exists := flagNameMap[Flags(1<<bit)]; exists {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("Bit %d", bit))
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// URI scheme name string codes, the first code point of the URI data type (0x01 = no scheme)
+// see: https://www.bluetooth.com/specifications/assigned-numbers/uri-scheme-name-string-mapping
+var uriSchemes = []string{
+	"", "aaa:", "aaas:", "about:", "acap:", "acct:", "cap:", "cid:", "coap:", "coaps:", "crid:", "data:", "dav:",
+	"dict:", "dns:", "file:", "ftp:", "geo:", "go:", "gopher:", "h323:", "http:", "https:", "iax:", "icap:", "im:",
+	"imap:", "info:", "ipp:", "ipps:", "iris:", "iris.beep:", "iris.xpc:", "iris.xpcs:", "iris.lwz:", "jabber:",
+	"ldap:", "mailto:", "mid:", "msrp:", "msrps:", "mtqp:", "mupdate:", "news:", "nfs:", "ni:", "nih:", "nntp:",
+	"opaquelocktoken:", "pop:", "pres:", "reload:", "rtsp:", "rtsps:", "rtspu:", "service:", "session:", "shttp:",
+	"sieve:", "sip:", "sips:", "sms:", "snmp:", "soap.beep:", "soap.beeps:", "stun:", "stuns:", "tag:", "tel:",
+	"telnet:", "tftp:", "thismessage:", "tn3270:", "tip:", "turn:", "turns:", "tv:", "urn:", "vemmi:", "ws:", "wss:",
+	"xcon:", "xcon-userid:", "xmlrpc.beep:", "xmlrpc.beeps:", "xmpp:", "z39.50r:", "z39.50s:",
+}
+
+// Base UUID used to expand 16 and 32 bit UUIDs, format matches the constants in bt_uuid
+const uuidBaseFormat = "%.8x-0000-1000-8000-00805f9b34fb"
+
+const uuidBaseSuffix = "-0000-1000-8000-00805f9b34fb"
+
+type Field struct {
+	Type ADType
+	Data []byte
+}
+
+// Length-Type-Value encoded AD structures. Fields holds the structures in order of appearance, Raw the
+// undecoded data (only set by UpdateFromPayload).
+//
+// The Add... methods append structures and could be chained, f.e.:
+//
+//	ad := bt_ad.New().AddFlags(bt_ad.FLAG_LE_GENERAL_DISCOVERABLE).AddUUIDs(true, bt_uuid.BATTERY_UUID)
+//	pay, err := ad.Payload(bt_ad.MAX_LEN_LEGACY)
+//
+// Errors of the Add... methods (f.e. invalid UUIDs) are returned by Payload.
+type AdvertisingData struct {
+	Raw    []byte
+	Fields []Field
+
+	err error
+}
+
+func New() *AdvertisingData {
+	return &AdvertisingData{}
+}
+
+// Parses the AD structures of pay. If a length octet exceeds the payload, ErrPayloadFormat is returned and
+// Fields holds the structures in front of it.
+func (ad *AdvertisingData) UpdateFromPayload(pay []byte) (err error) {
+	ad.Raw = pay
+	ad.Fields = nil
+	ad.err = nil
+	off := 0
+	for off < len(pay) {
+		fieldLen := int(pay[off])
+		if fieldLen == 0 {
+			break // early termination, remaining data is zero padding
+		}
+		if off+1+fieldLen > len(pay) {
+			return ErrPayloadFormat
+		}
+		ad.Fields = append(ad.Fields, Field{
+			Type: ADType(pay[off+1]),
+			Data: pay[off+2 : off+1+fieldLen],
+		})
+		off += 1 + fieldLen
+	}
+	return
+}
+
+// Length of the encoded structures
+func (ad *AdvertisingData) Len() (l int) {
+	for _, f := range ad.Fields {
+		l += 2 + len(f.Data)
+	}
+	return
+}
+
+// Encodes the structures, fails with ErrDataLength if the result exceeds maxLen (f.e. MAX_LEN_LEGACY, 0 for
+// no limit)
+func (ad *AdvertisingData) Payload(maxLen int) (pay []byte, err error) {
+	if ad.err != nil {
+		return nil, ad.err
+	}
+	if maxLen > 0 && ad.Len() > maxLen {
+		return nil, ErrDataLength
+	}
+	pay = make([]byte, 0, ad.Len())
+	for _, f := range ad.Fields {
+		if len(f.Data) > 254 {
+			return nil, ErrFieldLength
+		}
+		pay = append(pay, byte(len(f.Data)+1), byte(f.Type))
+		pay = append(pay, f.Data...)
+	}
+	return pay, nil
+}
+
+// keeps the first error for Payload
+func (ad *AdvertisingData) fail(err error) *AdvertisingData {
+	if ad.err == nil {
+		ad.err = err
+	}
+	return ad
+}
+
+// Appends a structure of arbitrary type
+func (ad *AdvertisingData) Add(t ADType, data []byte) *AdvertisingData {
+	if len(data) > 254 {
+		return ad.fail(ErrFieldLength)
+	}
+	ad.Fields = append(ad.Fields, Field{Type: t, Data: data})
+	return ad
+}
+
+func (ad *AdvertisingData) AddFlags(flags Flags) *AdvertisingData {
+	return ad.Add(AD_FLAGS, []byte{byte(flags)})
+}
+
+// Adds the service UUIDs (128 bit string representation), grouped into lists of 16, 32 and 128 bit UUIDs.
+// If complete is false, the lists are marked as incomplete.
+func (ad *AdvertisingData) AddUUIDs(complete bool, uuids ...string) *AdvertisingData {
+	var lists [3][]byte
+	for _, uuid := range uuids {
+		pay, err := ShortUUIDToPayload(uuid)
+		if err != nil {
+			return ad.fail(err)
+		}
+		switch len(pay) {
+		case 2:
+			lists[0] = append(lists[0], pay...)
+		case 4:
+			lists[1] = append(lists[1], pay...)
+		default:
+			lists[2] = append(lists[2], pay...)
+		}
+	}
+	types := [3]ADType{AD_UUID16_INCOMPLETE, AD_UUID32_INCOMPLETE, AD_UUID128_INCOMPLETE}
+	if complete {
+		types = [3]ADType{AD_UUID16_COMPLETE, AD_UUID32_COMPLETE, AD_UUID128_COMPLETE}
+	}
+	for i, list := range lists {
+		if len(list) > 0 {
+			ad.Add(types[i], list)
+		}
+	}
+	return ad
+}
+
+func (ad *AdvertisingData) AddName(name string) *AdvertisingData {
+	return ad.Add(AD_NAME_COMPLETE, []byte(name))
+}
+
+func (ad *AdvertisingData) AddShortName(name string) *AdvertisingData {
+	return ad.Add(AD_NAME_SHORT, []byte(name))
+}
+
+func (ad *AdvertisingData) AddTxPower(dBm int8) *AdvertisingData {
+	return ad.Add(AD_TX_POWER, []byte{byte(dBm)})
+}
+
+func (ad *AdvertisingData) AddAppearance(appearance uint16) *AdvertisingData {
+	data := make([]byte, 2)
+	binary.LittleEndian.PutUint16(data, appearance)
+	return ad.Add(AD_APPEARANCE, data)
+}
+
+func (ad *AdvertisingData) AddClassOfDevice(class bt_cod.CoD) *AdvertisingData {
+	return ad.Add(AD_CLASS_OF_DEVICE, class.Payload())
+}
+
+// Adds service data, the type (16, 32 or 128 bit UUID) depends on the given UUID
+func (ad *AdvertisingData) AddServiceData(uuid string, data []byte) *AdvertisingData {
+	pay, err := ShortUUIDToPayload(uuid)
+	if err != nil {
+		return ad.fail(err)
+	}
+	t := AD_SERVICE_DATA_UUID128
+	switch len(pay) {
+	case 2:
+		t = AD_SERVICE_DATA_UUID16
+	case 4:
+		t = AD_SERVICE_DATA_UUID32
+	}
+	return ad.Add(t, append(pay, data...))
+}
+
+func (ad *AdvertisingData) AddManufacturerData(companyID uint16, data []byte) *AdvertisingData {
+	pay := make([]byte, 2, 2+len(data))
+	binary.LittleEndian.PutUint16(pay, companyID)
+	return ad.Add(AD_MANUFACTURER_DATA, append(pay, data...))
+}
+
+// Adds the URI, a known scheme (f.e. "https:") is replaced by its code
+func (ad *AdvertisingData) AddURI(uri string) *AdvertisingData {
+	code, rest := 1, uri
+	for i, scheme := range uriSchemes {
+		if scheme != "" && strings.HasPrefix(uri, scheme) {
+			code, rest = i+1, uri[len(scheme):]
+			break
+		}
+	}
+	data := make([]byte, utf8.RuneLen(rune(code)))
+	utf8.EncodeRune(data, rune(code))
+	return ad.Add(AD_URI, append(data, rest...))
+}
+
+// Returns the data of the first field with the given type
+func (ad *AdvertisingData) Field(t ADType) (data []byte, exists bool) {
+	for _, f := range ad.Fields {
+		if f.Type == t {
+			return f.Data, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the complete name, falls back to the short name
+func (ad *AdvertisingData) Name() (name string, exists bool) {
+	if data, exists := ad.Field(AD_NAME_COMPLETE); exists {
+		return string(data), true
+	}
+	if data, exists := ad.Field(AD_NAME_SHORT); exists {
+		return string(data), true
+	}
+	return "", false
+}
+
+func (ad *AdvertisingData) Flags() (flags Flags, exists bool) {
+	data, exists := ad.Field(AD_FLAGS)
+	if !exists || len(data) < 1 {
+		return 0, false
+	}
+	return Flags(data[0]), true
+}
+
+func (ad *AdvertisingData) TxPower() (txPower int8, exists bool) {
+	data, exists := ad.Field(AD_TX_POWER)
+	if !exists || len(data) != 1 {
+		return 0, false
+	}
+	return int8(data[0]), true
+}
+
+func (ad *AdvertisingData) Appearance() (appearance uint16, exists bool) {
+	data, exists := ad.Field(AD_APPEARANCE)
+	if !exists || len(data) != 2 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(data), true
+}
+
+func (ad *AdvertisingData) ClassOfDevice() (class bt_cod.CoD, exists bool) {
+	data, exists := ad.Field(AD_CLASS_OF_DEVICE)
+	if !exists {
+		return 0, false
+	}
+	if class.UpdateFromPayload(data) != nil {
+		return 0, false
+	}
+	return class, true
+}
+
+// Returns all service UUIDs (complete and incomplete lists of 16, 32 and 128 bit UUIDs) in
+// 128 bit string representation
+func (ad *AdvertisingData) UUIDs() (uuids []string) {
+	for _, f := range ad.Fields {
+		uuidLen := 0
+		switch f.Type {
+		case AD_UUID16_INCOMPLETE, AD_UUID16_COMPLETE:
+			uuidLen = 2
+		case AD_UUID32_INCOMPLETE, AD_UUID32_COMPLETE:
+			uuidLen = 4
+		case AD_UUID128_INCOMPLETE, AD_UUID128_COMPLETE:
+			uuidLen = 16
+		default:
+			continue
+		}
+		for off := 0; off+uuidLen <= len(f.Data); off += uuidLen {
+			uuids = append(uuids, UUIDFromPayload(f.Data[off:off+uuidLen]))
+		}
+	}
+	return
+}
+
+// Returns true if all present UUID lists are complete (false if there's no UUID list at all)
+func (ad *AdvertisingData) UUIDsComplete() bool {
+	found := false
+	for _, f := range ad.Fields {
+		switch f.Type {
+		case AD_UUID16_INCOMPLETE, AD_UUID32_INCOMPLETE, AD_UUID128_INCOMPLETE:
+			return false
+		case AD_UUID16_COMPLETE, AD_UUID32_COMPLETE, AD_UUID128_COMPLETE:
+			found = true
+		}
+	}
+	return found
+}
+
+// Manufacturer specific data, keyed by company identifier
+func (ad *AdvertisingData) ManufacturerData() (res map[uint16][]byte) {
+	res = make(map[uint16][]byte)
+	for _, f := range ad.Fields {
+		if f.Type == AD_MANUFACTURER_DATA && len(f.Data) >= 2 {
+			res[binary.LittleEndian.Uint16(f.Data[0:2])] = f.Data[2:]
+		}
+	}
+	return
+}
+
+// Service data, keyed by the service UUID in 128 bit string representation
+func (ad *AdvertisingData) ServiceData() (res map[string][]byte) {
+	res = make(map[string][]byte)
+	for _, f := range ad.Fields {
+		uuidLen := 0
+		switch f.Type {
+		case AD_SERVICE_DATA_UUID16:
+			uuidLen = 2
+		case AD_SERVICE_DATA_UUID32:
+			uuidLen = 4
+		case AD_SERVICE_DATA_UUID128:
+			uuidLen = 16
+		default:
+			continue
+		}
+		if len(f.Data) < uuidLen {
+			continue
+		}
+		res[UUIDFromPayload(f.Data[:uuidLen])] = f.Data[uuidLen:]
+	}
+	return
+}
+
+// Returns the URI with its scheme expanded, unknown scheme codes are dropped
+func (ad *AdvertisingData) URI() (uri string, exists bool) {
+	data, exists := ad.Field(AD_URI)
+	if !exists {
+		return "", false
+	}
+	code, size := utf8.DecodeRune(data)
+	if code == utf8.RuneError {
+		return "", false
+	}
+	scheme := ""
+	if int(code) >= 1 && int(code) <= len(uriSchemes) {
+		scheme = uriSchemes[code-1]
+	}
+	return scheme + string(data[size:]), true
+}
+
+func (ad AdvertisingData) String() string {
+	res := "AD:"
+	for _, f := range ad.Fields {
+		res += fmt.Sprintf(" [0x%.2x % x]", byte(f.Type), f.Data)
+	}
+	return res
+}
+
+// Converts a little endian 16, 32 or 128 bit UUID to its 128 bit string representation, returns an empty
+// string for other lengths
+func UUIDFromPayload(pay []byte) string {
+	switch len(pay) {
+	case 2:
+		return fmt.Sprintf(uuidBaseFormat, binary.LittleEndian.Uint16(pay))
+	case 4:
+		return fmt.Sprintf(uuidBaseFormat, binary.LittleEndian.Uint32(pay))
+	case 16:
+		u := reverse(pay)
+		return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+	default:
+		return ""
+	}
+}
+
+// Converts a 128 bit UUID string (f.e. "0000180d-0000-1000-8000-00805f9b34fb") to its 16 octet little endian
+// wire format
+func UUIDToPayload(uuid string) (pay []byte, err error) {
+	raw, err := hex.DecodeString(strings.Replace(uuid, "-", "", -1))
+	if err != nil || len(raw) != 16 {
+		return nil, ErrInvalidUUID
+	}
+	return reverse(raw), nil
+}
+
+// Like UUIDToPayload, but returns the 2 or 4 octet form for UUIDs based on the Bluetooth Base UUID
+func ShortUUIDToPayload(uuid string) (pay []byte, err error) {
+	pay, err = UUIDToPayload(uuid)
+	if err != nil {
+		return
+	}
+	if !strings.HasSuffix(strings.ToLower(uuid), uuidBaseSuffix) {
+		return
+	}
+	if pay[14] == 0 && pay[15] == 0 {
+		return pay[12:14], nil
+	}
+	return pay[12:16], nil
+}
+
+func reverse(src []byte) []byte {
+	dst := make([]byte, len(src))
+	for i, v := range src {
+		dst[len(src)-1-i] = v
+	}
+	return dst
+}
@@ -0,0 +1,127 @@
+package bt_ad
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPayloadMaxLen(t *testing.T) {
+	// 2 + 29 octets of manufacturer data (company ID and 27 octets)
+	ad := New().AddManufacturerData(0x004c, make([]byte, 27))
+	if pay, err := ad.Payload(MAX_LEN_LEGACY); err != nil || len(pay) != 31 {
+		t.Fatalf("31 octets: got %d octets, %v", len(pay), err)
+	}
+	ad = New().AddManufacturerData(0x004c, make([]byte, 28))
+	if _, err := ad.Payload(MAX_LEN_LEGACY); err != ErrDataLength {
+		t.Errorf("32 octets: got %v, want %v", err, ErrDataLength)
+	}
+	if pay, err := ad.Payload(0); err != nil || len(pay) != 32 {
+		t.Errorf("no limit: got %d octets, %v", len(pay), err)
+	}
+
+	ad = New().Add(AD_MANUFACTURER_DATA, make([]byte, 249))
+	if pay, err := ad.Payload(MAX_LEN_EXTENDED); err != nil || len(pay) != 251 {
+		t.Errorf("251 octets: got %d octets, %v", len(pay), err)
+	}
+	if _, err := ad.Payload(MAX_LEN_EIR); err != ErrDataLength {
+		t.Errorf("251 octets of EIR: got %v, want %v", err, ErrDataLength)
+	}
+	ad.AddTxPower(0)
+	if _, err := ad.Payload(MAX_LEN_EXTENDED); err != ErrDataLength {
+		t.Errorf("254 octets: got %v, want %v", err, ErrDataLength)
+	}
+
+	// a single structure holds at most 254 octets of data
+	if _, err := New().Add(AD_MANUFACTURER_DATA, make([]byte, 255)).Payload(0); err != ErrFieldLength {
+		t.Errorf("255 octets of data: got %v, want %v", err, ErrFieldLength)
+	}
+}
+
+func TestUUIDLists(t *testing.T) {
+	uuids := []string{
+		"0000180d-0000-1000-8000-00805f9b34fb",
+		"0000180f-0000-1000-8000-00805f9b34fb",
+		"12345678-0000-1000-8000-00805f9b34fb",
+		"6e400001-b5a3-f393-e0a9-e50e24dcca9e",
+	}
+	pay, err := New().AddUUIDs(true, uuids...).Payload(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		5, byte(AD_UUID16_COMPLETE), 0x0d, 0x18, 0x0f, 0x18,
+		5, byte(AD_UUID32_COMPLETE), 0x78, 0x56, 0x34, 0x12,
+		17, byte(AD_UUID128_COMPLETE),
+		0x9e, 0xca, 0xdc, 0x24, 0x0e, 0xe5, 0xa9, 0xe0, 0x93, 0xf3, 0xa3, 0xb5, 0x01, 0x00, 0x40, 0x6e,
+	}
+	if !bytes.Equal(pay, want) {
+		t.Fatalf("got % x, want % x", pay, want)
+	}
+
+	ad := &AdvertisingData{}
+	if err = ad.UpdateFromPayload(pay); err != nil {
+		t.Fatal(err)
+	}
+	if got := ad.UUIDs(); !reflect.DeepEqual(got, uuids) {
+		t.Errorf("got %v, want %v", got, uuids)
+	}
+	if !ad.UUIDsComplete() {
+		t.Error("lists not reported as complete")
+	}
+
+	pay, _ = New().AddUUIDs(true, uuids[0]).AddUUIDs(false, uuids[3]).Payload(0)
+	ad.UpdateFromPayload(pay)
+	if ad.UUIDsComplete() {
+		t.Error("incomplete 128 bit list reported as complete")
+	}
+	if ad.UpdateFromPayload([]byte{2, 1, 6}); ad.UUIDsComplete() || len(ad.UUIDs()) != 0 {
+		t.Error("UUIDs reported without a list")
+	}
+
+	// trailing octets of a truncated list are ignored
+	ad.UpdateFromPayload([]byte{4, byte(AD_UUID16_INCOMPLETE), 0x0d, 0x18, 0x0f})
+	if got := ad.UUIDs(); !reflect.DeepEqual(got, uuids[:1]) {
+		t.Errorf("got %v, want %v", got, uuids[:1])
+	}
+
+	if _, err = New().AddUUIDs(true, "180d").Payload(0); err != ErrInvalidUUID {
+		t.Errorf("got %v, want %v", err, ErrInvalidUUID)
+	}
+}
+
+func TestURISchemes(t *testing.T) {
+	tests := []struct {
+		uri  string
+		data []byte
+	}{
+		{"https://example.com", append([]byte{0x17}, "//example.com"...)},
+		{"http://example.com", append([]byte{0x16}, "//example.com"...)},
+		{"aaa:x", []byte{0x02, 'x'}},
+		{"z39.50s:x", []byte{0x58, 'x'}},
+		{"unknown://example.com", append([]byte{0x01}, "unknown://example.com"...)},
+	}
+	for _, test := range tests {
+		ad := New().AddURI(test.uri)
+		if data, _ := ad.Field(AD_URI); !bytes.Equal(data, test.data) {
+			t.Errorf("%s: got % x, want % x", test.uri, data, test.data)
+		}
+		if uri, exists := ad.URI(); !exists || uri != test.uri {
+			t.Errorf("%s: decoded as %q", test.uri, uri)
+		}
+	}
+}
+
+func TestUpdateFromPayloadTruncated(t *testing.T) {
+	ad := &AdvertisingData{}
+	if err := ad.UpdateFromPayload([]byte{2, 1, 6, 5, 9, 'a', 'b'}); err != ErrPayloadFormat {
+		t.Fatalf("got %v, want %v", err, ErrPayloadFormat)
+	}
+	if flags, exists := ad.Flags(); !exists || flags != 6 || len(ad.Fields) != 1 {
+		t.Errorf("structures in front of the truncated one not kept: %v", ad)
+	}
+	// zero padding ends the data
+	if err := ad.UpdateFromPayload([]byte{2, 1, 6, 0, 0, 0}); err != nil || len(ad.Fields) != 1 {
+		t.Errorf("padded data: %v, %v", ad, err)
+	}
+}
@@ -0,0 +1,213 @@
+package bt_beacon
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/mame82/mblue-toolz/bt_ad"
+)
+
+// see: https://github.com/google/eddystone/blob/master/protocol-specification.md
+
+// 16 bit service UUID of Eddystone
+const EDDYSTONE_UUID = "0000feaa-0000-1000-8000-00805f9b34fb"
+
+const (
+	EDDYSTONE_FRAME_UID byte = 0x00
+	EDDYSTONE_FRAME_URL byte = 0x10
+	EDDYSTONE_FRAME_TLM byte = 0x20
+)
+
+// Value of EddystoneTLM.Temperature, if the beacon has no temperature sensor
+const EDDYSTONE_TEMPERATURE_NOT_SUPPORTED float32 = -128
+
+// Eddystone frame, one of *EddystoneUID, *EddystoneURL or *EddystoneTLM
+type EddystoneFrame interface {
+	FrameType() byte
+	AD() (ad *bt_ad.AdvertisingData, err error)
+}
+
+// wraps the frame into the Eddystone service UUID list and service data
+func eddystoneAD(frame []byte) *bt_ad.AdvertisingData {
+	return bt_ad.New().AddUUIDs(true, EDDYSTONE_UUID).AddServiceData(EDDYSTONE_UUID, frame)
+}
+
+type EddystoneUID struct {
+	TxPower   int8 // calibrated TX power at 0 meter
+	Namespace [10]byte
+	Instance  [6]byte
+}
+
+func (f *EddystoneUID) FrameType() byte {
+	return EDDYSTONE_FRAME_UID
+}
+
+func (f *EddystoneUID) AD() (ad *bt_ad.AdvertisingData, err error) {
+	frame := []byte{EDDYSTONE_FRAME_UID, byte(f.TxPower)}
+	frame = append(frame, f.Namespace[:]...)
+	frame = append(frame, f.Instance[:]...)
+	frame = append(frame, 0, 0) // reserved
+	return eddystoneAD(frame), nil
+}
+
+func (f EddystoneUID) String() string {
+	return fmt.Sprintf("Eddystone-UID namespace %x instance %x TX power %d dBm", f.Namespace, f.Instance, f.TxPower)
+}
+
+type EddystoneURL struct {
+	TxPower int8   // calibrated TX power at 0 meter
+	URL     string // has to start with "http://" or "https://", at most 17 octets after compression
+}
+
+// URL scheme prefixes in order of their codes
+var eddystoneURLSchemes = []string{"http://www.", "https://www.", "http://", "https://"}
+
+// URL expansions in order of their codes
+var eddystoneURLExpansions = []string{
+	".com/", ".org/", ".edu/", ".net/", ".info/", ".biz/", ".gov/",
+	".com", ".org", ".edu", ".net", ".info", ".biz", ".gov",
+}
+
+func (f *EddystoneURL) FrameType() byte {
+	return EDDYSTONE_FRAME_URL
+}
+
+func (f *EddystoneURL) AD() (ad *bt_ad.AdvertisingData, err error) {
+	url, err := encodeEddystoneURL(f.URL)
+	if err != nil {
+		return
+	}
+	return eddystoneAD(append([]byte{EDDYSTONE_FRAME_URL, byte(f.TxPower)}, url...)), nil
+}
+
+func (f EddystoneURL) String() string {
+	return fmt.Sprintf("Eddystone-URL %s TX power %d dBm", f.URL, f.TxPower)
+}
+
+func encodeEddystoneURL(url string) (enc []byte, err error) {
+	scheme := -1
+	for i, prefix := range eddystoneURLSchemes {
+		// the schemes are ordered, thus the "www." variants take precedence
+		if strings.HasPrefix(url, prefix) {
+			scheme = i
+			break
+		}
+	}
+	if scheme < 0 {
+		return nil, ErrURLScheme
+	}
+	enc = []byte{byte(scheme)}
+	rest := url[len(eddystoneURLSchemes[scheme]):]
+	for len(rest) > 0 {
+		expanded := false
+		for code, expansion := range eddystoneURLExpansions {
+			// expansions with trailing slash come first and take precedence
+			if strings.HasPrefix(rest, expansion) {
+				enc = append(enc, byte(code))
+				rest = rest[len(expansion):]
+				expanded = true
+				break
+			}
+		}
+		if !expanded {
+			if !isEddystoneURLChar(rest[0]) {
+				return nil, ErrURLCharacter
+			}
+			enc = append(enc, rest[0])
+			rest = rest[1:]
+		}
+	}
+	if len(enc) > 18 {
+		return nil, ErrURLLength
+	}
+	return
+}
+
+// Codes 0x00-0x20 are expansion codes (or reserved for them), 0x7f-0xff are reserved, too. Only printable ASCII
+// characters are encoded literally.
+func isEddystoneURLChar(c byte) bool {
+	return c > 0x20 && c < 0x7f
+}
+
+func decodeEddystoneURL(enc []byte) (url string, ok bool) {
+	if len(enc) < 1 || int(enc[0]) >= len(eddystoneURLSchemes) {
+		return "", false
+	}
+	url = eddystoneURLSchemes[enc[0]]
+	for _, c := range enc[1:] {
+		switch {
+		case int(c) < len(eddystoneURLExpansions):
+			url += eddystoneURLExpansions[c]
+		case isEddystoneURLChar(c):
+			url += string(rune(c))
+		default:
+			return "", false
+		}
+	}
+	return url, true
+}
+
+// Unencrypted telemetry frame, usually interleaved with UID or URL frames
+type EddystoneTLM struct {
+	BatteryVoltage uint16  // mV, 0 if not supported
+	Temperature    float32 // °C in steps of 1/256, EDDYSTONE_TEMPERATURE_NOT_SUPPORTED if not supported
+	AdvCount       uint32  // advertising PDUs sent since power-on or reboot
+	Uptime         uint32  // time since power-on or reboot in 0.1 seconds
+}
+
+func (f *EddystoneTLM) FrameType() byte {
+	return EDDYSTONE_FRAME_TLM
+}
+
+func (f *EddystoneTLM) AD() (ad *bt_ad.AdvertisingData, err error) {
+	frame := make([]byte, 14)
+	frame[0] = EDDYSTONE_FRAME_TLM
+	frame[1] = 0x00 // version (unencrypted)
+	binary.BigEndian.PutUint16(frame[2:4], f.BatteryVoltage)
+	binary.BigEndian.PutUint16(frame[4:6], uint16(int16(f.Temperature*256))) // signed 8.8 fixed point
+	binary.BigEndian.PutUint32(frame[6:10], f.AdvCount)
+	binary.BigEndian.PutUint32(frame[10:14], f.Uptime)
+	return eddystoneAD(frame), nil
+}
+
+func (f EddystoneTLM) String() string {
+	return fmt.Sprintf("Eddystone-TLM battery %d mV temperature %.2f °C advertisements %d uptime %.1f s",
+		f.BatteryVoltage, f.Temperature, f.AdvCount, float32(f.Uptime)/10)
+}
+
+// Returns the Eddystone frame contained in the advertising data, if any. Encrypted TLM frames and unknown
+// frame types (f.e. EID) aren't recognised.
+func ParseEddystone(ad *bt_ad.AdvertisingData) (frame EddystoneFrame, ok bool) {
+	data, exists := ad.ServiceData()[EDDYSTONE_UUID]
+	if !exists || len(data) < 2 {
+		return nil, false
+	}
+	switch data[0] {
+	case EDDYSTONE_FRAME_UID:
+		if len(data) != 20 && len(data) != 18 { // reserved octets are optional
+			return nil, false
+		}
+		uid := &EddystoneUID{TxPower: int8(data[1])}
+		copy(uid.Namespace[:], data[2:12])
+		copy(uid.Instance[:], data[12:18])
+		return uid, true
+	case EDDYSTONE_FRAME_URL:
+		url, ok := decodeEddystoneURL(data[2:])
+		if !ok {
+			return nil, false
+		}
+		return &EddystoneURL{TxPower: int8(data[1]), URL: url}, true
+	case EDDYSTONE_FRAME_TLM:
+		if len(data) != 14 || data[1] != 0x00 {
+			return nil, false
+		}
+		return &EddystoneTLM{
+			BatteryVoltage: binary.BigEndian.Uint16(data[2:4]),
+			Temperature:    float32(int16(binary.BigEndian.Uint16(data[4:6]))) / 256,
+			AdvCount:       binary.BigEndian.Uint32(data[6:10]),
+			Uptime:         binary.BigEndian.Uint32(data[10:14]),
+		}, true
+	}
+	return nil, false
+}
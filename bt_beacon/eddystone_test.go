@@ -0,0 +1,57 @@
+package bt_beacon
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEddystoneURLRoundTrip(t *testing.T) {
+	for _, url := range []string{"https://www.example.com/abc", "http://goo.gl/xyz", "https://a.b/~x?y=1&z"} {
+		frame := &EddystoneURL{TxPower: -20, URL: url}
+		ad, err := frame.AD()
+		if err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		parsed, ok := ParseEddystone(ad)
+		if !ok {
+			t.Fatalf("%s: frame not recognised", url)
+		}
+		if got := parsed.(*EddystoneURL); *got != *frame {
+			t.Errorf("%s: got %+v", url, got)
+		}
+	}
+}
+
+func TestEddystoneURLEncoding(t *testing.T) {
+	// "https://www." (0x01), "example", ".com/" (0x00)
+	enc, err := encodeEddystoneURL("https://www.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := append([]byte{0x01}, append([]byte("example"), 0x00)...); !bytes.Equal(enc, exp) {
+		t.Errorf("got % x, want % x", enc, exp)
+	}
+
+	invalid := map[string]error{
+		"ftp://example.com":                         ErrURLScheme,
+		"https://averyveryverylonghostname.example": ErrURLLength,
+		"https://exa mple.com":                      ErrURLCharacter,
+		"https://example.com/\x0f":                  ErrURLCharacter,
+		"https://example.com/\x7f":                  ErrURLCharacter,
+		"https://exämple.com":                       ErrURLCharacter,
+	}
+	for url, expErr := range invalid {
+		if _, err := (&EddystoneURL{URL: url}).AD(); err != expErr {
+			t.Errorf("%q: got %v, want %v", url, err, expErr)
+		}
+	}
+}
+
+func TestEddystoneURLReservedCodes(t *testing.T) {
+	for _, code := range []byte{0x0e, 0x20, 0x7f, 0x80, 0xff} {
+		frame := []byte{EDDYSTONE_FRAME_URL, 0xec, 0x03, 'a', code}
+		if parsed, ok := ParseEddystone(eddystoneAD(frame)); ok {
+			t.Errorf("reserved code %#x accepted: %+v", code, parsed)
+		}
+	}
+}
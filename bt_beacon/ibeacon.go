@@ -0,0 +1,77 @@
+// Package bt_beacon builds and recognises the advertising data of iBeacon and Eddystone (UID, URL, TLM) beacons.
+//
+// The frames are encoded as bt_ad.AdvertisingData, without Flags structure. For mgmt advertising, the kernel
+// adds the flags if the advertisement is discoverable, f.e.:
+//
+//	ad, err := bt_beacon.IBeacon{UUID: uuid, Major: 1, Minor: 2, MeasuredPower: -59}.AD()
+//	adv := btmgmt.NewAdvertisement(1).Discoverable().WithAD(ad)
+//	instance, err := bm.AddAdvertising(0, adv)
+//
+// For a BlueZ LEAdvertisement1, toolz.LEAdvertisementProperties converts the returned data to the properties of
+// the advertisement object (ManufacturerData, ServiceData, ServiceUUIDs ..., BlueZ adds the flags itself).
+// To recognise beacons in scan results, pass the EIR of a btmgmt Device Found event (or the data returned by
+// the AdvertisingData getter of a DBus device) to ParseIBeacon or ParseEddystone.
+package bt_beacon
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mame82/mblue-toolz/bt_ad"
+)
+
+var (
+	ErrInvalidUUID  = errors.New("Invalid proximity UUID")
+	ErrURLScheme    = errors.New("URL scheme not supported by Eddystone-URL")
+	ErrURLLength    = errors.New("Encoded URL exceeds 17 octets")
+	ErrURLCharacter = errors.New("URL contains characters reserved by Eddystone-URL (only printable ASCII is allowed)")
+)
+
+// Company identifier of Apple Inc., used by iBeacon manufacturer data
+const COMPANY_ID_APPLE uint16 = 0x004c
+
+// iBeacon type and length, following the company identifier
+var iBeaconPrefix = []byte{0x02, 0x15}
+
+type IBeacon struct {
+	UUID          string // proximity UUID, f.e. "f7826da6-4fa2-4e98-8024-bc5b71e0893e"
+	Major         uint16
+	Minor         uint16
+	MeasuredPower int8 // RSSI at 1 meter distance
+}
+
+func (b IBeacon) String() string {
+	return fmt.Sprintf("iBeacon %s major %d minor %d measured power %d dBm", b.UUID, b.Major, b.Minor, b.MeasuredPower)
+}
+
+// Encodes the beacon as manufacturer data (30 octets with the flags added by the kernel)
+func (b IBeacon) AD() (ad *bt_ad.AdvertisingData, err error) {
+	uuid, err := hex.DecodeString(strings.Replace(b.UUID, "-", "", -1))
+	if err != nil || len(uuid) != 16 {
+		return nil, ErrInvalidUUID
+	}
+	data := append([]byte{}, iBeaconPrefix...)
+	data = append(data, uuid...) // big endian, unlike the UUIDs of AD structures
+	data = append(data, 0, 0, 0, 0, byte(b.MeasuredPower))
+	binary.BigEndian.PutUint16(data[18:20], b.Major)
+	binary.BigEndian.PutUint16(data[20:22], b.Minor)
+	return bt_ad.New().AddManufacturerData(COMPANY_ID_APPLE, data), nil
+}
+
+// Returns the iBeacon contained in the advertising data, if any
+func ParseIBeacon(ad *bt_ad.AdvertisingData) (beacon *IBeacon, ok bool) {
+	data, exists := ad.ManufacturerData()[COMPANY_ID_APPLE]
+	if !exists || len(data) != 23 || data[0] != iBeaconPrefix[0] || data[1] != iBeaconPrefix[1] {
+		return nil, false
+	}
+	u := data[2:18]
+	return &IBeacon{
+		UUID:          fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]),
+		Major:         binary.BigEndian.Uint16(data[18:20]),
+		Minor:         binary.BigEndian.Uint16(data[20:22]),
+		MeasuredPower: int8(data[22]),
+	}, true
+}
@@ -0,0 +1,446 @@
+// Package bt_cod decodes and encodes the Bluetooth Class of Device (CoD), as used by the mgmt API (3 octets,
+// little endian) and by the DBus API of bluez (uint32).
+package bt_cod
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// see: https://www.bluetooth.com/specifications/assigned-numbers/baseband
+
+var (
+	ErrPayloadFormat = errors.New("Class of Device has to be 3 octets")
+)
+
+// Class of Device in its 24 bit representation:
+// bits 0..1 format type (always 0), bits 2..7 minor device class, bits 8..12 major device class,
+// bits 13..23 major service classes
+type CoD uint32
+
+// Bit mask of major service classes (bits 13..23 of the CoD)
+type ServiceClass uint32
+
+const (
+	SERVICE_CLASS_LIMITED_DISCOVERABLE ServiceClass = 1 << 13
+	SERVICE_CLASS_LE_AUDIO             ServiceClass = 1 << 14
+	SERVICE_CLASS_POSITIONING          ServiceClass = 1 << 16
+	SERVICE_CLASS_NETWORKING           ServiceClass = 1 << 17
+	SERVICE_CLASS_RENDERING            ServiceClass = 1 << 18
+	SERVICE_CLASS_CAPTURING            ServiceClass = 1 << 19
+	SERVICE_CLASS_OBJECT_TRANSFER      ServiceClass = 1 << 20
+	SERVICE_CLASS_AUDIO                ServiceClass = 1 << 21
+	SERVICE_CLASS_TELEPHONY            ServiceClass = 1 << 22
+	SERVICE_CLASS_INFORMATION          ServiceClass = 1 << 23
+
+	serviceClassMask ServiceClass = 0xffe000
+)
+
+var serviceClassNameMap = genServiceClassNameMap()
+
+func genServiceClassNameMap() (nMap map[ServiceClass]string) {
+	nMap = make(map[ServiceClass]string)
+	nMap[SERVICE_CLASS_LIMITED_DISCOVERABLE] = "Limited Discoverable Mode"
+	nMap[SERVICE_CLASS_LE_AUDIO] = "LE Audio"
+	nMap[SERVICE_CLASS_POSITIONING] = "Positioning"
+	nMap[SERVICE_CLASS_NETWORKING] = "Networking"
+	nMap[SERVICE_CLASS_RENDERING] = "Rendering"
+	nMap[SERVICE_CLASS_CAPTURING] = "Capturing"
+	nMap[SERVICE_CLASS_OBJECT_TRANSFER] = "Object Transfer"
+	nMap[SERVICE_CLASS_AUDIO] = "Audio"
+	nMap[SERVICE_CLASS_TELEPHONY] = "Telephony"
+	nMap[SERVICE_CLASS_INFORMATION] = "Information"
+	return nMap
+}
+
+// Names of all set service classes, separated by "|"
+func (s ServiceClass) String() string {
+	var names []string
+	for bit := uint(13); bit <= 23; bit++ {
+		if s&(1<<bit) == 0 {
+			continue
+		}
+		if name, exists := serviceClassNameMap[ServiceClass(1<<bit)]; exists {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("Reserved (bit %d)", bit))
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Major device class (bits 8..12 of the CoD)
+type MajorDeviceClass byte
+
+const (
+	MAJOR_MISCELLANEOUS MajorDeviceClass = 0x00
+	MAJOR_COMPUTER      MajorDeviceClass = 0x01
+	MAJOR_PHONE         MajorDeviceClass = 0x02
+	MAJOR_NETWORK       MajorDeviceClass = 0x03 // LAN/Network Access Point
+	MAJOR_AUDIO_VIDEO   MajorDeviceClass = 0x04
+	MAJOR_PERIPHERAL    MajorDeviceClass = 0x05
+	MAJOR_IMAGING       MajorDeviceClass = 0x06
+	MAJOR_WEARABLE      MajorDeviceClass = 0x07
+	MAJOR_TOY           MajorDeviceClass = 0x08
+	MAJOR_HEALTH        MajorDeviceClass = 0x09
+	MAJOR_UNCATEGORIZED MajorDeviceClass = 0x1f
+)
+
+var majorNameMap = genMajorNameMap()
+
+func genMajorNameMap() (nMap map[MajorDeviceClass]string) {
+	nMap = make(map[MajorDeviceClass]string)
+	nMap[MAJOR_MISCELLANEOUS] = "Miscellaneous"
+	nMap[MAJOR_COMPUTER] = "Computer"
+	nMap[MAJOR_PHONE] = "Phone"
+	nMap[MAJOR_NETWORK] = "LAN/Network Access Point"
+	nMap[MAJOR_AUDIO_VIDEO] = "Audio/Video"
+	nMap[MAJOR_PERIPHERAL] = "Peripheral"
+	nMap[MAJOR_IMAGING] = "Imaging"
+	nMap[MAJOR_WEARABLE] = "Wearable"
+	nMap[MAJOR_TOY] = "Toy"
+	nMap[MAJOR_HEALTH] = "Health"
+	nMap[MAJOR_UNCATEGORIZED] = "Uncategorized"
+	return nMap
+}
+
+func (m MajorDeviceClass) String() string {
+	if name, exists := majorNameMap[m]; exists {
+		return name
+	}
+	return "Reserved"
+}
+
+// Minor device class (bits 2..7 of the CoD, without the format type bits). The meaning depends on the
+// major device class, see the MINOR_... constants.
+type MinorDeviceClass byte
+
+// Minor device classes of MAJOR_COMPUTER
+const (
+	MINOR_COMPUTER_UNCATEGORIZED MinorDeviceClass = 0x00
+	MINOR_COMPUTER_DESKTOP       MinorDeviceClass = 0x01
+	MINOR_COMPUTER_SERVER        MinorDeviceClass = 0x02
+	MINOR_COMPUTER_LAPTOP        MinorDeviceClass = 0x03
+	MINOR_COMPUTER_HANDHELD      MinorDeviceClass = 0x04
+	MINOR_COMPUTER_PALM_SIZE     MinorDeviceClass = 0x05
+	MINOR_COMPUTER_WEARABLE      MinorDeviceClass = 0x06
+	MINOR_COMPUTER_TABLET        MinorDeviceClass = 0x07
+)
+
+// Minor device classes of MAJOR_PHONE
+const (
+	MINOR_PHONE_UNCATEGORIZED MinorDeviceClass = 0x00
+	MINOR_PHONE_CELLULAR      MinorDeviceClass = 0x01
+	MINOR_PHONE_CORDLESS      MinorDeviceClass = 0x02
+	MINOR_PHONE_SMARTPHONE    MinorDeviceClass = 0x03
+	MINOR_PHONE_MODEM         MinorDeviceClass = 0x04 // wired modem or voice gateway
+	MINOR_PHONE_ISDN          MinorDeviceClass = 0x05
+)
+
+// Minor device classes of MAJOR_NETWORK (utilization of the access point, bits 3..5 of the minor class)
+const (
+	MINOR_NETWORK_FULLY_AVAILABLE MinorDeviceClass = 0x00 << 3
+	MINOR_NETWORK_UTILIZED_1_17   MinorDeviceClass = 0x01 << 3
+	MINOR_NETWORK_UTILIZED_17_33  MinorDeviceClass = 0x02 << 3
+	MINOR_NETWORK_UTILIZED_33_50  MinorDeviceClass = 0x03 << 3
+	MINOR_NETWORK_UTILIZED_50_67  MinorDeviceClass = 0x04 << 3
+	MINOR_NETWORK_UTILIZED_67_83  MinorDeviceClass = 0x05 << 3
+	MINOR_NETWORK_UTILIZED_83_99  MinorDeviceClass = 0x06 << 3
+	MINOR_NETWORK_NO_SERVICE      MinorDeviceClass = 0x07 << 3
+)
+
+// Minor device classes of MAJOR_AUDIO_VIDEO
+const (
+	MINOR_AV_UNCATEGORIZED      MinorDeviceClass = 0x00
+	MINOR_AV_HEADSET            MinorDeviceClass = 0x01
+	MINOR_AV_HANDS_FREE         MinorDeviceClass = 0x02
+	MINOR_AV_MICROPHONE         MinorDeviceClass = 0x04
+	MINOR_AV_LOUDSPEAKER        MinorDeviceClass = 0x05
+	MINOR_AV_HEADPHONES         MinorDeviceClass = 0x06
+	MINOR_AV_PORTABLE_AUDIO     MinorDeviceClass = 0x07
+	MINOR_AV_CAR_AUDIO          MinorDeviceClass = 0x08
+	MINOR_AV_SET_TOP_BOX        MinorDeviceClass = 0x09
+	MINOR_AV_HIFI_AUDIO         MinorDeviceClass = 0x0a
+	MINOR_AV_VCR                MinorDeviceClass = 0x0b
+	MINOR_AV_VIDEO_CAMERA       MinorDeviceClass = 0x0c
+	MINOR_AV_CAMCORDER          MinorDeviceClass = 0x0d
+	MINOR_AV_VIDEO_MONITOR      MinorDeviceClass = 0x0e
+	MINOR_AV_VIDEO_DISPLAY      MinorDeviceClass = 0x0f // video display and loudspeaker
+	MINOR_AV_VIDEO_CONFERENCING MinorDeviceClass = 0x10
+	MINOR_AV_GAMING_TOY         MinorDeviceClass = 0x12
+)
+
+// Minor device classes of MAJOR_PERIPHERAL, a keyboard/pointing flag could be combined with a device type
+// (f.e. MINOR_PERIPHERAL_KEYBOARD|MINOR_PERIPHERAL_REMOTE_CONTROL)
+const (
+	MINOR_PERIPHERAL_KEYBOARD MinorDeviceClass = 0x10
+	MINOR_PERIPHERAL_POINTING MinorDeviceClass = 0x20 // mouse
+	MINOR_PERIPHERAL_COMBO    MinorDeviceClass = MINOR_PERIPHERAL_KEYBOARD | MINOR_PERIPHERAL_POINTING
+
+	MINOR_PERIPHERAL_UNCATEGORIZED    MinorDeviceClass = 0x00
+	MINOR_PERIPHERAL_JOYSTICK         MinorDeviceClass = 0x01
+	MINOR_PERIPHERAL_GAMEPAD          MinorDeviceClass = 0x02
+	MINOR_PERIPHERAL_REMOTE_CONTROL   MinorDeviceClass = 0x03
+	MINOR_PERIPHERAL_SENSING_DEVICE   MinorDeviceClass = 0x04
+	MINOR_PERIPHERAL_DIGITIZER_TABLET MinorDeviceClass = 0x05
+	MINOR_PERIPHERAL_CARD_READER      MinorDeviceClass = 0x06
+	MINOR_PERIPHERAL_DIGITAL_PEN      MinorDeviceClass = 0x07
+	MINOR_PERIPHERAL_HANDHELD_SCANNER MinorDeviceClass = 0x08
+	MINOR_PERIPHERAL_GESTURAL_INPUT   MinorDeviceClass = 0x09
+)
+
+// Minor device classes of MAJOR_IMAGING (bit mask, more than one could be set)
+const (
+	MINOR_IMAGING_DISPLAY MinorDeviceClass = 0x04
+	MINOR_IMAGING_CAMERA  MinorDeviceClass = 0x08
+	MINOR_IMAGING_SCANNER MinorDeviceClass = 0x10
+	MINOR_IMAGING_PRINTER MinorDeviceClass = 0x20
+)
+
+// Minor device classes of MAJOR_WEARABLE
+const (
+	MINOR_WEARABLE_WRISTWATCH MinorDeviceClass = 0x01
+	MINOR_WEARABLE_PAGER      MinorDeviceClass = 0x02
+	MINOR_WEARABLE_JACKET     MinorDeviceClass = 0x03
+	MINOR_WEARABLE_HELMET     MinorDeviceClass = 0x04
+	MINOR_WEARABLE_GLASSES    MinorDeviceClass = 0x05
+)
+
+// Minor device classes of MAJOR_TOY
+const (
+	MINOR_TOY_ROBOT      MinorDeviceClass = 0x01
+	MINOR_TOY_VEHICLE    MinorDeviceClass = 0x02
+	MINOR_TOY_DOLL       MinorDeviceClass = 0x03
+	MINOR_TOY_CONTROLLER MinorDeviceClass = 0x04
+	MINOR_TOY_GAME       MinorDeviceClass = 0x05
+)
+
+// Minor device classes of MAJOR_HEALTH
+const (
+	MINOR_HEALTH_UNDEFINED              MinorDeviceClass = 0x00
+	MINOR_HEALTH_BLOOD_PRESSURE         MinorDeviceClass = 0x01
+	MINOR_HEALTH_THERMOMETER            MinorDeviceClass = 0x02
+	MINOR_HEALTH_WEIGHING_SCALE         MinorDeviceClass = 0x03
+	MINOR_HEALTH_GLUCOSE_METER          MinorDeviceClass = 0x04
+	MINOR_HEALTH_PULSE_OXIMETER         MinorDeviceClass = 0x05
+	MINOR_HEALTH_HEART_RATE_MONITOR     MinorDeviceClass = 0x06
+	MINOR_HEALTH_DATA_DISPLAY           MinorDeviceClass = 0x07
+	MINOR_HEALTH_STEP_COUNTER           MinorDeviceClass = 0x08
+	MINOR_HEALTH_BODY_COMPOSITION       MinorDeviceClass = 0x09
+	MINOR_HEALTH_PEAK_FLOW_MONITOR      MinorDeviceClass = 0x0a
+	MINOR_HEALTH_MEDICATION_MONITOR     MinorDeviceClass = 0x0b
+	MINOR_HEALTH_KNEE_PROSTHESIS        MinorDeviceClass = 0x0c
+	MINOR_HEALTH_ANKLE_PROSTHESIS       MinorDeviceClass = 0x0d
+	MINOR_HEALTH_GENERIC_HEALTH_MANAGER MinorDeviceClass = 0x0e
+	MINOR_HEALTH_PERSONAL_MOBILITY      MinorDeviceClass = 0x0f
+)
+
+var imagingNameMap = map[MinorDeviceClass]string{
+	MINOR_IMAGING_DISPLAY: "Display",
+	MINOR_IMAGING_CAMERA:  "Camera",
+	MINOR_IMAGING_SCANNER: "Scanner",
+	MINOR_IMAGING_PRINTER: "Printer",
+}
+
+var minorNameMaps = genMinorNameMaps()
+
+// Names of enumerated minor classes, per major class. Peripheral, imaging and network are composed and
+// handled by minorString.
+func genMinorNameMaps() (nMaps map[MajorDeviceClass]map[MinorDeviceClass]string) {
+	nMaps = make(map[MajorDeviceClass]map[MinorDeviceClass]string)
+
+	computer := make(map[MinorDeviceClass]string)
+	computer[MINOR_COMPUTER_UNCATEGORIZED] = "Uncategorized"
+	computer[MINOR_COMPUTER_DESKTOP] = "Desktop Workstation"
+	computer[MINOR_COMPUTER_SERVER] = "Server-class Computer"
+	computer[MINOR_COMPUTER_LAPTOP] = "Laptop"
+	computer[MINOR_COMPUTER_HANDHELD] = "Handheld PC/PDA"
+	computer[MINOR_COMPUTER_PALM_SIZE] = "Palm-size PC/PDA"
+	computer[MINOR_COMPUTER_WEARABLE] = "Wearable Computer"
+	computer[MINOR_COMPUTER_TABLET] = "Tablet"
+	nMaps[MAJOR_COMPUTER] = computer
+
+	phone := make(map[MinorDeviceClass]string)
+	phone[MINOR_PHONE_UNCATEGORIZED] = "Uncategorized"
+	phone[MINOR_PHONE_CELLULAR] = "Cellular"
+	phone[MINOR_PHONE_CORDLESS] = "Cordless"
+	phone[MINOR_PHONE_SMARTPHONE] = "Smartphone"
+	phone[MINOR_PHONE_MODEM] = "Wired Modem or Voice Gateway"
+	phone[MINOR_PHONE_ISDN] = "Common ISDN Access"
+	nMaps[MAJOR_PHONE] = phone
+
+	av := make(map[MinorDeviceClass]string)
+	av[MINOR_AV_UNCATEGORIZED] = "Uncategorized"
+	av[MINOR_AV_HEADSET] = "Wearable Headset Device"
+	av[MINOR_AV_HANDS_FREE] = "Hands-free Device"
+	av[MINOR_AV_MICROPHONE] = "Microphone"
+	av[MINOR_AV_LOUDSPEAKER] = "Loudspeaker"
+	av[MINOR_AV_HEADPHONES] = "Headphones"
+	av[MINOR_AV_PORTABLE_AUDIO] = "Portable Audio"
+	av[MINOR_AV_CAR_AUDIO] = "Car Audio"
+	av[MINOR_AV_SET_TOP_BOX] = "Set-top Box"
+	av[MINOR_AV_HIFI_AUDIO] = "HiFi Audio Device"
+	av[MINOR_AV_VCR] = "VCR"
+	av[MINOR_AV_VIDEO_CAMERA] = "Video Camera"
+	av[MINOR_AV_CAMCORDER] = "Camcorder"
+	av[MINOR_AV_VIDEO_MONITOR] = "Video Monitor"
+	av[MINOR_AV_VIDEO_DISPLAY] = "Video Display and Loudspeaker"
+	av[MINOR_AV_VIDEO_CONFERENCING] = "Video Conferencing"
+	av[MINOR_AV_GAMING_TOY] = "Gaming/Toy"
+	nMaps[MAJOR_AUDIO_VIDEO] = av
+
+	peripheral := make(map[MinorDeviceClass]string)
+	peripheral[MINOR_PERIPHERAL_UNCATEGORIZED] = "Uncategorized"
+	peripheral[MINOR_PERIPHERAL_JOYSTICK] = "Joystick"
+	peripheral[MINOR_PERIPHERAL_GAMEPAD] = "Gamepad"
+	peripheral[MINOR_PERIPHERAL_REMOTE_CONTROL] = "Remote Control"
+	peripheral[MINOR_PERIPHERAL_SENSING_DEVICE] = "Sensing Device"
+	peripheral[MINOR_PERIPHERAL_DIGITIZER_TABLET] = "Digitizer Tablet"
+	peripheral[MINOR_PERIPHERAL_CARD_READER] = "Card Reader"
+	peripheral[MINOR_PERIPHERAL_DIGITAL_PEN] = "Digital Pen"
+	peripheral[MINOR_PERIPHERAL_HANDHELD_SCANNER] = "Handheld Scanner"
+	peripheral[MINOR_PERIPHERAL_GESTURAL_INPUT] = "Handheld Gestural Input Device"
+	nMaps[MAJOR_PERIPHERAL] = peripheral
+
+	wearable := make(map[MinorDeviceClass]string)
+	wearable[MINOR_WEARABLE_WRISTWATCH] = "Wristwatch"
+	wearable[MINOR_WEARABLE_PAGER] = "Pager"
+	wearable[MINOR_WEARABLE_JACKET] = "Jacket"
+	wearable[MINOR_WEARABLE_HELMET] = "Helmet"
+	wearable[MINOR_WEARABLE_GLASSES] = "Glasses"
+	nMaps[MAJOR_WEARABLE] = wearable
+
+	toy := make(map[MinorDeviceClass]string)
+	toy[MINOR_TOY_ROBOT] = "Robot"
+	toy[MINOR_TOY_VEHICLE] = "Vehicle"
+	toy[MINOR_TOY_DOLL] = "Doll/Action Figure"
+	toy[MINOR_TOY_CONTROLLER] = "Controller"
+	toy[MINOR_TOY_GAME] = "Game"
+	nMaps[MAJOR_TOY] = toy
+
+	health := make(map[MinorDeviceClass]string)
+	health[MINOR_HEALTH_UNDEFINED] = "Undefined"
+	health[MINOR_HEALTH_BLOOD_PRESSURE] = "Blood Pressure Monitor"
+	health[MINOR_HEALTH_THERMOMETER] = "Thermometer"
+	health[MINOR_HEALTH_WEIGHING_SCALE] = "Weighing Scale"
+	health[MINOR_HEALTH_GLUCOSE_METER] = "Glucose Meter"
+	health[MINOR_HEALTH_PULSE_OXIMETER] = "Pulse Oximeter"
+	health[MINOR_HEALTH_HEART_RATE_MONITOR] = "Heart/Pulse Rate Monitor"
+	health[MINOR_HEALTH_DATA_DISPLAY] = "Health Data Display"
+	health[MINOR_HEALTH_STEP_COUNTER] = "Step Counter"
+	health[MINOR_HEALTH_BODY_COMPOSITION] = "Body Composition Analyzer"
+	health[MINOR_HEALTH_PEAK_FLOW_MONITOR] = "Peak Flow Monitor"
+	health[MINOR_HEALTH_MEDICATION_MONITOR] = "Medication Monitor"
+	health[MINOR_HEALTH_KNEE_PROSTHESIS] = "Knee Prosthesis"
+	health[MINOR_HEALTH_ANKLE_PROSTHESIS] = "Ankle Prosthesis"
+	health[MINOR_HEALTH_GENERIC_HEALTH_MANAGER] = "Generic Health Manager"
+	health[MINOR_HEALTH_PERSONAL_MOBILITY] = "Personal Mobility Device"
+	nMaps[MAJOR_HEALTH] = health
+
+	return nMaps
+}
+
+// Builds a CoD from its parts, bits exceeding the respective field are dropped
+func NewCoD(services ServiceClass, major MajorDeviceClass, minor MinorDeviceClass) CoD {
+	return CoD(uint32(services&serviceClassMask) | uint32(major&0x1f)<<8 | uint32(minor&0x3f)<<2)
+}
+
+func (c CoD) ServiceClasses() ServiceClass {
+	return ServiceClass(c) & serviceClassMask
+}
+
+func (c CoD) MajorDeviceClass() MajorDeviceClass {
+	return MajorDeviceClass(c>>8) & 0x1f
+}
+
+func (c CoD) MinorDeviceClass() MinorDeviceClass {
+	return MinorDeviceClass(c>>2) & 0x3f
+}
+
+// Format type (bits 0..1), only 0x00 is defined
+func (c CoD) FormatType() byte {
+	return byte(c) & 0x03
+}
+
+// Returns true if the given service class bits are set
+func (c CoD) HasServiceClass(services ServiceClass) bool {
+	return c.ServiceClasses()&services == services
+}
+
+// Minor device class, as understood by the mgmt command Set Device Class (bits 2..7 of the lowest octet)
+func (c CoD) MinorOctet() byte {
+	return byte(c) & 0xfc
+}
+
+// Name of the minor device class, depending on the major device class
+func (c CoD) MinorDeviceClassString() string {
+	minor := c.MinorDeviceClass()
+	switch major := c.MajorDeviceClass(); major {
+	case MAJOR_NETWORK:
+		utilization := []string{"Fully available", "1-17% utilized", "17-33% utilized", "33-50% utilized",
+			"50-67% utilized", "67-83% utilized", "83-99% utilized", "No service available"}
+		return utilization[minor>>3&0x07]
+	case MAJOR_PERIPHERAL:
+		var parts []string
+		if minor&MINOR_PERIPHERAL_KEYBOARD != 0 {
+			parts = append(parts, "Keyboard")
+		}
+		if minor&MINOR_PERIPHERAL_POINTING != 0 {
+			parts = append(parts, "Pointing Device")
+		}
+		if subtype := minor & 0x0f; subtype != MINOR_PERIPHERAL_UNCATEGORIZED || len(parts) == 0 {
+			parts = append(parts, minorName(major, subtype))
+		}
+		return strings.Join(parts, "/")
+	case MAJOR_IMAGING:
+		var parts []string
+		for _, flag := range []MinorDeviceClass{MINOR_IMAGING_DISPLAY, MINOR_IMAGING_CAMERA, MINOR_IMAGING_SCANNER, MINOR_IMAGING_PRINTER} {
+			if minor&flag != 0 {
+				parts = append(parts, imagingNameMap[flag])
+			}
+		}
+		if len(parts) == 0 {
+			return "Uncategorized"
+		}
+		return strings.Join(parts, "/")
+	default:
+		return minorName(major, minor)
+	}
+}
+
+func minorName(major MajorDeviceClass, minor MinorDeviceClass) string {
+	if names, exists := minorNameMaps[major]; exists {
+		if name, exists := names[minor]; exists {
+			return name
+		}
+		return "Reserved"
+	}
+	if minor == 0 {
+		return "Uncategorized"
+	}
+	return "Unknown"
+}
+
+// F.e. "0x240404 (Audio/Video: Wearable Headset Device, services: Rendering|Audio)"
+func (c CoD) String() string {
+	res := fmt.Sprintf("0x%.6x (%s: %s", uint32(c), c.MajorDeviceClass(), c.MinorDeviceClassString())
+	if services := c.ServiceClasses(); services != 0 {
+		res += ", services: " + services.String()
+	}
+	return res + ")"
+}
+
+// Decodes the 3 octets (little endian) of the mgmt API
+func (c *CoD) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 3 {
+		return ErrPayloadFormat
+	}
+	*c = CoD(uint32(pay[0]) | uint32(pay[1])<<8 | uint32(pay[2])<<16)
+	return
+}
+
+// Encodes the CoD to 3 octets (little endian), as used by the mgmt API and EIR data
+func (c CoD) Payload() []byte {
+	return []byte{byte(c), byte(c >> 8), byte(c >> 16)}
+}
@@ -0,0 +1,71 @@
+package bt_cod
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	c := CoD(0x240404)
+	if major := c.MajorDeviceClass(); major != MAJOR_AUDIO_VIDEO {
+		t.Errorf("major %v, want %v", major, MAJOR_AUDIO_VIDEO)
+	}
+	if minor := c.MinorDeviceClass(); minor != MINOR_AV_HEADSET {
+		t.Errorf("minor %#x, want %#x", minor, MINOR_AV_HEADSET)
+	}
+	if services := c.ServiceClasses(); services != SERVICE_CLASS_RENDERING|SERVICE_CLASS_AUDIO {
+		t.Errorf("services %v, want Rendering|Audio", services)
+	}
+	if !c.HasServiceClass(SERVICE_CLASS_AUDIO) || c.HasServiceClass(SERVICE_CLASS_AUDIO|SERVICE_CLASS_TELEPHONY) {
+		t.Error("wrong HasServiceClass result")
+	}
+	if c.FormatType() != 0 || c.MinorOctet() != 0x04 {
+		t.Errorf("format type %d, minor octet %#x", c.FormatType(), c.MinorOctet())
+	}
+	if s := c.String(); s != "0x240404 (Audio/Video: Wearable Headset Device, services: Rendering|Audio)" {
+		t.Errorf("got %q", s)
+	}
+}
+
+func TestMinorDeviceClassString(t *testing.T) {
+	tests := []struct {
+		cod  CoD
+		want string
+	}{
+		{NewCoD(0, MAJOR_PERIPHERAL, MINOR_PERIPHERAL_COMBO|MINOR_PERIPHERAL_REMOTE_CONTROL), "Keyboard/Pointing Device/Remote Control"},
+		{NewCoD(0, MAJOR_PERIPHERAL, MINOR_PERIPHERAL_KEYBOARD), "Keyboard"},
+		{NewCoD(0, MAJOR_PERIPHERAL, MINOR_PERIPHERAL_UNCATEGORIZED), "Uncategorized"},
+		{NewCoD(0, MAJOR_IMAGING, MINOR_IMAGING_PRINTER|MINOR_IMAGING_SCANNER), "Scanner/Printer"},
+		{NewCoD(0, MAJOR_IMAGING, 0), "Uncategorized"},
+		{NewCoD(0, MAJOR_NETWORK, MINOR_NETWORK_NO_SERVICE), "No service available"},
+		{NewCoD(0, MAJOR_MISCELLANEOUS, 0), "Uncategorized"},
+	}
+	for _, test := range tests {
+		if got := test.cod.MinorDeviceClassString(); got != test.want {
+			t.Errorf("%#06x: got %q, want %q", uint32(test.cod), got, test.want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	c := NewCoD(SERVICE_CLASS_LIMITED_DISCOVERABLE, MAJOR_PERIPHERAL, MINOR_PERIPHERAL_COMBO|MINOR_PERIPHERAL_REMOTE_CONTROL)
+	if c != 0x0025cc {
+		t.Fatalf("got %#06x, want 0x0025cc", uint32(c))
+	}
+	// bits exceeding the fields are dropped
+	if d := NewCoD(0x1fff, 0xff, 0xff); d != 0x001ffc {
+		t.Errorf("got %#06x, want 0x001ffc", uint32(d))
+	}
+
+	pay := c.Payload()
+	if !bytes.Equal(pay, []byte{0xcc, 0x25, 0x00}) {
+		t.Errorf("got % x", pay)
+	}
+	var d CoD
+	if err := d.UpdateFromPayload(pay); err != nil || d != c {
+		t.Errorf("decoded as %#06x, %v", uint32(d), err)
+	}
+	if err := d.UpdateFromPayload(pay[:2]); err != ErrPayloadFormat {
+		t.Errorf("got %v, want %v", err, ErrPayloadFormat)
+	}
+}
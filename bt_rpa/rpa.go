@@ -0,0 +1,107 @@
+// Package bt_rpa generates and resolves LE resolvable private addresses (RPA) with the random address hash
+// function "ah" (see Bluetooth Core Specification Vol 3 Part H, 2.2.2).
+//
+// IRKs are given in the byte order used by the mgmt API and HCI (least significant octet first), as found in
+// btmgmt.IdentityResolvingKey.Value. Addresses are net.HardwareAddr in their usual notation (most
+// significant octet first).
+package bt_rpa
+
+import (
+	"crypto/aes"
+	"crypto/rand"
+	"errors"
+	"net"
+)
+
+var (
+	ErrNotResolvable = errors.New("Not a resolvable private address")
+)
+
+// Security function e, AES-128 with key and data in the byte order of the mgmt API (least significant octet
+// first), returns the result in the same order
+func e(key [16]byte, data [16]byte) (res [16]byte) {
+	k, d := reverse(key), reverse(data)
+	block, _ := aes.NewCipher(k[:]) // a 16 octet key never fails
+	block.Encrypt(res[:], d[:])
+	return reverse(res)
+}
+
+func reverse(in [16]byte) (out [16]byte) {
+	for i, v := range in {
+		out[15-i] = v
+	}
+	return
+}
+
+// Random address hash function ah, the 24 bit hash of prand (the lower 24 bits are used)
+func Ah(irk [16]byte, prand uint32) (hash uint32) {
+	var r [16]byte
+	r[0], r[1], r[2] = byte(prand), byte(prand>>8), byte(prand>>16)
+	res := e(irk, r)
+	return uint32(res[0]) | uint32(res[1])<<8 | uint32(res[2])<<16
+}
+
+// Returns true if the (random) address is a resolvable private address (two most significant bits 0b01)
+func IsResolvable(addr net.HardwareAddr) bool {
+	return len(addr) == 6 && addr[0]&0xc0 == 0x40
+}
+
+// Splits the address into prand (most significant 24 bits) and hash (least significant 24 bits)
+func split(addr net.HardwareAddr) (prand uint32, hash uint32) {
+	prand = uint32(addr[0])<<16 | uint32(addr[1])<<8 | uint32(addr[2])
+	hash = uint32(addr[3])<<16 | uint32(addr[4])<<8 | uint32(addr[5])
+	return
+}
+
+// Generates a new resolvable private address from the given IRK
+func GenerateRPA(irk [16]byte) (addr net.HardwareAddr, err error) {
+	prand := make([]byte, 3)
+	for {
+		if _, err = rand.Read(prand); err != nil {
+			return nil, err
+		}
+		prand[0] = prand[0]&0x3f | 0x40
+		// the random part of prand mustn't be all zero or all one
+		random := uint32(prand[0]&0x3f)<<16 | uint32(prand[1])<<8 | uint32(prand[2])
+		if random != 0 && random != 0x3fffff {
+			break
+		}
+	}
+	return RPAFromPrand(irk, uint32(prand[0])<<16|uint32(prand[1])<<8|uint32(prand[2]))
+}
+
+// Builds the resolvable private address for the given prand (24 bits, the two most significant bits have to
+// be 0b01)
+func RPAFromPrand(irk [16]byte, prand uint32) (addr net.HardwareAddr, err error) {
+	if prand>>22 != 0x01 {
+		return nil, ErrNotResolvable
+	}
+	hash := Ah(irk, prand)
+	return net.HardwareAddr{
+		byte(prand >> 16), byte(prand >> 8), byte(prand),
+		byte(hash >> 16), byte(hash >> 8), byte(hash),
+	}, nil
+}
+
+// Returns true if the address has been generated from the given IRK
+func Resolve(irk [16]byte, addr net.HardwareAddr) bool {
+	if !IsResolvable(addr) {
+		return false
+	}
+	prand, hash := split(addr)
+	return Ah(irk, prand) == hash
+}
+
+// Returns the index of the first IRK, which resolves the address
+func ResolveAny(irks [][16]byte, addr net.HardwareAddr) (index int, ok bool) {
+	if !IsResolvable(addr) {
+		return -1, false
+	}
+	prand, hash := split(addr)
+	for i, irk := range irks {
+		if Ah(irk, prand) == hash {
+			return i, true
+		}
+	}
+	return -1, false
+}
@@ -0,0 +1,60 @@
+package bt_rpa
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// IRK of the ah sample data (Core spec, Vol 3, Part H, Appendix D.7), converted from MSB first to mgmt byte order
+func sampleIRK(t *testing.T) (irk [16]byte) {
+	msb, err := hex.DecodeString("ec0234a357c8ad05341010a60a397d9b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range msb {
+		irk[15-i] = msb[i]
+	}
+	return
+}
+
+func TestAh(t *testing.T) {
+	if hash := Ah(sampleIRK(t), 0x708194); hash != 0x0dfbaa {
+		t.Fatalf("ah = %#06x, want 0x0dfbaa", hash)
+	}
+}
+
+func TestRPAFromPrand(t *testing.T) {
+	irk := sampleIRK(t)
+	addr, err := RPAFromPrand(irk, 0x708194)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "70:81:94:0d:fb:aa" {
+		t.Errorf("got %s, want 70:81:94:0d:fb:aa", addr)
+	}
+	if !Resolve(irk, addr) {
+		t.Error("address not resolved with its own IRK")
+	}
+	// the two most significant bits of prand have to be 0b01
+	if _, err = RPAFromPrand(irk, 0xc08194); err != ErrNotResolvable {
+		t.Errorf("got %v, want %v", err, ErrNotResolvable)
+	}
+}
+
+func TestGenerateAndResolve(t *testing.T) {
+	irk := sampleIRK(t)
+	other := irk
+	other[0] ^= 1
+	for i := 0; i < 100; i++ {
+		addr, err := GenerateRPA(irk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsResolvable(addr) || !Resolve(irk, addr) {
+			t.Fatalf("%s not resolvable", addr)
+		}
+		if idx, ok := ResolveAny([][16]byte{other, irk}, addr); !ok || idx != 1 {
+			t.Fatalf("ResolveAny = %d, %v", idx, ok)
+		}
+	}
+}
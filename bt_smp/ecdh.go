@@ -0,0 +1,105 @@
+package bt_smp
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Private key of the debug mode of LE Secure Connections (Vol 3 Part H, 2.3.5.6.1), most significant octet
+// first. Pairings using it can be decrypted by anyone, thus it must never be used outside of tests.
+const debugPrivateKey = "3f49f6d4a3c55f3874c9b3e3d2103f504aff607beb40b7995899b8a6cd3c1abd"
+
+// P-256 public key with X and Y coordinate least significant octet first, as in the Pairing Public Key PDU
+type PublicKey struct {
+	X [32]byte
+	Y [32]byte
+}
+
+// Parses the 64 octet payload of a Pairing Public Key PDU
+func (k *PublicKey) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 64 {
+		return ErrPayloadFormat
+	}
+	copy(k.X[:], pay[0:32])
+	copy(k.Y[:], pay[32:64])
+	return
+}
+
+func (k PublicKey) Payload() []byte {
+	return append(k.X[:], k.Y[:]...)
+}
+
+// Returns true if the key is the public key of the debug mode
+func (k PublicKey) IsDebugKey() bool {
+	return k == DebugKey().PublicKey
+}
+
+func (k PublicKey) toECDH() (*ecdh.PublicKey, error) {
+	uncompressed := append([]byte{0x04}, reverse(k.X[:])...)
+	uncompressed = append(uncompressed, reverse(k.Y[:])...)
+	pub, err := ecdh.P256().NewPublicKey(uncompressed)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+	return pub, nil
+}
+
+// P-256 key pair, D is the private key least significant octet first
+type PrivateKey struct {
+	D         [32]byte
+	PublicKey PublicKey
+}
+
+func newPrivateKey(key *ecdh.PrivateKey) (k *PrivateKey) {
+	k = &PrivateKey{}
+	copy(k.D[:], reverse(key.Bytes()))
+	pub := key.PublicKey().Bytes() // 0x04 || X || Y
+	copy(k.PublicKey.X[:], reverse(pub[1:33]))
+	copy(k.PublicKey.Y[:], reverse(pub[33:65]))
+	return
+}
+
+// Returns the key pair for the given private key
+func NewPrivateKey(d [32]byte) (k *PrivateKey, err error) {
+	key, err := ecdh.P256().NewPrivateKey(reverse(d[:]))
+	if err != nil {
+		return nil, ErrInvalidPrivateKey
+	}
+	return newPrivateKey(key), nil
+}
+
+// Generates a new random key pair
+func GenerateKey() (k *PrivateKey, err error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	return newPrivateKey(key), nil
+}
+
+// Returns the key pair of the debug mode of LE Secure Connections
+func DebugKey() *PrivateKey {
+	d, _ := hex.DecodeString(debugPrivateKey)
+	key, _ := ecdh.P256().NewPrivateKey(d)
+	return newPrivateKey(key)
+}
+
+// Computes the DHKey (X coordinate of the shared point, least significant octet first) with the public key
+// of the peer. Keys which aren't points on the curve are rejected with ErrInvalidPublicKey.
+func (k *PrivateKey) DHKey(peer PublicKey) (dhKey [32]byte, err error) {
+	key, err := ecdh.P256().NewPrivateKey(reverse(k.D[:]))
+	if err != nil {
+		return dhKey, ErrInvalidPrivateKey
+	}
+	pub, err := peer.toECDH()
+	if err != nil {
+		return
+	}
+	shared, err := key.ECDH(pub)
+	if err != nil {
+		return dhKey, ErrInvalidPublicKey
+	}
+	copy(dhKey[:], reverse(shared))
+	return
+}
@@ -0,0 +1,270 @@
+// Package bt_smp implements the cryptographic toolbox of the Security Manager Protocol (see Bluetooth Core
+// Specification Vol 3 Part H, 2.2): the LE legacy pairing functions c1 and s1, the LE Secure Connections
+// functions f4, f5, f6 and g2, the key conversion functions h6 and h7 and P-256 ECDH.
+//
+// All values are given in the byte order used by SMP PDUs, the mgmt API and HCI (least significant octet
+// first), as found in btmgmt.LongTermKey.Value or btmgmt.OOBData. The specification (and its sample data)
+// writes them most significant octet first, thus the sample values have to be reversed. Addresses are
+// net.HardwareAddr in their usual notation (most significant octet first).
+package bt_smp
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+var (
+	ErrPayloadFormat     = errors.New("Invalid payload format")
+	ErrInvalidAddress    = errors.New("Invalid address")
+	ErrInvalidPublicKey  = errors.New("Public key isn't a valid P-256 point")
+	ErrInvalidPrivateKey = errors.New("Invalid P-256 private key")
+)
+
+// Key IDs of h6 (ASCII, most significant octet first)
+const (
+	KEY_ID_TMP1 uint32 = 0x746d7031 // "tmp1", LTK to intermediate key
+	KEY_ID_TMP2 uint32 = 0x746d7032 // "tmp2", link key to intermediate key
+	KEY_ID_LEBR uint32 = 0x6c656272 // "lebr", intermediate key to link key
+	KEY_ID_BRLE uint32 = 0x62726c65 // "brle", intermediate key to LTK
+	KEY_ID_BTLE uint32 = 0x62746c65 // "btle", used by f5
+)
+
+var (
+	// SALT of f5 (0x6C888391AAF5A53860370BDB5A6083BE)
+	saltF5 = [16]byte{0xbe, 0x83, 0x60, 0x5a, 0xdb, 0x0b, 0x37, 0x60, 0x38, 0xa5, 0xf5, 0xaa, 0x91, 0x83, 0x88, 0x6c}
+	// SALTs of h7 used for cross-transport key derivation with CT2 set
+	SALT_TMP1 = [16]byte{0x31, 0x70, 0x6d, 0x74}
+	SALT_TMP2 = [16]byte{0x32, 0x70, 0x6d, 0x74}
+)
+
+// LE device address, as used by c1, f5 and f6
+type Address struct {
+	Addr   net.HardwareAddr
+	Random bool
+}
+
+// 56 bit representation (address type in the most significant octet), least significant octet first
+func (a Address) toPayload() (pay []byte, err error) {
+	if len(a.Addr) != 6 {
+		return nil, ErrInvalidAddress
+	}
+	pay = make([]byte, 7)
+	for i, b := range a.Addr {
+		pay[5-i] = b
+	}
+	if a.Random {
+		pay[6] = 0x01
+	}
+	return
+}
+
+func reverse(in []byte) (out []byte) {
+	out = make([]byte, len(in))
+	for i, v := range in {
+		out[len(in)-1-i] = v
+	}
+	return
+}
+
+// Security function e, AES-128 with key, data and result least significant octet first
+func e(key [16]byte, data [16]byte) (res [16]byte) {
+	block, _ := aes.NewCipher(reverse(key[:])) // a 16 octet key never fails
+	block.Encrypt(res[:], reverse(data[:]))
+	copy(res[:], reverse(res[:]))
+	return
+}
+
+// AES-CMAC (RFC 4493) with key, message and result most significant octet first
+func cmac(key []byte, m []byte) (mac [16]byte) {
+	block, _ := aes.NewCipher(key)
+	var k1, k2 [16]byte
+	block.Encrypt(k1[:], k1[:])
+	k1 = cmacSubkey(k1)
+	k2 = cmacSubkey(k1)
+
+	n := (len(m) + 15) / 16
+	last := make([]byte, 16)
+	if n > 0 && len(m)%16 == 0 {
+		copy(last, m[(n-1)*16:])
+		xor(last, k1[:])
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rem := m[(n-1)*16:]
+		copy(last, rem)
+		last[len(rem)] = 0x80
+		xor(last, k2[:])
+	}
+	for i := 0; i < n-1; i++ {
+		xor(mac[:], m[i*16:(i+1)*16])
+		block.Encrypt(mac[:], mac[:])
+	}
+	xor(mac[:], last)
+	block.Encrypt(mac[:], mac[:])
+	return
+}
+
+func cmacSubkey(l [16]byte) (k [16]byte) {
+	for i := 0; i < 15; i++ {
+		k[i] = l[i]<<1 | l[i+1]>>7
+	}
+	k[15] = l[15] << 1
+	if l[0]&0x80 != 0 {
+		k[15] ^= 0x87
+	}
+	return
+}
+
+func xor(dst []byte, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// AES-CMAC with key, message and result least significant octet first. As the whole message is reversed,
+// its parts have to be concatenated in reverse order.
+func aesCMAC(key [16]byte, m []byte) (mac [16]byte) {
+	mac = cmac(reverse(key[:]), reverse(m))
+	copy(mac[:], reverse(mac[:]))
+	return
+}
+
+// Confirm value generation function c1 of LE legacy pairing. preq and pres are the Pairing Request and
+// Pairing Response PDUs (including the opcode), ia and ra the addresses of initiator and responder.
+func C1(k [16]byte, r [16]byte, preq [7]byte, pres [7]byte, ia Address, ra Address) (res [16]byte, err error) {
+	iaPay, err := ia.toPayload()
+	if err != nil {
+		return
+	}
+	raPay, err := ra.toPayload()
+	if err != nil {
+		return
+	}
+	// p1 = pres || preq || rat' || iat'
+	var p1 [16]byte
+	p1[0], p1[1] = iaPay[6], raPay[6]
+	copy(p1[2:9], preq[:])
+	copy(p1[9:16], pres[:])
+	// p2 = padding || ia || ra
+	var p2 [16]byte
+	copy(p2[0:6], raPay[:6])
+	copy(p2[6:12], iaPay[:6])
+
+	xor(r[:], p1[:])
+	res = e(k, r)
+	xor(res[:], p2[:])
+	return e(k, res), nil
+}
+
+// Key generation function s1 of LE legacy pairing, returns the STK for the TK k and the random values
+// r1 (initiator) and r2 (responder)
+func S1(k [16]byte, r1 [16]byte, r2 [16]byte) [16]byte {
+	// r' = r1' || r2', with the least significant 64 bits of r1 and r2
+	var r [16]byte
+	copy(r[0:8], r2[0:8])
+	copy(r[8:16], r1[0:8])
+	return e(k, r)
+}
+
+// Confirm value generation function f4 of LE Secure Connections, u and v are X coordinates of public keys,
+// x is a random value and z zero or the passkey bit
+func F4(u [32]byte, v [32]byte, x [16]byte, z byte) [16]byte {
+	m := append([]byte{z}, v[:]...)
+	m = append(m, u[:]...)
+	return aesCMAC(x, m)
+}
+
+// Key generation function f5 of LE Secure Connections, derives the MacKey and the LTK from the DHKey w,
+// the random values of initiator and responder n1, n2 and their addresses a1, a2.
+func F5(w [32]byte, n1 [16]byte, n2 [16]byte, a1 Address, a2 Address) (macKey [16]byte, ltk [16]byte, err error) {
+	a1Pay, err := a1.toPayload()
+	if err != nil {
+		return
+	}
+	a2Pay, err := a2.toPayload()
+	if err != nil {
+		return
+	}
+	t := aesCMAC(saltF5, w[:])
+
+	// Counter || keyID || N1 || N2 || A1 || A2 || Length
+	m := []byte{0x00, 0x01} // Length 256
+	m = append(m, a2Pay...)
+	m = append(m, a1Pay...)
+	m = append(m, n2[:]...)
+	m = append(m, n1[:]...)
+	m = binary.LittleEndian.AppendUint32(m, KEY_ID_BTLE)
+	macKey = aesCMAC(t, append(m, 0x00))
+	ltk = aesCMAC(t, append(m, 0x01))
+	return
+}
+
+// Check value generation function f6 of LE Secure Connections. ioCap holds the IO Capability, OOB data flag
+// and AuthReq octets of the Pairing Request (or Response), in PDU order.
+func F6(w [16]byte, n1 [16]byte, n2 [16]byte, r [16]byte, ioCap [3]byte, a1 Address, a2 Address) (res [16]byte, err error) {
+	a1Pay, err := a1.toPayload()
+	if err != nil {
+		return
+	}
+	a2Pay, err := a2.toPayload()
+	if err != nil {
+		return
+	}
+	// N1 || N2 || R || IOcap || A1 || A2
+	m := append(a2Pay, a1Pay...)
+	m = append(m, ioCap[:]...)
+	m = append(m, r[:]...)
+	m = append(m, n2[:]...)
+	m = append(m, n1[:]...)
+	return aesCMAC(w, m), nil
+}
+
+// Numeric comparison value generation function g2 of LE Secure Connections, the value displayed to the user
+// is the result modulo 1000000 (see NumericComparisonValue)
+func G2(u [32]byte, v [32]byte, x [16]byte, y [16]byte) uint32 {
+	m := append(y[:], v[:]...)
+	m = append(m, u[:]...)
+	res := aesCMAC(x, m)
+	return binary.LittleEndian.Uint32(res[0:4])
+}
+
+// Returns the six digit value displayed for numeric comparison
+func NumericComparisonValue(u [32]byte, v [32]byte, x [16]byte, y [16]byte) uint32 {
+	return G2(u, v, x, y) % 1000000
+}
+
+// Link key conversion function h6, keyID is one of KEY_ID_*
+func H6(w [16]byte, keyID uint32) [16]byte {
+	return aesCMAC(w, binary.LittleEndian.AppendUint32(nil, keyID))
+}
+
+// Link key conversion function h7, salt is one of SALT_TMP1 or SALT_TMP2
+func H7(salt [16]byte, w [16]byte) [16]byte {
+	return aesCMAC(salt, w[:])
+}
+
+// Derives the BR/EDR link key from an LE LTK (cross-transport key derivation), ct2 is the CT2 bit of the
+// AuthReq exchanged while pairing (h7 is used instead of h6 for the intermediate key if both sides set it)
+func LinkKeyFromLTK(ltk [16]byte, ct2 bool) [16]byte {
+	var ilk [16]byte
+	if ct2 {
+		ilk = H7(SALT_TMP1, ltk)
+	} else {
+		ilk = H6(ltk, KEY_ID_TMP1)
+	}
+	return H6(ilk, KEY_ID_LEBR)
+}
+
+// Derives the LE LTK from a BR/EDR link key (cross-transport key derivation), see LinkKeyFromLTK
+func LTKFromLinkKey(linkKey [16]byte, ct2 bool) [16]byte {
+	var ilk [16]byte
+	if ct2 {
+		ilk = H7(SALT_TMP2, linkKey)
+	} else {
+		ilk = H6(linkKey, KEY_ID_TMP2)
+	}
+	return H6(ilk, KEY_ID_BRLE)
+}
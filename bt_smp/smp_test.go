@@ -0,0 +1,184 @@
+package bt_smp
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+// The sample data of the Core spec (Vol 3, Part H, Appendix D) is given MSB first, while the functions of this
+// package use the little endian byte order of SMP PDUs and mgmt commands.
+
+func lsb(t *testing.T, msb string) []byte {
+	b, err := hex.DecodeString(msb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reverse(b)
+}
+
+func lsb16(t *testing.T, msb string) (res [16]byte) {
+	copy(res[:], lsb(t, msb))
+	return
+}
+
+func lsb32(t *testing.T, msb string) (res [32]byte) {
+	copy(res[:], lsb(t, msb))
+	return
+}
+
+func expectMSB(t *testing.T, name string, got []byte, exp string) {
+	t.Helper()
+	if msb := hex.EncodeToString(reverse(got)); msb != exp {
+		t.Errorf("%s = %s, want %s", name, msb, exp)
+	}
+}
+
+// sample data shared by f4, f5, f6 and g2 (Appendix D.2 - D.5)
+const (
+	sampleU  = "20b003d2f297be2c5e2c83a7e9f9a5b9eff49111acf4fddbcc0301480e359de6"
+	sampleV  = "55188b3d32f6bb9a900afcfbeed4e72a59cb9ac2f19d7cfb6b4fdd49f47fc5fd"
+	sampleX  = "d5cb8454d177733effffb2ec712baeab"
+	sampleN2 = "a6e8e7cc25a75f6e216583f7ff3dc4cf"
+	sampleW  = "ec0234a357c8ad05341010a60a397d9b99796b13b4f866f1868d34f373bfa698"
+)
+
+var (
+	sampleA1 = Address{Addr: net.HardwareAddr{0x56, 0x12, 0x37, 0x37, 0xbf, 0xce}}
+	sampleA2 = Address{Addr: net.HardwareAddr{0xa7, 0x13, 0x70, 0x2d, 0xcf, 0xc1}}
+)
+
+// RFC 4493, section 4
+func TestCMAC(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51" +
+		"30c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	for _, c := range []struct {
+		msgLen int
+		exp    string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		mac := cmac(key, msg[:c.msgLen])
+		if got := hex.EncodeToString(mac[:]); got != c.exp {
+			t.Errorf("CMAC of %d bytes = %s, want %s", c.msgLen, got, c.exp)
+		}
+	}
+}
+
+func TestC1(t *testing.T) {
+	var preq, pres [7]byte
+	copy(preq[:], lsb(t, "07071000000101"))
+	copy(pres[:], lsb(t, "05000800000302"))
+	ia := Address{Addr: net.HardwareAddr{0xa1, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6}, Random: true}
+	ra := Address{Addr: net.HardwareAddr{0xb1, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6}}
+	res, err := C1([16]byte{}, lsb16(t, "5783d52156ad6f0e6388274ec6702ee0"), preq, pres, ia, ra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMSB(t, "c1", res[:], "1e1e3fef878988ead2a74dc5bef13b86")
+}
+
+func TestS1(t *testing.T) {
+	res := S1([16]byte{}, lsb16(t, "000f0e0d0c0b0a091122334455667788"), lsb16(t, "010203040506070899aabbccddeeff00"))
+	expectMSB(t, "s1", res[:], "9a1fe1f0e8b0f49b5b4216ae796da062")
+}
+
+func TestF4(t *testing.T) {
+	res := F4(lsb32(t, sampleU), lsb32(t, sampleV), lsb16(t, sampleX), 0)
+	expectMSB(t, "f4", res[:], "f2c916f107a9bd1cf1eda1bea974872d")
+}
+
+func TestF5(t *testing.T) {
+	macKey, ltk, err := F5(lsb32(t, sampleW), lsb16(t, sampleX), lsb16(t, sampleN2), sampleA1, sampleA2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMSB(t, "f5 MacKey", macKey[:], "2965f176a1084a02fd3f6a20ce636e20")
+	expectMSB(t, "f5 LTK", ltk[:], "6986791169d7cd23980522b594750a38")
+}
+
+func TestF6(t *testing.T) {
+	var ioCap [3]byte
+	copy(ioCap[:], lsb(t, "010102"))
+	res, err := F6(lsb16(t, "2965f176a1084a02fd3f6a20ce636e20"), lsb16(t, sampleX), lsb16(t, sampleN2),
+		lsb16(t, "12a3343bb453bb5408da42d20c2d0fc8"), ioCap, sampleA1, sampleA2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMSB(t, "f6", res[:], "e3c473989cd0e8c5d26c0b09da958f61")
+}
+
+func TestG2(t *testing.T) {
+	if res := G2(lsb32(t, sampleU), lsb32(t, sampleV), lsb16(t, sampleX), lsb16(t, sampleN2)); res != 0x2f9ed5ba {
+		t.Errorf("g2 = %#08x, want 0x2f9ed5ba", res)
+	}
+}
+
+func TestH6(t *testing.T) {
+	res := H6(lsb16(t, "ec0234a357c8ad05341010a60a397d9b"), 0x6c656272) // "lebr"
+	expectMSB(t, "h6", res[:], "2d9ae102e76dc91ce8d3a9e280b16399")
+}
+
+func TestH7(t *testing.T) {
+	res := H7(SALT_TMP1, lsb16(t, "ec0234a357c8ad05341010a60a397d9b"))
+	expectMSB(t, "h7", res[:], "fb173597c6a3c0ecd2998c2a75a57011")
+}
+
+// the P-256 sample data of Vol 3, Part H, section 2.3.5.6.1 (debug key) and Appendix D.1
+func TestDebugKey(t *testing.T) {
+	debug := DebugKey()
+	expectMSB(t, "debug public key X", debug.PublicKey.X[:], sampleU)
+	expectMSB(t, "debug public key Y", debug.PublicKey.Y[:], "dc809c49652aeb6d63329abf5a52155c766345c28fed3024741c8ed01589d28b")
+	if !debug.PublicKey.IsDebugKey() {
+		t.Error("debug public key not recognised")
+	}
+
+	keyB, err := NewPrivateKey(lsb32(t, "55188b3d32f6bb9a900afcfbeed4e72a59cb9ac2f19d7cfb6b4fdd49f47fc5fd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMSB(t, "public key X of B", keyB.PublicKey.X[:], "1ea1f0f01faf1d9609592284f19e4c0047b58afd8615a69f559077b22faaa190")
+	dhKey, err := debug.DHKey(keyB.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMSB(t, "DHKey", dhKey[:], sampleW)
+}
+
+func TestDHKey(t *testing.T) {
+	a, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dhA, err := a.DHKey(b.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dhB, err := b.DHKey(a.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dhA != dhB {
+		t.Error("DHKeys of both sides differ")
+	}
+
+	var parsed PublicKey
+	if err = parsed.UpdateFromPayload(a.PublicKey.Payload()); err != nil || parsed != a.PublicKey {
+		t.Errorf("public key payload round trip failed: %v", err)
+	}
+
+	// invalid curve attack (CVE-2018-5383), the peer key has to be a point on the curve
+	invalid := b.PublicKey
+	invalid.Y[0] ^= 1
+	if _, err = a.DHKey(invalid); err != ErrInvalidPublicKey {
+		t.Errorf("got %v, want %v", err, ErrInvalidPublicKey)
+	}
+}
@@ -0,0 +1,563 @@
+package btmgmt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mame82/mblue-toolz/bt_ad"
+)
+
+var (
+	ErrAdvertisingDataLength = errors.New("Advertising data or scan response exceeds 255 bytes")
+)
+
+// Flags of an advertising instance, as used by Add Advertising, Add Extended Advertising Parameters,
+// Get Advertising Size Information and the supported flags of Read Advertising Features
+type AdvertisingFlags uint32
+
+const (
+	ADV_FLAG_CONNECTABLE          AdvertisingFlags = 1 << 0
+	ADV_FLAG_DISCOVERABLE         AdvertisingFlags = 1 << 1
+	ADV_FLAG_LIMITED_DISCOVERABLE AdvertisingFlags = 1 << 2
+	ADV_FLAG_MANAGED_FLAGS        AdvertisingFlags = 1 << 3  // kernel adds the flags field to the advertising data
+	ADV_FLAG_TX_POWER             AdvertisingFlags = 1 << 4  // kernel adds the TX power field to the advertising data
+	ADV_FLAG_APPEARANCE           AdvertisingFlags = 1 << 5  // kernel adds the appearance field to the scan response
+	ADV_FLAG_LOCAL_NAME           AdvertisingFlags = 1 << 6  // kernel adds the local name to the scan response
+	ADV_FLAG_SEC_1M               AdvertisingFlags = 1 << 7  // secondary channel with LE 1M PHY
+	ADV_FLAG_SEC_2M               AdvertisingFlags = 1 << 8  // secondary channel with LE 2M PHY
+	ADV_FLAG_SEC_CODED            AdvertisingFlags = 1 << 9  // secondary channel with LE Coded PHY
+	ADV_FLAG_CAN_SET_TX_POWER     AdvertisingFlags = 1 << 10 // supported flags only
+	ADV_FLAG_HW_OFFLOAD           AdvertisingFlags = 1 << 11 // supported flags only
+
+	// only used by Add Extended Advertising Parameters, mark the respective parameter as valid
+	ADV_PARAM_DURATION  AdvertisingFlags = 1 << 12
+	ADV_PARAM_TIMEOUT   AdvertisingFlags = 1 << 13
+	ADV_PARAM_INTERVALS AdvertisingFlags = 1 << 14
+	ADV_PARAM_TX_POWER  AdvertisingFlags = 1 << 15
+	ADV_PARAM_SCAN_RSP  AdvertisingFlags = 1 << 16 // scannable, even if the scan response data is empty
+
+	advParamFlags = ADV_PARAM_DURATION | ADV_PARAM_TIMEOUT | ADV_PARAM_INTERVALS | ADV_PARAM_TX_POWER | ADV_PARAM_SCAN_RSP
+)
+
+var advertisingFlagNameMap = genAdvertisingFlagNameMap()
+
+func genAdvertisingFlagNameMap() (nMap map[AdvertisingFlags]string) {
+	nMap = make(map[AdvertisingFlags]string)
+	nMap[ADV_FLAG_CONNECTABLE] = "Connectable"
+	nMap[ADV_FLAG_DISCOVERABLE] = "Discoverable"
+	nMap[ADV_FLAG_LIMITED_DISCOVERABLE] = "Limited Discoverable"
+	nMap[ADV_FLAG_MANAGED_FLAGS] = "Managed Flags"
+	nMap[ADV_FLAG_TX_POWER] = "TX Power"
+	nMap[ADV_FLAG_APPEARANCE] = "Appearance"
+	nMap[ADV_FLAG_LOCAL_NAME] = "Local Name"
+	nMap[ADV_FLAG_SEC_1M] = "Secondary LE 1M"
+	nMap[ADV_FLAG_SEC_2M] = "Secondary LE 2M"
+	nMap[ADV_FLAG_SEC_CODED] = "Secondary LE Coded"
+	nMap[ADV_FLAG_CAN_SET_TX_POWER] = "Can Set TX Power"
+	nMap[ADV_FLAG_HW_OFFLOAD] = "HW Offload"
+	nMap[ADV_PARAM_DURATION] = "Duration Param"
+	nMap[ADV_PARAM_TIMEOUT] = "Timeout Param"
+	nMap[ADV_PARAM_INTERVALS] = "Intervals Param"
+	nMap[ADV_PARAM_TX_POWER] = "TX Power Param"
+	nMap[ADV_PARAM_SCAN_RSP] = "Scan Response Param"
+	return nMap
+}
+
+// Names of all set flags, separated by "|"
+func (f AdvertisingFlags) String() string {
+	var names []string
+	for bit := uint(0); bit < 32; bit++ {
+		if f&(1<<bit) == 0 {
+			continue
+		}
+		if name, exists := advertisingFlagNameMap[AdvertisingFlags(1<<bit)]; exists {
+			names = append(names, name)
+		} else {
+			names = append(names, "Unknown")
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// TX power value, if the host has no preference (Add Extended Advertising Parameters) or the power
+// is unknown
+const ADV_TX_POWER_NO_PREFERENCE int8 = 127
+
+// Unit of the advertising intervals
+const ADV_INTERVAL_UNIT = 625 * time.Microsecond
+
+type AdvertisingFeatures struct {
+	SupportedFlags  AdvertisingFlags
+	MaxAdvDataLen   byte
+	MaxScanRspLen   byte
+	MaxInstances    byte
+	ActiveInstances []byte
+}
+
+func (af *AdvertisingFeatures) UpdateFromPayload(p []byte) (err error) {
+	if len(p) < 8 {
+		return ErrPayloadFormat
+	}
+	af.SupportedFlags = AdvertisingFlags(binary.LittleEndian.Uint32(p[0:4]))
+	af.MaxAdvDataLen = p[4]
+	af.MaxScanRspLen = p[5]
+	af.MaxInstances = p[6]
+	num := int(p[7])
+	if len(p) != 8+num {
+		return ErrPayloadFormat
+	}
+	af.ActiveInstances = append([]byte{}, p[8:]...)
+	return
+}
+
+type AdvertisingSizeInformation struct {
+	Instance      byte
+	Flags         AdvertisingFlags
+	MaxAdvDataLen byte // space left for own advertising data, with the fields added by the kernel for Flags
+	MaxScanRspLen byte // space left for own scan response data, with the fields added by the kernel for Flags
+}
+
+func (si *AdvertisingSizeInformation) UpdateFromPayload(p []byte) (err error) {
+	if len(p) != 7 {
+		return ErrPayloadFormat
+	}
+	si.Instance = p[0]
+	si.Flags = AdvertisingFlags(binary.LittleEndian.Uint32(p[1:5]))
+	si.MaxAdvDataLen = p[5]
+	si.MaxScanRspLen = p[6]
+	return
+}
+
+// Result of Add Extended Advertising Parameters
+type ExtAdvertisingParametersResult struct {
+	Instance      byte
+	TxPower       int8 // selected by the controller
+	MaxAdvDataLen byte
+	MaxScanRspLen byte
+}
+
+func (r *ExtAdvertisingParametersResult) UpdateFromPayload(p []byte) (err error) {
+	if len(p) != 4 {
+		return ErrPayloadFormat
+	}
+	r.Instance = p[0]
+	r.TxPower = int8(p[1])
+	r.MaxAdvDataLen = p[2]
+	r.MaxScanRspLen = p[3]
+	return
+}
+
+// Advertising instance, as added by AddAdvertising or AddExtAdvertisingParameters/AddExtAdvertisingData.
+// Could be built with NewAdvertisement and the chainable setters, f.e.:
+//
+//	adv := NewAdvertisement(1).Connectable().Discoverable().WithTimeout(time.Minute).WithAdvData(data)
+//
+// Duration and Timeout have a resolution of one second. A zero Duration selects the default duration,
+// a zero Timeout keeps the instance till it is removed.
+type Advertisement struct {
+	Instance    byte // 1 .. MaxInstances of AdvertisingFeatures
+	Flags       AdvertisingFlags
+	Duration    time.Duration // time the instance is advertised, before switching to the next instance
+	Timeout     time.Duration // the instance is removed after this time
+	MinInterval time.Duration // extended advertising only
+	MaxInterval time.Duration // extended advertising only
+	TxPower     int8          // extended advertising only, dBm
+	AdvData     []byte        // EIR formatted advertising data
+	ScanRsp     []byte        // EIR formatted scan response data
+
+	err error // encoding error of WithAD / WithScanRspAD, returned when the advertisement is added
+}
+
+func NewAdvertisement(instance byte) *Advertisement {
+	return &Advertisement{
+		Instance: instance,
+		TxPower:  ADV_TX_POWER_NO_PREFERENCE,
+	}
+}
+
+func (a *Advertisement) Connectable() *Advertisement {
+	a.Flags |= ADV_FLAG_CONNECTABLE
+	return a
+}
+
+// The kernel adds a flags field with "LE General Discoverable Mode" to the advertising data
+func (a *Advertisement) Discoverable() *Advertisement {
+	a.Flags |= ADV_FLAG_DISCOVERABLE
+	return a
+}
+
+// The kernel adds a flags field with "LE Limited Discoverable Mode" to the advertising data
+func (a *Advertisement) LimitedDiscoverable() *Advertisement {
+	a.Flags |= ADV_FLAG_LIMITED_DISCOVERABLE
+	return a
+}
+
+// The kernel adds a flags field, matching the current discoverable setting of the controller
+func (a *Advertisement) ManagedFlags() *Advertisement {
+	a.Flags |= ADV_FLAG_MANAGED_FLAGS
+	return a
+}
+
+// The kernel adds the TX power to the advertising data
+func (a *Advertisement) IncludeTxPower() *Advertisement {
+	a.Flags |= ADV_FLAG_TX_POWER
+	return a
+}
+
+// The kernel adds the appearance to the scan response
+func (a *Advertisement) IncludeAppearance() *Advertisement {
+	a.Flags |= ADV_FLAG_APPEARANCE
+	return a
+}
+
+// The kernel adds the local name to the scan response
+func (a *Advertisement) IncludeLocalName() *Advertisement {
+	a.Flags |= ADV_FLAG_LOCAL_NAME
+	return a
+}
+
+// Sets additional flags (f.e. the secondary PHY for extended advertising)
+func (a *Advertisement) WithFlags(flags AdvertisingFlags) *Advertisement {
+	a.Flags |= flags
+	return a
+}
+
+func (a *Advertisement) WithDuration(duration time.Duration) *Advertisement {
+	a.Duration = duration
+	a.Flags |= ADV_PARAM_DURATION
+	return a
+}
+
+func (a *Advertisement) WithTimeout(timeout time.Duration) *Advertisement {
+	a.Timeout = timeout
+	a.Flags |= ADV_PARAM_TIMEOUT
+	return a
+}
+
+// Advertising interval range, rounded down to multiples of ADV_INTERVAL_UNIT (extended advertising only)
+func (a *Advertisement) WithInterval(minInterval time.Duration, maxInterval time.Duration) *Advertisement {
+	a.MinInterval = minInterval
+	a.MaxInterval = maxInterval
+	a.Flags |= ADV_PARAM_INTERVALS
+	return a
+}
+
+// Requested TX power in dBm (extended advertising only), the controller reports the power in use
+func (a *Advertisement) WithTxPower(dBm int8) *Advertisement {
+	a.TxPower = dBm
+	a.Flags |= ADV_PARAM_TX_POWER
+	return a
+}
+
+func (a *Advertisement) WithAdvData(data []byte) *Advertisement {
+	a.AdvData = data
+	return a
+}
+
+// Sets the scan response data, which makes the advertisement scannable
+func (a *Advertisement) WithScanRsp(data []byte) *Advertisement {
+	a.ScanRsp = data
+	a.Flags |= ADV_PARAM_SCAN_RSP
+	return a
+}
+
+// Like WithAdvData, but encodes the given AD structures. The length is checked by the kernel, as the space
+// left depends on the flags (see GetAdvertisingSizeInformation).
+func (a *Advertisement) WithAD(ad *bt_ad.AdvertisingData) *Advertisement {
+	data, err := ad.Payload(0)
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	return a.WithAdvData(data)
+}
+
+// Like WithScanRsp, but encodes the given AD structures
+func (a *Advertisement) WithScanRspAD(ad *bt_ad.AdvertisingData) *Advertisement {
+	data, err := ad.Payload(0)
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	return a.WithScanRsp(data)
+}
+
+func durationSeconds(d time.Duration) []byte {
+	secs := d / time.Second
+	if secs > 0xffff {
+		secs = 0xffff
+	}
+	pay := make([]byte, 2)
+	binary.LittleEndian.PutUint16(pay, uint16(secs))
+	return pay
+}
+
+func intervalUnits(d time.Duration) []byte {
+	pay := make([]byte, 4)
+	binary.LittleEndian.PutUint32(pay, uint32(d/ADV_INTERVAL_UNIT))
+	return pay
+}
+
+func advDataPayload(advData []byte, scanRsp []byte) (pay []byte, err error) {
+	if len(advData) > 255 || len(scanRsp) > 255 {
+		return nil, ErrAdvertisingDataLength
+	}
+	pay = []byte{byte(len(advData)), byte(len(scanRsp))}
+	pay = append(pay, advData...)
+	return append(pay, scanRsp...), nil
+}
+
+func parseInstanceResult(payload []byte) (instance byte, err error) {
+	if len(payload) != 1 {
+		return 0, ErrPayloadFormat
+	}
+	return payload[0], nil
+}
+
+func (bm BtMgmt) ReadAdvertisingFeatures(controllerID uint16) (res *AdvertisingFeatures, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.ReadAdvertisingFeaturesContext(ctx, controllerID)
+}
+
+func (bm BtMgmt) ReadAdvertisingFeaturesContext(ctx context.Context, controllerID uint16) (res *AdvertisingFeatures, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_READ_ADVERTISING_FEATURES)
+	if err != nil {
+		return
+	}
+	res = &AdvertisingFeatures{}
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Adds (or replaces) a legacy advertising instance. Interval and TX power of the advertisement are ignored,
+// as are the ADV_PARAM_... flags. Returns the instance.
+func (bm BtMgmt) AddAdvertising(controllerID uint16, adv *Advertisement) (instance byte, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.AddAdvertisingContext(ctx, controllerID, adv)
+}
+
+func (bm BtMgmt) AddAdvertisingContext(ctx context.Context, controllerID uint16, adv *Advertisement) (instance byte, err error) {
+	if adv.err != nil {
+		return 0, adv.err
+	}
+	data, err := advDataPayload(adv.AdvData, adv.ScanRsp)
+	if err != nil {
+		return
+	}
+	params := []byte{adv.Instance, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(params[1:5], uint32(adv.Flags&^advParamFlags))
+	params = append(params, durationSeconds(adv.Duration)...)
+	params = append(params, durationSeconds(adv.Timeout)...)
+	params = append(params, data...)
+	payload, err := bm.runCmd(ctx, controllerID, CMD_ADD_ADVERTISING, params...)
+	if err != nil {
+		return
+	}
+	return parseInstanceResult(payload)
+}
+
+// Removes the given advertising instance (all instances for instance 0), returns the instance
+func (bm BtMgmt) RemoveAdvertising(controllerID uint16, instance byte) (res byte, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.RemoveAdvertisingContext(ctx, controllerID, instance)
+}
+
+func (bm BtMgmt) RemoveAdvertisingContext(ctx context.Context, controllerID uint16, instance byte) (res byte, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_REMOVE_ADVERTISING, instance)
+	if err != nil {
+		return
+	}
+	return parseInstanceResult(payload)
+}
+
+// Returns the space left for advertising data and scan response of an instance with the given flags
+func (bm BtMgmt) GetAdvertisingSizeInformation(controllerID uint16, instance byte, flags AdvertisingFlags) (res *AdvertisingSizeInformation, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.GetAdvertisingSizeInformationContext(ctx, controllerID, instance, flags)
+}
+
+func (bm BtMgmt) GetAdvertisingSizeInformationContext(ctx context.Context, controllerID uint16, instance byte, flags AdvertisingFlags) (res *AdvertisingSizeInformation, err error) {
+	params := []byte{instance, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(params[1:5], uint32(flags))
+	payload, err := bm.runCmd(ctx, controllerID, CMD_GET_ADVERTISING_SIZE_INFORMATION, params...)
+	if err != nil {
+		return
+	}
+	res = &AdvertisingSizeInformation{}
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// First step of adding an extended advertising instance: registers the parameters (flags, duration, timeout,
+// interval, TX power) of the advertisement. The data has to be set with AddExtAdvertisingData afterwards,
+// which enables the instance.
+func (bm BtMgmt) AddExtAdvertisingParameters(controllerID uint16, adv *Advertisement) (res *ExtAdvertisingParametersResult, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.AddExtAdvertisingParametersContext(ctx, controllerID, adv)
+}
+
+func (bm BtMgmt) AddExtAdvertisingParametersContext(ctx context.Context, controllerID uint16, adv *Advertisement) (res *ExtAdvertisingParametersResult, err error) {
+	params := []byte{adv.Instance, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(params[1:5], uint32(adv.Flags))
+	params = append(params, durationSeconds(adv.Duration)...)
+	params = append(params, durationSeconds(adv.Timeout)...)
+	params = append(params, intervalUnits(adv.MinInterval)...)
+	params = append(params, intervalUnits(adv.MaxInterval)...)
+	params = append(params, byte(adv.TxPower))
+	payload, err := bm.runCmd(ctx, controllerID, CMD_ADD_EXT_ADVERTISING_PARAMETERS, params...)
+	if err != nil {
+		return
+	}
+	res = &ExtAdvertisingParametersResult{}
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Second step of adding an extended advertising instance, sets advertising data and scan response of an
+// instance registered with AddExtAdvertisingParameters. Returns the instance.
+func (bm BtMgmt) AddExtAdvertisingData(controllerID uint16, instance byte, advData []byte, scanRsp []byte) (res byte, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.AddExtAdvertisingDataContext(ctx, controllerID, instance, advData, scanRsp)
+}
+
+func (bm BtMgmt) AddExtAdvertisingDataContext(ctx context.Context, controllerID uint16, instance byte, advData []byte, scanRsp []byte) (res byte, err error) {
+	data, err := advDataPayload(advData, scanRsp)
+	if err != nil {
+		return
+	}
+	payload, err := bm.runCmd(ctx, controllerID, CMD_ADD_EXT_ADVERTISING_DATA, append([]byte{instance}, data...)...)
+	if err != nil {
+		return
+	}
+	return parseInstanceResult(payload)
+}
+
+// Adds an extended advertising instance by issuing AddExtAdvertisingParameters and AddExtAdvertisingData.
+// If setting the data fails, the registered instance is removed again.
+func (bm BtMgmt) AddExtendedAdvertising(controllerID uint16, adv *Advertisement) (res *ExtAdvertisingParametersResult, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.AddExtendedAdvertisingContext(ctx, controllerID, adv)
+}
+
+func (bm BtMgmt) AddExtendedAdvertisingContext(ctx context.Context, controllerID uint16, adv *Advertisement) (res *ExtAdvertisingParametersResult, err error) {
+	if adv.err != nil {
+		return nil, adv.err
+	}
+	res, err = bm.AddExtAdvertisingParametersContext(ctx, controllerID, adv)
+	if err != nil {
+		return
+	}
+	_, err = bm.AddExtAdvertisingDataContext(ctx, controllerID, res.Instance, adv.AdvData, adv.ScanRsp)
+	if err != nil {
+		bm.RemoveAdvertisingContext(ctx, controllerID, res.Instance)
+		return nil, err
+	}
+	return
+}
+
+// Keeps track of the advertising instances of a single controller, see TrackAdvertisingInstances
+type AdvertisingTracker struct {
+	*sync.Mutex
+	instances map[byte]bool
+}
+
+// Returns the currently registered instances in ascending order
+func (t *AdvertisingTracker) Instances() (instances []byte) {
+	t.Lock()
+	defer t.Unlock()
+	for instance := range t.instances {
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i] < instances[j] })
+	return
+}
+
+func (t *AdvertisingTracker) remove(instance byte) {
+	if instance == 0 {
+		t.instances = make(map[byte]bool)
+		return
+	}
+	delete(t.instances, instance)
+}
+
+// Applies Advertising Added / Removed events and the results of own Add / Remove Advertising commands
+// (the kernel doesn't send the events to the socket which issued the command)
+func (t *AdvertisingTracker) updateFromEvent(evt TypedEvent) {
+	t.Lock()
+	defer t.Unlock()
+	switch e := evt.Payload.(type) {
+	case *AdvertisingAddedEvent:
+		t.instances[e.Instance] = true
+	case *AdvertisingRemovedEvent:
+		t.remove(e.Instance)
+	case *CommandCompleteEvent:
+		if e.Status != CMD_STATUS_SUCCESS {
+			return
+		}
+		instance, err := parseInstanceResult(e.ReturnParams)
+		if err != nil {
+			return
+		}
+		switch e.CmdCode {
+		case CMD_ADD_ADVERTISING, CMD_ADD_EXT_ADVERTISING_DATA:
+			t.instances[instance] = true
+		case CMD_REMOVE_ADVERTISING:
+			t.remove(instance)
+		}
+	}
+}
+
+// Reads the active advertising instances and keeps track of added and removed instances (including those
+// removed by the kernel on timeout), till ctx is done
+func (bm BtMgmt) TrackAdvertisingInstances(ctx context.Context, controllerID uint16) (tracker *AdvertisingTracker, err error) {
+	tracker = &AdvertisingTracker{
+		Mutex:     &sync.Mutex{},
+		instances: make(map[byte]bool),
+	}
+	// subscribe before reading, to not miss changes in between
+	evts, err := bm.subscribeInit(ctx, SubscriptionFilter{
+		ControllerIndices: []uint16{controllerID},
+		EventCodes: []EvtCode{
+			EVT_COMMAND_COMPLETE,
+			EVT_EXTENDED_ADVERTISING_ADDED,
+			EVT_EXTENDED_ADVERTISING_REMOVED,
+		},
+	}, func() error {
+		features, rErr := bm.ReadAdvertisingFeaturesContext(ctx, controllerID)
+		if rErr != nil {
+			return rErr
+		}
+		for _, instance := range features.ActiveInstances {
+			tracker.instances[instance] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for evt := range evts {
+			tracker.updateFromEvent(evt)
+		}
+	}()
+	return
+}
@@ -0,0 +1,170 @@
+package btmgmt_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+	"github.com/mame82/mblue-toolz/btmgmt/mgmttest"
+)
+
+func lastParams(k *mgmttest.Kernel, code btmgmt.CmdCode) (params []byte) {
+	for _, cmd := range k.ReceivedCommands() {
+		if cmd.Code == code {
+			params = cmd.Params
+		}
+	}
+	return
+}
+
+func TestAdvertisementFlags(t *testing.T) {
+	tests := []struct {
+		adv  *btmgmt.Advertisement
+		want btmgmt.AdvertisingFlags
+	}{
+		{btmgmt.NewAdvertisement(1), 0},
+		{btmgmt.NewAdvertisement(1).Connectable(), btmgmt.ADV_FLAG_CONNECTABLE},
+		{btmgmt.NewAdvertisement(1).Discoverable(), btmgmt.ADV_FLAG_DISCOVERABLE},
+		{btmgmt.NewAdvertisement(1).LimitedDiscoverable(), btmgmt.ADV_FLAG_LIMITED_DISCOVERABLE},
+		{btmgmt.NewAdvertisement(1).ManagedFlags(), btmgmt.ADV_FLAG_MANAGED_FLAGS},
+		{btmgmt.NewAdvertisement(1).IncludeTxPower(), btmgmt.ADV_FLAG_TX_POWER},
+		{btmgmt.NewAdvertisement(1).IncludeAppearance(), btmgmt.ADV_FLAG_APPEARANCE},
+		{btmgmt.NewAdvertisement(1).IncludeLocalName(), btmgmt.ADV_FLAG_LOCAL_NAME},
+		{btmgmt.NewAdvertisement(1).WithFlags(btmgmt.ADV_FLAG_SEC_2M), btmgmt.ADV_FLAG_SEC_2M},
+		{btmgmt.NewAdvertisement(1).WithDuration(time.Second), btmgmt.ADV_PARAM_DURATION},
+		{btmgmt.NewAdvertisement(1).WithTimeout(time.Second), btmgmt.ADV_PARAM_TIMEOUT},
+		{btmgmt.NewAdvertisement(1).WithInterval(0, 0), btmgmt.ADV_PARAM_INTERVALS},
+		{btmgmt.NewAdvertisement(1).WithTxPower(0), btmgmt.ADV_PARAM_TX_POWER},
+		{btmgmt.NewAdvertisement(1).WithScanRsp(nil), btmgmt.ADV_PARAM_SCAN_RSP},
+		{btmgmt.NewAdvertisement(1).WithAdvData([]byte{2, 1, 6}), 0},
+		{
+			btmgmt.NewAdvertisement(1).Connectable().Discoverable().WithTimeout(time.Minute),
+			btmgmt.ADV_FLAG_CONNECTABLE | btmgmt.ADV_FLAG_DISCOVERABLE | btmgmt.ADV_PARAM_TIMEOUT,
+		},
+	}
+	for _, test := range tests {
+		if test.adv.Flags != test.want {
+			t.Errorf("flags %v, want %v", test.adv.Flags, test.want)
+		}
+	}
+	if adv := btmgmt.NewAdvertisement(1); adv.TxPower != btmgmt.ADV_TX_POWER_NO_PREFERENCE {
+		t.Errorf("default TX power %d, want no preference", adv.TxPower)
+	}
+}
+
+// Legacy advertising has no parameter flags, they are masked off
+func TestAddAdvertisingParameters(t *testing.T) {
+	k, bm := newTestKernel(t)
+	adv := btmgmt.NewAdvertisement(2).Connectable().Discoverable().
+		WithDuration(3 * time.Second).WithTimeout(time.Minute).WithTxPower(-4).
+		WithAdvData([]byte{2, 1, 6}).WithScanRsp([]byte{3, 9, 'a', 'b'})
+	instance, err := bm.AddAdvertising(0, adv)
+	if err != nil || instance != 2 {
+		t.Fatalf("AddAdvertising returned %d, %v", instance, err)
+	}
+	want := []byte{
+		2,          // instance
+		3, 0, 0, 0, // connectable, discoverable
+		3, 0, // duration
+		60, 0, // timeout
+		3, 4, // data lengths
+		2, 1, 6,
+		3, 9, 'a', 'b',
+	}
+	if params := lastParams(k, btmgmt.CMD_ADD_ADVERTISING); !bytes.Equal(params, want) {
+		t.Errorf("wrong parameters % x, want % x", params, want)
+	}
+
+	if _, err = bm.AddAdvertising(0, btmgmt.NewAdvertisement(3).WithAdvData(make([]byte, 256))); err != btmgmt.ErrAdvertisingDataLength {
+		t.Errorf("AddAdvertising with 256 octets returned %v", err)
+	}
+	if ctrl, _ := k.Controller(0); !reflect.DeepEqual(ctrl.Advertising, []byte{2}) {
+		t.Errorf("kernel has instances %v, want [2]", ctrl.Advertising)
+	}
+}
+
+func TestAddExtendedAdvertisingParameters(t *testing.T) {
+	k, bm := newTestKernel(t)
+	adv := btmgmt.NewAdvertisement(1).Connectable().WithFlags(btmgmt.ADV_FLAG_SEC_2M).
+		WithTimeout(2*time.Second).WithInterval(100*time.Millisecond, 200*time.Millisecond).WithTxPower(-4).
+		WithAdvData([]byte{2, 1, 6})
+	res, err := bm.AddExtendedAdvertising(0, adv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Instance != 1 || res.TxPower != -4 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	want := []byte{
+		1,                // instance
+		0x01, 0xe1, 0, 0, // connectable, secondary LE 2M, timeout, intervals, TX power
+		0, 0, // duration
+		2, 0, // timeout
+		160, 0, 0, 0, // min interval
+		64, 1, 0, 0, // max interval
+		0xfc, // TX power
+	}
+	if params := lastParams(k, btmgmt.CMD_ADD_EXT_ADVERTISING_PARAMETERS); !bytes.Equal(params, want) {
+		t.Errorf("wrong parameters % x, want % x", params, want)
+	}
+	if params := lastParams(k, btmgmt.CMD_ADD_EXT_ADVERTISING_DATA); !bytes.Equal(params, []byte{1, 3, 0, 2, 1, 6}) {
+		t.Errorf("wrong data parameters % x", params)
+	}
+
+	// the registered instance is removed again, if the data is rejected
+	_, err = bm.AddExtendedAdvertising(0, btmgmt.NewAdvertisement(2).WithAdvData(make([]byte, 32)))
+	if err == nil {
+		t.Fatal("AddExtendedAdvertising accepted 32 octets of advertising data")
+	}
+	if ctrl, _ := k.Controller(0); !reflect.DeepEqual(ctrl.Advertising, []byte{1}) {
+		t.Errorf("kernel has instances %v, want [1]", ctrl.Advertising)
+	}
+}
+
+func TestTrackAdvertisingInstances(t *testing.T) {
+	k, bm := newTestKernel(t)
+	conn, err := k.NewMgmtConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	other := btmgmt.NewBtMgmtForConnection(conn)
+	instances := func(tracker *btmgmt.AdvertisingTracker, want ...byte) func() bool {
+		return func() bool { return bytes.Equal(tracker.Instances(), want) }
+	}
+
+	if _, err = bm.AddAdvertising(0, btmgmt.NewAdvertisement(1)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker, err := bm.TrackAdvertisingInstances(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !instances(tracker, 1)() {
+		t.Fatalf("initial instances %v, want [1]", tracker.Instances())
+	}
+
+	// own commands are tracked by their results, those of other sockets by events
+	if _, err = bm.AddAdvertising(0, btmgmt.NewAdvertisement(2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.AddExtendedAdvertising(0, btmgmt.NewAdvertisement(4)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "added instances", instances(tracker, 1, 2, 4))
+	if _, err = other.RemoveAdvertising(0, 2); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "removed instance", instances(tracker, 1, 4))
+
+	// instance 0 removes all instances
+	if _, err = bm.RemoveAdvertising(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "removal of all instances", instances(tracker))
+}
@@ -0,0 +1,285 @@
+package btmgmt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Adds the device to the block list of the controller, connections from and to blocked devices are rejected
+// by the kernel. Fails with CMD_STATUS_FAILED if the device is already blocked. Other mgmt sockets receive a
+// Device Blocked event.
+func (bm BtMgmt) BlockDevice(controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.BlockDeviceContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) BlockDeviceContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_BLOCK_DEVICE, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+// Removes the device from the block list of the controller. Fails with CMD_STATUS_INVALID_PARAMETERS if
+// the device isn't blocked. Other mgmt sockets receive a Device Unblocked event.
+func (bm BtMgmt) UnblockDevice(controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.UnblockDeviceContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) UnblockDeviceContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_UNBLOCK_DEVICE, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+// Persistent list of devices, which should be blocked on every controller
+type DenyListStore interface {
+	LoadDenyList() (devices []AddressInfo, err error)
+	AddDevice(device AddressInfo) error    // adding a listed device is no error
+	RemoveDevice(device AddressInfo) error // removing an unlisted device is no error
+}
+
+// DenyListStore implementation, which keeps the list in a JSON file
+// (f.e. [{"Address": "AA:BB:CC:DD:EE:FF", "AddressType": 0}])
+type FileDenyList struct {
+	*sync.Mutex
+	path string
+}
+
+func NewFileDenyList(path string) *FileDenyList {
+	return &FileDenyList{
+		Mutex: &sync.Mutex{},
+		path:  path,
+	}
+}
+
+func (fd *FileDenyList) read() (devices []AddressInfo, err error) {
+	data, err := ioutil.ReadFile(fd.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &devices)
+	return
+}
+
+// writes to a temporary file first, like FileKeyStore
+func (fd *FileDenyList) write(devices []AddressInfo) (err error) {
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return
+	}
+	tmpPath := fd.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return
+	}
+	return os.Rename(tmpPath, fd.path)
+}
+
+func (fd *FileDenyList) LoadDenyList() (devices []AddressInfo, err error) {
+	fd.Lock()
+	defer fd.Unlock()
+	return fd.read()
+}
+
+func (fd *FileDenyList) AddDevice(device AddressInfo) (err error) {
+	fd.Lock()
+	defer fd.Unlock()
+	devices, err := fd.read()
+	if err != nil {
+		return
+	}
+	for _, d := range devices {
+		if d.Equal(device) {
+			return nil
+		}
+	}
+	return fd.write(append(devices, device))
+}
+
+func (fd *FileDenyList) RemoveDevice(device AddressInfo) (err error) {
+	fd.Lock()
+	defer fd.Unlock()
+	devices, err := fd.read()
+	if err != nil {
+		return
+	}
+	remaining := []AddressInfo{}
+	for _, d := range devices {
+		if !d.Equal(device) {
+			remaining = append(remaining, d)
+		}
+	}
+	if len(remaining) == len(devices) {
+		return nil
+	}
+	return fd.write(remaining)
+}
+
+// Keeps the block lists of all controllers in sync with a DenyListStore, see RunDenyListPolicy
+type DenyListPolicy struct {
+	bm    BtMgmt
+	store DenyListStore
+}
+
+// blocks all listed devices on the given controller
+func (p *DenyListPolicy) apply(controllerID uint16) (err error) {
+	devices, err := p.store.LoadDenyList()
+	if err != nil {
+		return
+	}
+	for _, device := range devices {
+		if bErr := p.block(controllerID, device); bErr != nil {
+			err = bErr
+		}
+	}
+	return
+}
+
+// a controller failing to apply the list doesn't keep the list from being applied to the remaining ones, thus
+// only reading the index list fails
+func (p *DenyListPolicy) applyAll() (err error) {
+	indexList, err := p.bm.ReadControllerIndexList()
+	if err != nil {
+		return
+	}
+	for _, idx := range indexList.Indices {
+		if aErr := p.apply(idx); aErr != nil {
+			fmt.Printf("Applying deny list to controller %d failed: %v\n", idx, aErr)
+		}
+	}
+	return
+}
+
+// the kernel has no command to read the block list, thus devices which are already blocked are no error
+func (p *DenyListPolicy) block(controllerID uint16, device AddressInfo) (err error) {
+	_, err = p.bm.BlockDevice(controllerID, device)
+	var mErr *MgmtError
+	if errors.As(err, &mErr) && mErr.Status == CMD_STATUS_FAILED {
+		return nil
+	}
+	return
+}
+
+func (p *DenyListPolicy) unblock(controllerID uint16, device AddressInfo) (err error) {
+	_, err = p.bm.UnblockDevice(controllerID, device)
+	var mErr *MgmtError
+	if errors.As(err, &mErr) && mErr.Status == CMD_STATUS_INVALID_PARAMETERS {
+		return nil
+	}
+	return
+}
+
+// Adds the device to the deny list and blocks it on all controllers
+func (p *DenyListPolicy) Block(device AddressInfo) (err error) {
+	if err = p.store.AddDevice(device); err != nil {
+		return
+	}
+	indexList, err := p.bm.ReadControllerIndexList()
+	if err != nil {
+		return
+	}
+	for _, idx := range indexList.Indices {
+		if bErr := p.block(idx, device); bErr != nil {
+			err = bErr
+		}
+	}
+	return
+}
+
+// Removes the device from the deny list and unblocks it on all controllers
+func (p *DenyListPolicy) Unblock(device AddressInfo) (err error) {
+	if err = p.store.RemoveDevice(device); err != nil {
+		return
+	}
+	indexList, err := p.bm.ReadControllerIndexList()
+	if err != nil {
+		return
+	}
+	for _, idx := range indexList.Indices {
+		if uErr := p.unblock(idx, device); uErr != nil {
+			err = uErr
+		}
+	}
+	return
+}
+
+func (p *DenyListPolicy) handleEvent(evt TypedEvent) (err error) {
+	switch e := evt.Payload.(type) {
+	case *IndexAddedEvent:
+		return p.apply(evt.ControllerIdx)
+	case *DeviceUnblockedEvent:
+		// only Unblock removes devices from the list, devices unblocked by other mgmt sockets are blocked again
+		devices, lErr := p.store.LoadDenyList()
+		if lErr != nil {
+			return lErr
+		}
+		for _, d := range devices {
+			if d.Equal(e.Address) {
+				return p.block(evt.ControllerIdx, e.Address)
+			}
+		}
+	}
+	return
+}
+
+// Blocks all devices of the deny list on all present controllers and on every controller added later on,
+// till ctx is done. The list is only changed by the store and the Block / Unblock methods of the policy: blocks of
+// other mgmt sockets (f.e. bluetoothd) aren't added to it, listed devices unblocked by other mgmt sockets are
+// blocked again. Controllers failing to apply the list are logged and don't stop the policy, only failing to read
+// the controller index list does.
+// If the BtMgmt uses a MgmtSupervisor (f.e. the global connection of NewBtMgmt), the list is re-applied after
+// every reconnect, as controllers could have been re-added meanwhile.
+// Use the Block / Unblock methods of the returned policy, to change the list from within the process.
+func (bm BtMgmt) RunDenyListPolicy(ctx context.Context, store DenyListStore) (policy *DenyListPolicy, err error) {
+	policy = &DenyListPolicy{
+		bm:    bm,
+		store: store,
+	}
+	evts, err := bm.subscribeInit(ctx, SubscriptionFilter{
+		EventCodes: []EvtCode{
+			EVT_INDEX_ADDED,
+			EVT_DEVICE_UNBLOCKED,
+		},
+	}, policy.applyAll)
+	if err != nil {
+		return nil, err
+	}
+
+	if supervisor, ok := bm.provider.(*MgmtSupervisor); ok {
+		states := supervisor.SubscribeState(ctx)
+		go func() {
+			for state := range states {
+				if state != CONNECTION_STATE_UP {
+					continue
+				}
+				if aErr := policy.applyAll(); aErr != nil {
+					fmt.Printf("Re-applying deny list after reconnect failed: %v\n", aErr)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for evt := range evts {
+			if hErr := policy.handleEvent(evt); hErr != nil {
+				fmt.Printf("Deny list policy failed to handle event %#x of controller %d: %v\n", evt.EventCode, evt.ControllerIdx, hErr)
+			}
+		}
+	}()
+	return
+}
@@ -0,0 +1,101 @@
+package btmgmt_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+	"github.com/mame82/mblue-toolz/btmgmt/mgmttest"
+)
+
+func blocksDevices(k *mgmttest.Kernel, controllerIdx uint16, want int) func() bool {
+	return func() bool {
+		c, _ := k.Controller(controllerIdx)
+		return len(c.Blocked) == want
+	}
+}
+
+func TestDenyListPolicyRevertsForeignUnblock(t *testing.T) {
+	k, bm := newTestKernel(t)
+	store := btmgmt.NewFileDenyList(filepath.Join(t.TempDir(), "deny.json"))
+	dev, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}, btmgmt.ADDRESS_TYPE_LE_PUBLIC)
+	if err := store.AddDevice(dev); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	policy, err := bm.RunDenyListPolicy(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "blocked devices", blocksDevices(k, 0, 1))
+
+	// unblocked by another mgmt socket, like bluetoothd
+	conn, err := k.NewMgmtConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = btmgmt.NewBtMgmtForConnection(conn).UnblockDevice(0, dev); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "blocked devices", blocksDevices(k, 0, 1))
+	if devices, _ := store.LoadDenyList(); len(devices) != 1 {
+		t.Fatalf("foreign unblock changed the deny list: %v", devices)
+	}
+
+	// blocks of other mgmt sockets stay out of the deny list
+	foreignDev, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x77}, btmgmt.ADDRESS_TYPE_LE_PUBLIC)
+	if _, err = btmgmt.NewBtMgmtForConnection(conn).BlockDevice(0, foreignDev); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = btmgmt.NewBtMgmtForConnection(conn).UnblockDevice(0, foreignDev); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "blocked devices", blocksDevices(k, 0, 1))
+	if devices, _ := store.LoadDenyList(); len(devices) != 1 {
+		t.Fatalf("foreign block changed the deny list: %v", devices)
+	}
+
+	if err = policy.Unblock(dev); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "blocked devices", blocksDevices(k, 0, 0))
+	if devices, _ := store.LoadDenyList(); len(devices) != 0 {
+		t.Fatalf("Unblock didn't remove the device from the deny list: %v", devices)
+	}
+}
+
+func TestDenyListPolicySurvivesFailingControllers(t *testing.T) {
+	k, bm := newTestKernel(t)
+	k.AddController(mgmttest.DefaultController())
+	k.HandleCmd(btmgmt.CMD_BLOCK_DEVICE, func(req *mgmttest.Request) (btmgmt.CmdStatus, []byte) {
+		return btmgmt.CMD_STATUS_NOT_POWERED, nil
+	})
+	store := btmgmt.NewFileDenyList(filepath.Join(t.TempDir(), "deny.json"))
+	dev, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}, btmgmt.ADDRESS_TYPE_BR_EDR)
+	store.AddDevice(dev)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := bm.RunDenyListPolicy(ctx, store); err != nil {
+		t.Fatalf("policy failed to start, because no controller accepted the list: %v", err)
+	}
+	blockSent := func(controllerIdx uint16) bool {
+		for _, cmd := range k.ReceivedCommands() {
+			if cmd.Code == btmgmt.CMD_BLOCK_DEVICE && cmd.ControllerIdx == controllerIdx {
+				return true
+			}
+		}
+		return false
+	}
+	if !blockSent(0) || !blockSent(1) {
+		t.Fatalf("Block Device hasn't been sent to both controllers: %+v", k.ReceivedCommands())
+	}
+
+	// the policy keeps running and applies the list to controllers added later on
+	idx := k.AddController(mgmttest.DefaultController())
+	waitFor(t, "Block Device for the added controller", func() bool { return blockSent(idx) })
+}
@@ -1,68 +1,62 @@
 package btmgmt
 
 import (
+	"context"
 	"encoding/binary"
-	"fmt"
-	"errors"
 	"sync"
 )
 
 var (
-	ErrMgmtConnFailed                   = errors.New("BtMgmt socket connection failed")
-	globalMgmtConn      *MgmtConnection = nil
-	globalMgmtConnMutex                 = &sync.Mutex{}
+	globalSupervisor      *MgmtSupervisor = nil
+	globalSupervisorMutex                 = &sync.Mutex{}
 )
 
-func runGlobalMgmtConnection() (err error) {
-	if globalMgmtConn != nil {
-		return nil
+// Returns the supervisor of the MgmtConnection shared by all BtMgmt instances created with NewBtMgmt,
+// the connection is established on first call
+func globalMgmtSupervisor() (s *MgmtSupervisor, err error) {
+	globalSupervisorMutex.Lock()
+	defer globalSupervisorMutex.Unlock()
+	if globalSupervisor != nil {
+		return globalSupervisor, nil
 	}
-	globalMgmtConn, err = NewMgmtConnection()
+	globalSupervisor, err = NewMgmtSupervisor(NewMgmtConnection)
 	if err != nil {
-		return
+		globalSupervisor = nil
+		return nil, err
 	}
-
-	// recover errors of go routine which watches MgmtConnection
-	defer func() {
-		if caught := recover(); caught != nil {
-			if caught == ErrMgmtConnFailed {
-				err = ErrMgmtConnFailed // propagate error
-				return
-			}
-			panic(caught) //re-panic otherwise
-		}
-	}()
-
-	// watch state of globalMgmtConnection in goRoutine, re-init if needed
-	go func() {
-		for {
-			select {
-			case <-globalMgmtConn.disposeMgmtConnection:
-				fmt.Println("Global management connection died, restarting...")
-				globalMgmtConnMutex.Lock()
-				globalMgmtConn, err = NewMgmtConnection()
-				globalMgmtConnMutex.Unlock()
-				if err != nil {
-					panic(ErrMgmtConnFailed)
-				}
-
-			}
-		}
-	}()
-	return nil
+	return globalSupervisor, nil
 }
 
 // Wraps general functionality of mgmtConnection (issue commands) to more specific commands
-// with proper input arguments and result parsing. Additionally tries to assure that there's
-// always a working MgmtConnection (connected socket). A global object is used to assure the latter
-// and shared by all BtMgmt instances (There has been no testing of using more than one BtMgmt instance)
+// with proper input arguments and result parsing. Instances created by NewBtMgmt share a global MgmtConnection,
+// which is watched by a MgmtSupervisor and re-established if it dies. Independent instances could be bound to
+// an own MgmtConnection (NewBtMgmtForConnection) or MgmtSupervisor (NewBtMgmtForSupervisor).
+//
+// Every command method uses defaultCommandTimeout, the ...Context variants (f.e. SetPoweredContext) allow
+// applying own deadlines and cancellation.
 type BtMgmt struct {
+	provider mgmtConnectionProvider
 }
 
+func (bm BtMgmt) runCmd(ctx context.Context, controllerID uint16, cmdCode CmdCode, params ...byte) (resultParams []byte, err error) {
+	if bm.provider == nil {
+		return nil, ErrSocketNotConnected
+	}
+	conn, err := bm.provider.connection()
+	if err != nil {
+		return nil, err
+	}
+	return conn.RunCmdContext(ctx, controllerID, cmdCode, params...)
+}
 
+func (bm BtMgmt) ReadManagementVersionInformation() (res *VersionInformation, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.ReadManagementVersionInformationContext(ctx)
+}
 
-func (bm BtMgmt) ReadManagementVersionInformation() (res *VersionInformation, err error)  {
-	payload,err := globalMgmtConn.RunCmd(INDEX_CONTROLLER_NONE, CMD_READ_MANAGEMENT_VERSION_INFORMATION)
+func (bm BtMgmt) ReadManagementVersionInformationContext(ctx context.Context) (res *VersionInformation, err error) {
+	payload,err := bm.runCmd(ctx, INDEX_CONTROLLER_NONE, CMD_READ_MANAGEMENT_VERSION_INFORMATION)
 	if err != nil { return }
 	res = &VersionInformation{}
 	err = res.UpdateFromPayload(payload)
@@ -70,8 +64,14 @@ func (bm BtMgmt) ReadManagementVersionInformation() (res *VersionInformation, er
 	return
 }
 
-func (bm BtMgmt) ReadManagementSupportedCommands() (res *SupportedCommands, err error)  {
-	payload,err := globalMgmtConn.RunCmd(INDEX_CONTROLLER_NONE, CMD_READ_MANAGEMENT_SUPPORTED_COMMANDS)
+func (bm BtMgmt) ReadManagementSupportedCommands() (res *SupportedCommands, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.ReadManagementSupportedCommandsContext(ctx)
+}
+
+func (bm BtMgmt) ReadManagementSupportedCommandsContext(ctx context.Context) (res *SupportedCommands, err error) {
+	payload,err := bm.runCmd(ctx, INDEX_CONTROLLER_NONE, CMD_READ_MANAGEMENT_SUPPORTED_COMMANDS)
 	if err != nil { return }
 	res = &SupportedCommands{}
 	err = res.UpdateFromPayload(payload)
@@ -79,8 +79,14 @@ func (bm BtMgmt) ReadManagementSupportedCommands() (res *SupportedCommands, err
 	return
 }
 
-func (bm BtMgmt) ReadControllerIndexList() (res *ControllerIndexList, err error)  {
-	payload,err := globalMgmtConn.RunCmd(INDEX_CONTROLLER_NONE, CMD_READ_CONTROLLER_INDEX_LIST)
+func (bm BtMgmt) ReadControllerIndexList() (res *ControllerIndexList, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.ReadControllerIndexListContext(ctx)
+}
+
+func (bm BtMgmt) ReadControllerIndexListContext(ctx context.Context) (res *ControllerIndexList, err error) {
+	payload,err := bm.runCmd(ctx, INDEX_CONTROLLER_NONE, CMD_READ_CONTROLLER_INDEX_LIST)
 	if err != nil { return }
 	res = &ControllerIndexList{}
 	err = res.UpdateFromPayload(payload)
@@ -88,8 +94,14 @@ func (bm BtMgmt) ReadControllerIndexList() (res *ControllerIndexList, err error)
 	return
 }
 
-func (bm BtMgmt) ReadControllerInformation(controllerID uint16) (res *ControllerInformation, err error)  {
-	payload,err := globalMgmtConn.RunCmd(controllerID, CMD_READ_CONTROLLER_INFORMATION)
+func (bm BtMgmt) ReadControllerInformation(controllerID uint16) (res *ControllerInformation, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.ReadControllerInformationContext(ctx, controllerID)
+}
+
+func (bm BtMgmt) ReadControllerInformationContext(ctx context.Context, controllerID uint16) (res *ControllerInformation, err error) {
+	payload,err := bm.runCmd(ctx, controllerID, CMD_READ_CONTROLLER_INFORMATION)
 
 	if err != nil { return }
 	res = &ControllerInformation{}
@@ -98,10 +110,16 @@ func (bm BtMgmt) ReadControllerInformation(controllerID uint16) (res *Controller
 	return
 }
 
-func (bm BtMgmt) SetPowered(controllerID uint16, powered bool) (currentSettings *ControllerSettings, err error)  {
+func (bm BtMgmt) SetPowered(controllerID uint16, powered bool) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetPoweredContext(ctx, controllerID, powered)
+}
+
+func (bm BtMgmt) SetPoweredContext(ctx context.Context, controllerID uint16, powered bool) (currentSettings *ControllerSettings, err error) {
 	var bPowered byte
 	if powered { bPowered = 1}
-	payload,err := globalMgmtConn.RunCmd(controllerID, CMD_SET_POWERED, bPowered)
+	payload,err := bm.runCmd(ctx, controllerID, CMD_SET_POWERED, bPowered)
 
 	if err != nil { return }
 	currentSettings = &ControllerSettings{}
@@ -110,17 +128,20 @@ func (bm BtMgmt) SetPowered(controllerID uint16, powered bool) (currentSettings
 	return
 }
 
-func (bm BtMgmt) SetDiscoverable(controllerID uint16, discoverable Discoverability, timeoutSeconds uint16) (currentSettings *ControllerSettings, err error)  {
+func (bm BtMgmt) SetDiscoverable(controllerID uint16, discoverable Discoverability, timeoutSeconds uint16) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetDiscoverableContext(ctx, controllerID, discoverable, timeoutSeconds)
+}
+
+func (bm BtMgmt) SetDiscoverableContext(ctx context.Context, controllerID uint16, discoverable Discoverability, timeoutSeconds uint16) (currentSettings *ControllerSettings, err error) {
 	params := []byte{byte(discoverable)}
 	if discoverable == NOT_DISCOVERABLE { timeoutSeconds = 0 } // Could also be handle via invalid parameters error
 	timeoutBytes := make([]byte,2)
 	binary.LittleEndian.PutUint16(timeoutBytes, timeoutSeconds)
 	params = append(params,timeoutBytes...)
 
-	fmt.Println("PARAMS: ", params)
-	payload,err := globalMgmtConn.RunCmd(controllerID, CMD_SET_DISCOVERABLE, params...)
-
-
+	payload,err := bm.runCmd(ctx, controllerID, CMD_SET_DISCOVERABLE, params...)
 	if err != nil { return }
 	currentSettings = &ControllerSettings{}
 	err = currentSettings.UpdateFromPayload(payload)
@@ -128,10 +149,16 @@ func (bm BtMgmt) SetDiscoverable(controllerID uint16, discoverable Discoverabili
 	return
 }
 
-func (bm BtMgmt) SetConnectable(controllerID uint16, connectable bool) (currentSettings *ControllerSettings, err error)  {
+func (bm BtMgmt) SetConnectable(controllerID uint16, connectable bool) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetConnectableContext(ctx, controllerID, connectable)
+}
+
+func (bm BtMgmt) SetConnectableContext(ctx context.Context, controllerID uint16, connectable bool) (currentSettings *ControllerSettings, err error) {
 	var bConnectable byte
 	if connectable { bConnectable = 1}
-	payload,err := globalMgmtConn.RunCmd(controllerID, CMD_SET_CONNECTABLE, bConnectable)
+	payload,err := bm.runCmd(ctx, controllerID, CMD_SET_CONNECTABLE, bConnectable)
 
 	if err != nil { return }
 	currentSettings = &ControllerSettings{}
@@ -140,10 +167,16 @@ func (bm BtMgmt) SetConnectable(controllerID uint16, connectable bool) (currentS
 	return
 }
 
-func (bm BtMgmt) SetFastConnectable(controllerID uint16, fastConnectable bool) (currentSettings *ControllerSettings, err error)  {
+func (bm BtMgmt) SetFastConnectable(controllerID uint16, fastConnectable bool) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetFastConnectableContext(ctx, controllerID, fastConnectable)
+}
+
+func (bm BtMgmt) SetFastConnectableContext(ctx context.Context, controllerID uint16, fastConnectable bool) (currentSettings *ControllerSettings, err error) {
 	var bFastConnectable byte
 	if fastConnectable { bFastConnectable = 1}
-	payload,err := globalMgmtConn.RunCmd(controllerID, CMD_SET_FAST_CONNECTABLE, bFastConnectable)
+	payload,err := bm.runCmd(ctx, controllerID, CMD_SET_FAST_CONNECTABLE, bFastConnectable)
 
 	if err != nil { return }
 	currentSettings = &ControllerSettings{}
@@ -152,10 +185,16 @@ func (bm BtMgmt) SetFastConnectable(controllerID uint16, fastConnectable bool) (
 	return
 }
 
-func (bm BtMgmt) SetBondable(controllerID uint16, bondable bool) (currentSettings *ControllerSettings, err error)  {
+func (bm BtMgmt) SetBondable(controllerID uint16, bondable bool) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetBondableContext(ctx, controllerID, bondable)
+}
+
+func (bm BtMgmt) SetBondableContext(ctx context.Context, controllerID uint16, bondable bool) (currentSettings *ControllerSettings, err error) {
 	var bBondable byte
 	if bondable { bBondable = 1}
-	payload,err := globalMgmtConn.RunCmd(controllerID, CMD_SET_BONDABLE, bBondable)
+	payload,err := bm.runCmd(ctx, controllerID, CMD_SET_BONDABLE, bBondable)
 
 	if err != nil { return }
 	currentSettings = &ControllerSettings{}
@@ -164,10 +203,16 @@ func (bm BtMgmt) SetBondable(controllerID uint16, bondable bool) (currentSetting
 	return
 }
 
-func (bm BtMgmt) SetLinkSecurity(controllerID uint16, linkSecurity bool) (currentSettings *ControllerSettings, err error)  {
+func (bm BtMgmt) SetLinkSecurity(controllerID uint16, linkSecurity bool) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetLinkSecurityContext(ctx, controllerID, linkSecurity)
+}
+
+func (bm BtMgmt) SetLinkSecurityContext(ctx context.Context, controllerID uint16, linkSecurity bool) (currentSettings *ControllerSettings, err error) {
 	var bLinksecurity byte
 	if linkSecurity { bLinksecurity = 1}
-	payload,err := globalMgmtConn.RunCmd(controllerID, CMD_SET_LINK_SECURITY, bLinksecurity)
+	payload,err := bm.runCmd(ctx, controllerID, CMD_SET_LINK_SECURITY, bLinksecurity)
 
 	if err != nil { return }
 	currentSettings = &ControllerSettings{}
@@ -176,10 +221,16 @@ func (bm BtMgmt) SetLinkSecurity(controllerID uint16, linkSecurity bool) (curren
 	return
 }
 
-func (bm BtMgmt) SetSecureSimplePairing(controllerID uint16, secureSimplePairing bool) (currentSettings *ControllerSettings, err error)  {
+func (bm BtMgmt) SetSecureSimplePairing(controllerID uint16, secureSimplePairing bool) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetSecureSimplePairingContext(ctx, controllerID, secureSimplePairing)
+}
+
+func (bm BtMgmt) SetSecureSimplePairingContext(ctx context.Context, controllerID uint16, secureSimplePairing bool) (currentSettings *ControllerSettings, err error) {
 	var bSecureSimplePairing byte
 	if secureSimplePairing { bSecureSimplePairing = 1}
-	payload,err := globalMgmtConn.RunCmd(controllerID, CMD_SET_SECURE_SIMPLE_PAIRING, bSecureSimplePairing)
+	payload,err := bm.runCmd(ctx, controllerID, CMD_SET_SECURE_SIMPLE_PAIRING, bSecureSimplePairing)
 
 	if err != nil { return }
 	currentSettings = &ControllerSettings{}
@@ -188,10 +239,16 @@ func (bm BtMgmt) SetSecureSimplePairing(controllerID uint16, secureSimplePairing
 	return
 }
 
-func (bm BtMgmt) SetHighSpeed(controllerID uint16, highspeed bool) (currentSettings *ControllerSettings, err error)  {
+func (bm BtMgmt) SetHighSpeed(controllerID uint16, highspeed bool) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetHighSpeedContext(ctx, controllerID, highspeed)
+}
+
+func (bm BtMgmt) SetHighSpeedContext(ctx context.Context, controllerID uint16, highspeed bool) (currentSettings *ControllerSettings, err error) {
 	var bParam byte
 	if highspeed { bParam = 1}
-	payload,err := globalMgmtConn.RunCmd(controllerID, CMD_SET_HIGH_SPEED, bParam)
+	payload,err := bm.runCmd(ctx, controllerID, CMD_SET_HIGH_SPEED, bParam)
 
 	if err != nil { return }
 	currentSettings = &ControllerSettings{}
@@ -200,10 +257,16 @@ func (bm BtMgmt) SetHighSpeed(controllerID uint16, highspeed bool) (currentSetti
 	return
 }
 
-func (bm BtMgmt) SetLowEnergy(controllerID uint16, le bool) (currentSettings *ControllerSettings, err error)  {
+func (bm BtMgmt) SetLowEnergy(controllerID uint16, le bool) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetLowEnergyContext(ctx, controllerID, le)
+}
+
+func (bm BtMgmt) SetLowEnergyContext(ctx context.Context, controllerID uint16, le bool) (currentSettings *ControllerSettings, err error) {
 	var bParam byte
 	if le { bParam = 1}
-	payload,err := globalMgmtConn.RunCmd(controllerID, CMD_SET_LOW_ENERGY, bParam)
+	payload,err := bm.runCmd(ctx, controllerID, CMD_SET_LOW_ENERGY, bParam)
 
 	if err != nil { return }
 	currentSettings = &ControllerSettings{}
@@ -212,15 +275,51 @@ func (bm BtMgmt) SetLowEnergy(controllerID uint16, le bool) (currentSettings *Co
 	return
 }
 
+// Delivers decoded events matching the filter on the returned channel, till ctx is done.
+// Example: all DeviceConnected events of controller 0
+//   evts,err := bm.Subscribe(ctx, SubscriptionFilter{ControllerIndices: []uint16{0}, EventCodes: []EvtCode{EVT_DEVICE_CONNECTED}})
+//   for evt := range evts { devConn := evt.Payload.(*DeviceConnectedEvent) ... }
+func (bm BtMgmt) Subscribe(ctx context.Context, filter SubscriptionFilter) (events <-chan TypedEvent, err error) {
+	if bm.provider == nil {
+		return nil, ErrSocketNotConnected
+	}
+	return bm.provider.Subscribe(ctx, filter)
+}
 
+// Subscribes and runs init afterwards (f.e. reading the initial state of a tracker, without missing changes in
+// between). If init fails, the subscription is ended right away instead of being left undrained till ctx is done.
+func (bm BtMgmt) subscribeInit(ctx context.Context, filter SubscriptionFilter, init func() error) (events <-chan TypedEvent, err error) {
+	subCtx, cancel := context.WithCancel(ctx)
+	events, err = bm.Subscribe(subCtx, filter)
+	if err == nil {
+		err = init()
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	return
+}
 
 func NewBtMgmt() (mgmt *BtMgmt, err error) {
 	// check if global MgmtConnection is initialized, do otherwise
-	err = runGlobalMgmtConnection()
+	supervisor, err := globalMgmtSupervisor()
 	if err != nil {
 		return nil, err
 	}
 
-	mgmt = &BtMgmt{}
-	return
+	return NewBtMgmtForSupervisor(supervisor), nil
+}
+
+func NewBtMgmtForSupervisor(supervisor *MgmtSupervisor) (mgmt *BtMgmt) {
+	return &BtMgmt{provider: supervisor}
+}
+
+// The returned BtMgmt isn't usable anymore, once the given connection is closed
+func NewBtMgmtForConnection(conn *MgmtConnection) (mgmt *BtMgmt) {
+	return &BtMgmt{provider: conn}
 }
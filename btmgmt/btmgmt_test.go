@@ -0,0 +1,283 @@
+package btmgmt_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mame82/mblue-toolz/bt_ad"
+	"github.com/mame82/mblue-toolz/btmgmt"
+	"github.com/mame82/mblue-toolz/btmgmt/mgmttest"
+)
+
+func nextEvent(t *testing.T, events <-chan btmgmt.TypedEvent) btmgmt.TypedEvent {
+	t.Helper()
+	select {
+	case evt, ok := <-events:
+		if !ok {
+			t.Fatal("subscription ended unexpectedly")
+		}
+		return evt
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return btmgmt.TypedEvent{}
+}
+
+func expectNoEvent(t *testing.T, events <-chan btmgmt.TypedEvent) {
+	t.Helper()
+	select {
+	case evt, ok := <-events:
+		if ok {
+			t.Fatalf("unexpected event %#x: %+v", evt.EventCode, evt.Payload)
+		}
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	k, bm := newTestKernel(t)
+
+	version, err := bm.ReadManagementVersionInformation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != 1 {
+		t.Errorf("unexpected version %+v", version)
+	}
+	indexList, err := bm.ReadControllerIndexList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexList.Indices) != 1 || indexList.Indices[0] != 0 {
+		t.Errorf("unexpected index list %+v", indexList.Indices)
+	}
+	info, err := bm.ReadControllerInformation(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl, _ := k.Controller(0)
+	if info.Name != ctrl.Name || info.Address.Addr.String() != ctrl.Address.String() {
+		t.Errorf("controller information %+v doesn't match the fake controller", info)
+	}
+
+	settings, err := bm.SetPowered(0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.Powered {
+		t.Error("Set Powered result doesn't report the controller as powered")
+	}
+	ctrl, _ = k.Controller(0)
+	if ctrl.CurrentSettings&mgmttest.SETTING_POWERED == 0 {
+		t.Error("fake kernel didn't power on the controller")
+	}
+	cmds := k.ReceivedCommands()
+	last := cmds[len(cmds)-1]
+	if last.Code != btmgmt.CMD_SET_POWERED || last.ControllerIdx != 0 || len(last.Params) != 1 || last.Params[0] != 1 {
+		t.Errorf("unexpected command sent: %+v", last)
+	}
+}
+
+func TestCommandStatusErrors(t *testing.T) {
+	k, bm := newTestKernel(t)
+
+	// answered with a Command Status event
+	k.HandleCmd(btmgmt.CMD_SET_CONNECTABLE, func(req *mgmttest.Request) (btmgmt.CmdStatus, []byte) {
+		return btmgmt.CMD_STATUS_BUSY, nil
+	})
+	_, err := bm.SetConnectable(0, true)
+	var mErr *btmgmt.MgmtError
+	if !errors.As(err, &mErr) {
+		t.Fatalf("expected MgmtError, got %v", err)
+	}
+	if mErr.Opcode != btmgmt.CMD_SET_CONNECTABLE || mErr.ControllerIdx != 0 || mErr.Status != btmgmt.CMD_STATUS_BUSY {
+		t.Errorf("unexpected error values %+v", mErr)
+	}
+	if !errors.Is(err, btmgmt.CmdStatusErrorMap[btmgmt.CMD_STATUS_BUSY]) {
+		t.Error("error doesn't match the BUSY sentinel")
+	}
+	if errors.Is(err, btmgmt.CmdStatusErrorMap[btmgmt.CMD_STATUS_REJECTED]) {
+		t.Error("error matches the REJECTED sentinel")
+	}
+
+	// answered with a Command Complete event carrying an error status
+	_, err = bm.ReadControllerInformation(5)
+	if !errors.As(err, &mErr) || mErr.ControllerIdx != 5 || mErr.Status != btmgmt.CMD_STATUS_INVALID_INDEX {
+		t.Errorf("expected INVALID_INDEX for controller 5, got %v", err)
+	}
+
+	k.HandleCmd(btmgmt.CMD_SET_BONDABLE, nil)
+	_, err = bm.SetBondable(0, true)
+	if !errors.Is(err, btmgmt.CmdStatusErrorMap[btmgmt.CMD_STATUS_UNKNOWN_COMMAND]) {
+		t.Errorf("expected UNKNOWN_COMMAND, got %v", err)
+	}
+
+	k.HandleCmd(btmgmt.CMD_SET_POWERED, func(req *mgmttest.Request) (btmgmt.CmdStatus, []byte) {
+		return 0x42, nil
+	})
+	_, err = bm.SetPowered(0, true)
+	if !errors.Is(err, btmgmt.ErrUnknownCommandStatus) {
+		t.Errorf("expected ErrUnknownCommandStatus, got %v", err)
+	}
+}
+
+func TestEventParsing(t *testing.T) {
+	k, bm := newTestKernel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := bm.Subscribe(ctx, btmgmt.SubscriptionFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namePay := make([]byte, 260)
+	copy(namePay, "new name")
+	copy(namePay[249:], "short")
+	k.EmitEvent(btmgmt.EVT_LOCAL_NAME_CHANGED, 0, namePay)
+	evt := nextEvent(t, events)
+	nameChanged, ok := evt.Payload.(*btmgmt.LocalNameChangedEvent)
+	if !ok || evt.EventCode != btmgmt.EVT_LOCAL_NAME_CHANGED || evt.ControllerIdx != 0 {
+		t.Fatalf("unexpected event %#x: %+v", evt.EventCode, evt.Payload)
+	}
+	if nameChanged.Name != "new name" || nameChanged.ShortName != "short" {
+		t.Errorf("wrong names: %+v", nameChanged)
+	}
+
+	addr, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}, btmgmt.ADDRESS_TYPE_LE_PUBLIC)
+	k.ConnectDevice(0, mgmttest.Connection{Address: addr})
+	evt = nextEvent(t, events)
+	connected, ok := evt.Payload.(*btmgmt.DeviceConnectedEvent)
+	if !ok || !connected.Address.Equal(addr) {
+		t.Fatalf("unexpected event %#x: %+v", evt.EventCode, evt.Payload)
+	}
+
+	// events caused by commands of other sockets
+	other, err := k.NewMgmtConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err = btmgmt.NewBtMgmtForConnection(other).SetPowered(0, true); err != nil {
+		t.Fatal(err)
+	}
+	evt = nextEvent(t, events)
+	newSettings, ok := evt.Payload.(*btmgmt.NewSettingsEvent)
+	if !ok || !newSettings.CurrentSettings.Powered {
+		t.Fatalf("unexpected event %#x: %+v", evt.EventCode, evt.Payload)
+	}
+
+	k.EmitEvent(0x7ff0, 0, []byte{1, 2, 3})
+	evt = nextEvent(t, events)
+	unknown, ok := evt.Payload.(*btmgmt.UnknownEvent)
+	if !ok || evt.EventCode != 0x7ff0 || len(unknown.Payload) != 3 {
+		t.Fatalf("unexpected event %#x: %+v", evt.EventCode, evt.Payload)
+	}
+}
+
+func TestSubscribeUnsubscribe(t *testing.T) {
+	k := mgmttest.NewKernel()
+	defer k.Close()
+	// controllers are added before connecting, otherwise the Index Added events could reach the subscriptions
+	k.AddController(mgmttest.DefaultController())
+	k.AddController(mgmttest.DefaultController())
+	conn, err := k.NewMgmtConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	bm := btmgmt.NewBtMgmtForConnection(conn)
+
+	ctxAll, cancelAll := context.WithCancel(context.Background())
+	defer cancelAll()
+	all, err := bm.Subscribe(ctxAll, btmgmt.SubscriptionFilter{EventCodes: []btmgmt.EvtCode{btmgmt.EVT_CONTROLLER_ERROR}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxCtrl1, cancelCtrl1 := context.WithCancel(context.Background())
+	defer cancelCtrl1()
+	ctrl1, err := bm.Subscribe(ctxCtrl1, btmgmt.SubscriptionFilter{ControllerIndices: []uint16{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k.EmitEvent(btmgmt.EVT_CONTROLLER_ERROR, 0, []byte{0x01})
+	k.EmitEvent(btmgmt.EVT_CONTROLLER_ERROR, 1, []byte{0x02})
+	if evt := nextEvent(t, all); evt.ControllerIdx != 0 || evt.Payload.(*btmgmt.ControllerErrorEvent).ErrorCode != 0x01 {
+		t.Errorf("unexpected event %+v", evt)
+	}
+	if evt := nextEvent(t, all); evt.ControllerIdx != 1 {
+		t.Errorf("unexpected event %+v", evt)
+	}
+	if evt := nextEvent(t, ctrl1); evt.ControllerIdx != 1 || evt.EventCode != btmgmt.EVT_CONTROLLER_ERROR {
+		t.Errorf("unexpected event %+v", evt)
+	}
+	expectNoEvent(t, ctrl1)
+
+	// ending one subscription closes its channel, without affecting the other one
+	cancelCtrl1()
+	select {
+	case _, ok := <-ctrl1:
+		if ok {
+			t.Fatal("event delivered after the subscription ended")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after the subscription ended")
+	}
+	k.EmitEvent(btmgmt.EVT_CONTROLLER_ERROR, 1, []byte{0x03})
+	if evt := nextEvent(t, all); evt.Payload.(*btmgmt.ControllerErrorEvent).ErrorCode != 0x03 {
+		t.Errorf("unexpected event %+v", evt)
+	}
+
+	cancelAll()
+	for range all {
+		// drain events queued before the subscription ended
+	}
+}
+
+// a malformed EIR field of a remote device mustn't cost the whole event
+func TestMalformedEIR(t *testing.T) {
+	k, bm := newTestKernel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := bm.Subscribe(ctx, btmgmt.SubscriptionFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flags field, followed by a name field claiming 5 octets with only 2 present
+	eir := []byte{0x02, 0x01, 0x06, 0x05, 0x09, 'a', 'b'}
+	addrPay := []byte{0x66, 0x55, 0x44, 0x33, 0x22, 0x11, byte(btmgmt.ADDRESS_TYPE_LE_PUBLIC)}
+	found := append(append([]byte{}, addrPay...), 0xc4, 0, 0, 0, 0, byte(len(eir)), 0)
+	k.EmitEvent(btmgmt.EVT_DEVICE_FOUND, 0, append(found, eir...))
+	// Device Connected with EIR length exceeding the event
+	connected := append(append([]byte{}, addrPay...), 0, 0, 0, 0, byte(len(eir)+10), 0)
+	k.EmitEvent(btmgmt.EVT_DEVICE_CONNECTED, 0, append(connected, eir...))
+
+	evt := nextEvent(t, events)
+	deviceFound, ok := evt.Payload.(*btmgmt.DeviceFoundEvent)
+	if !ok {
+		t.Fatalf("unexpected event %#x: %+v", evt.EventCode, evt.Payload)
+	}
+	if deviceFound.Address.Address.Addr.String() != "11:22:33:44:55:66" || deviceFound.RSSI != -60 {
+		t.Errorf("wrong address or RSSI: %+v", deviceFound)
+	}
+	if deviceFound.EIRErr != bt_ad.ErrPayloadFormat {
+		t.Errorf("got EIR error %v, want %v", deviceFound.EIRErr, bt_ad.ErrPayloadFormat)
+	}
+	if len(deviceFound.EIR.Fields) != 1 || deviceFound.EIR.Fields[0].Type != bt_ad.AD_FLAGS || !bytes.Equal(deviceFound.EIR.Raw, eir) {
+		t.Errorf("fields in front of the malformed one weren't kept: %+v", deviceFound.EIR)
+	}
+
+	evt = nextEvent(t, events)
+	deviceConnected, ok := evt.Payload.(*btmgmt.DeviceConnectedEvent)
+	if !ok {
+		t.Fatalf("unexpected event %#x: %+v", evt.EventCode, evt.Payload)
+	}
+	if deviceConnected.EIRErr == nil || len(deviceConnected.EIR.Fields) != 1 {
+		t.Errorf("truncated EIR not reported: %+v", deviceConnected)
+	}
+}
@@ -1,10 +1,10 @@
 package btmgmt
 
 import (
+	"context"
 	"fmt"
-	"golang.org/x/sys/unix"
 	"sync"
-	"syscall"
+	"time"
 )
 
 // Details see: https://git.kernel.org/pub/scm/bluetooth/bluez.git/tree/doc/mgmt-api.txt
@@ -23,71 +23,88 @@ import (
 // This makes a precise assignment of the events to the issuing commands impossible.
 //
 // Misbehaviour only seems to be avoidable, if the socket is used exclusively (e.g. no other bluetooth service running)
+//
+// Inside a single MgmtConnection the problem is avoided by queuing commands per (controller, CmdCode): a command
+// is only sent after the result of the previous command with the same controller index and CmdCode arrived (or
+// timed out). Concurrent callers of the same command, thus, always receive their own result.
 
 
 type MgmtConnection struct {
 	*sync.Mutex
 	rMutex                *sync.Mutex
 	wMutex                *sync.Mutex
-	socket_fd             int
-	isBound               bool
+	transport             Transport
 	isConnected           bool
-	saHciCtrl             unix.SockaddrHCI
 	disposeMgmtConnection chan interface{} // used to abort eventHandler loop on close
 	newRawPacket          chan []byte      // used by socket reader loop to pass data to event handler loop
 	mutexListeners        *sync.Mutex
 	registeredListeners   map[EventListener]bool
 	addListener           chan EventListener
 	removeListener        chan EventListener
+	mutexCmdQueues        *sync.Mutex
+	cmdQueues             map[cmdQueueKey]chan struct{}
+}
+
+// Commands with the same controller index and CmdCode can't be told apart by their results,
+// so they share a queue
+type cmdQueueKey struct {
+	controllerIdx uint16
+	cmdCode       CmdCode
 }
 
 
+// Opens a MgmtConnection on the HCI control socket
 func NewMgmtConnection() (mgmtConn *MgmtConnection, err error) {
+	transport, err := DialHCIControlSocket()
+	if err != nil {
+		return nil, err
+	}
+	return NewMgmtConnectionWithTransport(transport), nil
+}
+
+// Runs a MgmtConnection on the given transport, which is closed along with the connection
+func NewMgmtConnectionWithTransport(transport Transport) (mgmtConn *MgmtConnection) {
 	mgmtConn = &MgmtConnection{
 		Mutex:       &sync.Mutex{},
 		rMutex:      &sync.Mutex{},
 		wMutex:      &sync.Mutex{},
-		isBound:     false,
-		isConnected: false,
-		saHciCtrl: unix.SockaddrHCI{
-			Channel: unix.HCI_CHANNEL_CONTROL,
-			Dev:     uint16(INDEX_CONTROLLER_NONE),
-		},
+		transport:   transport,
+		isConnected: true,
 		disposeMgmtConnection: make(chan interface{}),
 		newRawPacket:          make(chan []byte), // no buffer
 		mutexListeners:        &sync.Mutex{},
 		addListener:           make(chan EventListener),
 		removeListener:        make(chan EventListener),
 		registeredListeners:   make(map[EventListener]bool),
-	}
-	err = mgmtConn.Connect()
-	if err != nil {
-		return nil, err
-	}
-	err = mgmtConn.bind()
-	if err != nil {
-		mgmtConn.Close()
-		return nil, err
+		mutexCmdQueues:        &sync.Mutex{},
+		cmdQueues:             make(map[cmdQueueKey]chan struct{}),
 	}
 	go mgmtConn.socketReaderLoop() // converts []byte received via blocking io to blocking channel data
 	go mgmtConn.eventHandlerLoop() // handles events based on channels
-	return mgmtConn, nil
+	return mgmtConn
 }
 
 func (m *MgmtConnection) AddListener(l EventListener) error {
 	if m.isClosed() { return ErrClosed }
 	//fmt.Println("Listener marked for addition")
-	m.addListener <- l
+	select {
+	case m.addListener <- l:
+	case <-m.disposeMgmtConnection:
+		return ErrClosed
+	}
 	return nil
 }
 
-/*
-// Listeners are removed if the Handler returns true
+// Listeners are removed if the Handler returns true, RemoveListener is meant for listeners which
+// have to be removed without receiving a further event (f.e. ended subscriptions)
 func (m *MgmtConnection) RemoveListener(l EventListener) {
 	//fmt.Printf("Listener marked for remove: %v\n", l)
-	m.removeListener <- l
+	select {
+	case m.removeListener <- l:
+	case <-m.disposeMgmtConnection:
+		// event handler loop isn't running anymore, nothing to remove
+	}
 }
-*/
 
 func (m *MgmtConnection) socketReaderLoop() {
 	//fmt.Println("Readloop started")
@@ -99,7 +116,7 @@ func (m *MgmtConnection) socketReaderLoop() {
 		if err != nil || n == 0 {
 			m.Close()
 			//fmt.Println("Error reading from socket")
-			break
+			return
 		}
 		evtPacket := make([]byte, n)
 		copy(evtPacket, rcvBuf) // Copy over as many bytes as readen
@@ -110,8 +127,7 @@ func (m *MgmtConnection) socketReaderLoop() {
 			// do nothing
 		case <-m.disposeMgmtConnection:
 			// unblock and exit the loop if eventHandler is closed
-			close(m.newRawPacket)
-			break
+			return
 		}
 	}
 
@@ -144,6 +160,7 @@ func (m *MgmtConnection) eventHandlerLoop() {
 			evt, eErr := parseEvt(evtPacket)
 			if eErr != nil {
 				fmt.Printf("Skipping unparsable event: %v\n", eErr)
+				m.eventDispatched()
 				continue Outer
 			}
 			m.mutexListeners.Lock()
@@ -160,8 +177,10 @@ func (m *MgmtConnection) eventHandlerLoop() {
 				//Remove listener directly to avoid dealocking this select branch
 				//m.RemoveListener(delme)
 				delete(m.registeredListeners, delme)
+				delete(listenerDeleteMap, delme)
 			}
 			m.mutexListeners.Unlock()
+			m.eventDispatched()
 
 			/*
 			fmt.Printf("Dispatching event: %+v\n", evt)
@@ -177,7 +196,6 @@ func (m *MgmtConnection) eventHandlerLoop() {
 			if deleteListener == nil { break } //happens on channel close
 			// remove listener
 			m.mutexListeners.Lock()
-			fmt.Printf("Removed listener %v\n", deleteListener)
 			delete(m.registeredListeners, deleteListener)
 			m.mutexListeners.Unlock()
 		}
@@ -185,7 +203,37 @@ func (m *MgmtConnection) eventHandlerLoop() {
 	//fmt.Println("Event handler stopped")
 }
 
+// notifies transports implementing DispatchObserver
+func (m *MgmtConnection) eventDispatched() {
+	if observer, ok := m.transport.(DispatchObserver); ok {
+		observer.EventDispatched()
+	}
+}
+
+// Returns the queue for commands with the given controller index and CmdCode. The queue is a channel with
+// capacity 1, a command is in flight as long as the channel holds an element.
+func (m *MgmtConnection) cmdQueue(controllerId uint16, cmdCode CmdCode) chan struct{} {
+	m.mutexCmdQueues.Lock()
+	defer m.mutexCmdQueues.Unlock()
+	key := cmdQueueKey{controllerIdx: controllerId, cmdCode: cmdCode}
+	queue, exists := m.cmdQueues[key]
+	if !exists {
+		queue = make(chan struct{}, 1)
+		m.cmdQueues[key] = queue
+	}
+	return queue
+}
+
+// Runs the command with defaultCommandTimeout, see RunCmdContext
 func (m *MgmtConnection) RunCmd(controllerId uint16, cmdCode CmdCode, params ...byte) (resultParsams []byte, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return m.RunCmdContext(ctx, controllerId, cmdCode, params...)
+}
+
+// Sends the command and waits for its result (Command Complete or Command Status event). If ctx ends before,
+// ErrCmdTimeout (deadline exceeded) or the error of the context is returned.
+func (m *MgmtConnection) RunCmdContext(ctx context.Context, controllerId uint16, cmdCode CmdCode, params ...byte) (resultParsams []byte, err error) {
 	if m.isClosed() { return nil,ErrClosed }
 	command := newCommand(
 		cmdCode,
@@ -193,36 +241,69 @@ func (m *MgmtConnection) RunCmd(controllerId uint16, cmdCode CmdCode, params ...
 		params...,
 	)
 
+	// wait till no other command with same controller index and CmdCode is in flight
+	queue := m.cmdQueue(controllerId, cmdCode)
+	select {
+	case queue <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctxErr(ctx)
+	case <-m.disposeMgmtConnection:
+		return nil, ErrClosed
+	}
+
 	// created listener for given command
-	commandL := newDefaultCmdEvtListener(command)
+	commandL := newDefaultCmdEvtListener(command, m.disposeMgmtConnection)
 	// register listener for command result
-	m.AddListener(commandL)
+	err = m.AddListener(commandL)
+	if err != nil {
+		<-queue
+		return nil, err
+	}
 	// send command
-	m.SendCmd(command)
-
-	return commandL.WaitResult(defaultCommandTimeout)
-}
+	err = m.SendCmd(command)
+	if err != nil {
+		commandL.SetDone()
+		m.RemoveListener(commandL)
+		<-queue
+		return nil, err
+	}
 
+	resultParsams, err = commandL.WaitResult(ctx)
+	if ctx.Err() == nil {
+		<-queue
+		return
+	}
 
+	// The command has been abandoned, but the kernel still answers it. The late result has to be consumed by the
+	// abandoned listener, otherwise it would be handed to the next command in queue. Thus the queue is released
+	// (and the listener removed) once the late result arrived, or defaultCommandTimeout passed.
+	go func() {
+		lateResultTimeout := time.NewTimer(defaultCommandTimeout)
+		defer lateResultTimeout.Stop()
+		select {
+		case <-commandL.ctx.Done():
+		case <-lateResultTimeout.C:
+			commandL.SetDone()
+			m.RemoveListener(commandL)
+		case <-m.disposeMgmtConnection:
+		}
+		<-queue
+	}()
+	return
+}
 
 func (m *MgmtConnection) Read(p []byte) (n int, err error) {
 	if m.isClosed() { return 0,ErrClosed }
 	m.rMutex.Lock()
 	defer m.rMutex.Unlock()
-	if !m.isBound {
-		return 0, ErrSocketNotConnected
-	}
-	return syscall.Read(m.socket_fd, p)
+	return m.transport.Read(p)
 }
 
 func (m *MgmtConnection) Write(p []byte) (n int, err error) {
 	if m.isClosed() { return 0,ErrClosed }
 	m.wMutex.Lock()
 	defer m.wMutex.Unlock()
-	if !m.isBound {
-		return 0, ErrSocketNotConnected
-	}
-	return unix.Write(m.socket_fd, p)
+	return m.transport.Write(p)
 }
 
 func (m *MgmtConnection) isClosed() bool {
@@ -232,52 +313,21 @@ func (m *MgmtConnection) isClosed() bool {
 }
 
 func (m *MgmtConnection) Close() (err error) {
-	if m.isClosed() { return }
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
-	if m.isConnected {
-		err = unix.Close(m.socket_fd)
-		if err != nil {
-			return ErrSockClose
-		}
+	if !m.isConnected { return } // already closed (checked under lock, as the reader loop could close concurrently)
+	err = m.transport.Close()
+	if err != nil {
+		err = ErrSockClose // the connection is disposed anyways
 	}
 	m.isConnected = false
-	m.isBound = false
 	close(m.disposeMgmtConnection)
-	close(m.newRawPacket)
-	close(m.addListener)
-	close(m.removeListener)
+	// newRawPacket, addListener and removeListener are left open, senders select on disposeMgmtConnection instead
 
 	return
 
 }
 
-func (m *MgmtConnection) Connect() (err error) {
-	m.Mutex.Lock()
-	defer m.Mutex.Unlock()
-	//m.socket_fd, err = unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.BTPROTO_HCI)
-	m.socket_fd, err = unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.BTPROTO_HCI)
-	if err != nil {
-		return ErrSocketOpen
-	}
-	m.isConnected = true
-	return
-}
-
-func (m *MgmtConnection) bind() (err error) {
-	m.Mutex.Lock()
-	defer m.Mutex.Unlock()
-	if !m.isConnected {
-		return ErrSocketBind
-	}
-	err = unix.Bind(m.socket_fd, &m.saHciCtrl)
-	if err != nil {
-		return ErrSocketBind
-	}
-	m.isBound = true
-	return
-}
-
 func (m *MgmtConnection) SendCmd(command command) (err error) {
 	if m.isClosed() { return ErrClosed }
 	sendbuf := command.toWire()
@@ -0,0 +1,115 @@
+package btmgmt_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+	"github.com/mame82/mblue-toolz/btmgmt/mgmttest"
+)
+
+func newTestKernel(t *testing.T) (k *mgmttest.Kernel, bm *btmgmt.BtMgmt) {
+	k = mgmttest.NewKernel()
+	t.Cleanup(k.Close)
+	k.AddController(mgmttest.DefaultController())
+	conn, err := k.NewMgmtConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return k, btmgmt.NewBtMgmtForConnection(conn)
+}
+
+func countCommands(k *mgmttest.Kernel, code btmgmt.CmdCode) (n int) {
+	for _, cmd := range k.ReceivedCommands() {
+		if cmd.Code == code {
+			n++
+		}
+	}
+	return
+}
+
+// Polls cond till it is true, fails after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// Concurrent calls of the same command can't be told apart by their results, every caller has to receive
+// the result of its own command anyways
+func TestConcurrentSetPowered(t *testing.T) {
+	k, bm := newTestKernel(t)
+	k.SetResponseDelay(time.Millisecond)
+
+	const callers = 64
+	wg := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(powered bool) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				settings, err := bm.SetPowered(0, powered)
+				if err != nil {
+					t.Errorf("SetPowered(%v) failed: %v", powered, err)
+					return
+				}
+				if settings.Powered != powered {
+					t.Errorf("SetPowered(%v) returned the result of another command: %+v", powered, settings)
+					return
+				}
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	if n := countCommands(k, btmgmt.CMD_SET_POWERED); n != callers*5 {
+		t.Errorf("kernel received %d Set Powered commands, want %d", n, callers*5)
+	}
+}
+
+// A command abandoned by its caller keeps its queue slot, till the kernel answered it. Otherwise the late
+// result would be handed to the next command of the queue.
+func TestCancelledCommandKeepsQueueSlot(t *testing.T) {
+	k, bm := newTestKernel(t)
+	delay := 300 * time.Millisecond
+	k.SetResponseDelay(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := bm.SetPoweredContext(ctx, 0, true); err != btmgmt.ErrCmdTimeout {
+		t.Fatalf("expected ErrCmdTimeout, got %v", err)
+	}
+
+	type result struct {
+		settings *btmgmt.ControllerSettings
+		err      error
+	}
+	results := make(chan result, 1)
+	go func() {
+		settings, err := bm.SetPowered(0, false)
+		results <- result{settings, err}
+	}()
+
+	time.Sleep(delay / 3)
+	if n := countCommands(k, btmgmt.CMD_SET_POWERED); n != 1 {
+		t.Fatalf("second Set Powered sent before the result of the cancelled one arrived (%d commands)", n)
+	}
+
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.settings.Powered {
+		t.Fatal("second Set Powered received the late result of the cancelled command")
+	}
+	if n := countCommands(k, btmgmt.CMD_SET_POWERED); n != 2 {
+		t.Fatalf("kernel received %d Set Powered commands, want 2", n)
+	}
+}
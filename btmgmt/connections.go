@@ -0,0 +1,163 @@
+package btmgmt
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// Value of RSSI, TxPower and MaxTxPower of ConnectionInformation, if the controller couldn't provide it
+const CONN_INFO_NOT_AVAILABLE int8 = 127
+
+// Value of ClockInformation.Accuracy, if the accuracy is unknown
+const CLOCK_ACCURACY_UNKNOWN uint16 = 0xffff
+
+type ConnectionList struct {
+	Connections []AddressInfo
+}
+
+func (cl *ConnectionList) UpdateFromPayload(p []byte) (err error) {
+	if len(p) < 2 {
+		return ErrPayloadFormat
+	}
+	count := int(binary.LittleEndian.Uint16(p[0:2]))
+	if len(p) != 2+count*7 {
+		return ErrPayloadFormat
+	}
+	cl.Connections = make([]AddressInfo, count)
+	for i := range cl.Connections {
+		off := 2 + i*7
+		if err = cl.Connections[i].UpdateFromPayload(p[off : off+7]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+type ConnectionInformation struct {
+	Address    AddressInfo
+	RSSI       int8 // CONN_INFO_NOT_AVAILABLE if unknown
+	TxPower    int8 // CONN_INFO_NOT_AVAILABLE if unknown
+	MaxTxPower int8 // CONN_INFO_NOT_AVAILABLE if unknown
+}
+
+func (ci *ConnectionInformation) UpdateFromPayload(p []byte) (err error) {
+	if len(p) != 10 {
+		return ErrPayloadFormat
+	}
+	if err = ci.Address.UpdateFromPayload(p[0:7]); err != nil {
+		return
+	}
+	ci.RSSI = int8(p[7])
+	ci.TxPower = int8(p[8])
+	ci.MaxTxPower = int8(p[9])
+	return
+}
+
+func (ci ConnectionInformation) String() string {
+	return fmt.Sprintf("%s RSSI %d TX power %d max TX power %d", ci.Address.String(), ci.RSSI, ci.TxPower, ci.MaxTxPower)
+}
+
+type ClockInformation struct {
+	Address      AddressInfo
+	LocalClock   uint32
+	PiconetClock uint32 // 0 if only the local clock has been requested
+	Accuracy     uint16 // CLOCK_ACCURACY_UNKNOWN if unknown
+}
+
+func (ci *ClockInformation) UpdateFromPayload(p []byte) (err error) {
+	if len(p) != 17 {
+		return ErrPayloadFormat
+	}
+	if err = ci.Address.UpdateFromPayload(p[0:7]); err != nil {
+		return
+	}
+	ci.LocalClock = binary.LittleEndian.Uint32(p[7:11])
+	ci.PiconetClock = binary.LittleEndian.Uint32(p[11:15])
+	ci.Accuracy = binary.LittleEndian.Uint16(p[15:17])
+	return
+}
+
+// Terminates the connection to the given device. The mgmt API has no reason parameter, the kernel always
+// disconnects with "Remote User Terminated Connection". Other mgmt sockets receive a Device Disconnected
+// event with DISCONNECT_REASON_TERMINATED_LOCAL_HOST, the issuing socket doesn't.
+func (bm BtMgmt) Disconnect(controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.DisconnectContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) DisconnectContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_DISCONNECT, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+// Returns the addresses of all connected devices
+func (bm BtMgmt) GetConnections(controllerID uint16) (res *ConnectionList, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.GetConnectionsContext(ctx, controllerID)
+}
+
+func (bm BtMgmt) GetConnectionsContext(ctx context.Context, controllerID uint16) (res *ConnectionList, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_GET_CONECTIONS)
+	if err != nil {
+		return
+	}
+	res = &ConnectionList{}
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Returns RSSI, TX power and max TX power of the connection to the given device. The kernel caches the values
+// for a short (random) time, thus frequent polling doesn't result in HCI traffic for every call.
+// If the kernel fails with a result (f.e. CMD_STATUS_NOT_CONNECTED), res holds the device and
+// CONN_INFO_NOT_AVAILABLE values, alongside the error.
+func (bm BtMgmt) GetConnectionInformation(controllerID uint16, device AddressInfo) (res *ConnectionInformation, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.GetConnectionInformationContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) GetConnectionInformationContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *ConnectionInformation, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_GET_CONNECTION_INFORMATION, device.toPayload()...)
+	res = &ConnectionInformation{}
+	if err != nil {
+		if res.UpdateFromPayload(payload) != nil {
+			res = nil
+		}
+		return
+	}
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Returns the local clock and the piconet clock of the BR/EDR connection to the given device. If the device
+// is the zero AddressInfo{} (BDADDR_ANY), only the local clock is read.
+func (bm BtMgmt) GetClockInformation(controllerID uint16, device AddressInfo) (res *ClockInformation, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.GetClockInformationContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) GetClockInformationContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *ClockInformation, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_GET_CLOCK_INFORMATION, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	res = &ClockInformation{}
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
@@ -0,0 +1,134 @@
+package btmgmt_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+	"github.com/mame82/mblue-toolz/btmgmt/mgmttest"
+)
+
+func TestConnectionPayloadLengths(t *testing.T) {
+	for _, n := range []int{0, 9, 11} {
+		if err := (&btmgmt.ConnectionInformation{}).UpdateFromPayload(make([]byte, n)); err != btmgmt.ErrPayloadFormat {
+			t.Errorf("connection information of %d octets returned %v", n, err)
+		}
+	}
+	for _, n := range []int{0, 16, 18} {
+		if err := (&btmgmt.ClockInformation{}).UpdateFromPayload(make([]byte, n)); err != btmgmt.ErrPayloadFormat {
+			t.Errorf("clock information of %d octets returned %v", n, err)
+		}
+	}
+	for _, n := range []int{0, 1, 8, 10} {
+		pay := make([]byte, n)
+		if n > 1 {
+			pay[0] = 1
+		}
+		if err := (&btmgmt.ConnectionList{}).UpdateFromPayload(pay); err != btmgmt.ErrPayloadFormat {
+			t.Errorf("connection list of %d octets returned %v", n, err)
+		}
+	}
+
+	ci := &btmgmt.ConnectionInformation{}
+	err := ci.UpdateFromPayload([]byte{0x66, 0x55, 0x44, 0x33, 0x22, 0x11, byte(btmgmt.ADDRESS_TYPE_LE_PUBLIC), 0xc4, 0x04, 0x7f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.Address.Address.String() != "11:22:33:44:55:66" || ci.RSSI != -60 || ci.TxPower != 4 || ci.MaxTxPower != btmgmt.CONN_INFO_NOT_AVAILABLE {
+		t.Errorf("wrong connection information %v", ci)
+	}
+	clk := &btmgmt.ClockInformation{}
+	err = clk.UpdateFromPayload([]byte{1, 2, 3, 4, 5, 6, 0, 0x44, 0x33, 0x22, 0x11, 0x88, 0x77, 0x66, 0x55, 0xff, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clk.LocalClock != 0x11223344 || clk.PiconetClock != 0x55667788 || clk.Accuracy != btmgmt.CLOCK_ACCURACY_UNKNOWN {
+		t.Errorf("wrong clock information %+v", clk)
+	}
+}
+
+func TestConnections(t *testing.T) {
+	k, bm := newTestKernel(t)
+	conn, err := k.NewMgmtConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	other := btmgmt.NewBtMgmtForConnection(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := other.Subscribe(ctx, btmgmt.SubscriptionFilter{EventCodes: []btmgmt.EvtCode{btmgmt.EVT_DEVICE_DISCONNECTED}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = bm.SetPowered(0, true); err != nil {
+		t.Fatal(err)
+	}
+	dev, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x00, 0x1a, 0x7d, 0xda, 0x71, 0x14}, btmgmt.ADDRESS_TYPE_BR_EDR)
+	k.ConnectDevice(0, mgmttest.Connection{Address: dev, RSSI: -60, TxPower: 4, MaxTxPower: 127})
+
+	list, err := bm.GetConnections(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Connections) != 1 || !list.Connections[0].Equal(dev) {
+		t.Errorf("wrong connections %+v", list.Connections)
+	}
+	info, err := bm.GetConnectionInformation(0, dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Address.Equal(dev) || info.RSSI != -60 || info.TxPower != 4 || info.MaxTxPower != btmgmt.CONN_INFO_NOT_AVAILABLE {
+		t.Errorf("wrong connection information %v", info)
+	}
+
+	// BDADDR_ANY reads the local clock only
+	clk, err := bm.GetClockInformation(0, btmgmt.AddressInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clk.PiconetClock != 0 || clk.Accuracy != btmgmt.CLOCK_ACCURACY_UNKNOWN {
+		t.Errorf("wrong local clock information %+v", clk)
+	}
+	if clk, err = bm.GetClockInformation(0, dev); err != nil {
+		t.Fatal(err)
+	}
+	if !clk.Address.Equal(dev) || clk.PiconetClock == 0 {
+		t.Errorf("wrong piconet clock information %+v", clk)
+	}
+
+	res, err := bm.Disconnect(0, dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Equal(dev) {
+		t.Errorf("Disconnect returned %v", res)
+	}
+	evt := nextEvent(t, events)
+	if e, ok := evt.Payload.(*btmgmt.DeviceDisconnectedEvent); !ok || !e.Address.Equal(dev) || e.Reason != btmgmt.DISCONNECT_REASON_TERMINATED_LOCAL_HOST {
+		t.Errorf("unexpected event %+v", evt.Payload)
+	}
+	if list, err = bm.GetConnections(0); err != nil || len(list.Connections) != 0 {
+		t.Errorf("connections after disconnect %+v, %v", list, err)
+	}
+
+	// the kernel reports the device with "not available" values
+	var mErr *btmgmt.MgmtError
+	info, err = bm.GetConnectionInformation(0, dev)
+	if !errors.As(err, &mErr) || mErr.Status != btmgmt.CMD_STATUS_NOT_CONNECTED {
+		t.Fatalf("GetConnectionInformation of disconnected device returned %v", err)
+	}
+	if info == nil || !info.Address.Equal(dev) || info.RSSI != btmgmt.CONN_INFO_NOT_AVAILABLE ||
+		info.TxPower != btmgmt.CONN_INFO_NOT_AVAILABLE || info.MaxTxPower != btmgmt.CONN_INFO_NOT_AVAILABLE {
+		t.Errorf("wrong connection information %v", info)
+	}
+	if _, err = bm.GetClockInformation(0, dev); !errors.As(err, &mErr) || mErr.Status != btmgmt.CMD_STATUS_NOT_CONNECTED {
+		t.Errorf("GetClockInformation of disconnected device returned %v", err)
+	}
+	if _, err = bm.Disconnect(0, dev); !errors.As(err, &mErr) || mErr.Status != btmgmt.CMD_STATUS_NOT_CONNECTED {
+		t.Errorf("second Disconnect returned %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package btmgmt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Adds the device to the kernel's device list of the controller (or changes the action of a listed device).
+// BR/EDR devices only support DEVICE_ACTION_ALLOW_INCOMING, which lets them connect while the controller isn't
+// connectable. For LE devices the kernel runs background scanning and connects (or reports the device with
+// Device Found events) depending on the action. Other mgmt sockets receive a Device Added event.
+func (bm BtMgmt) AddDevice(controllerID uint16, device AddressInfo, action DeviceAction) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.AddDeviceContext(ctx, controllerID, device, action)
+}
+
+func (bm BtMgmt) AddDeviceContext(ctx context.Context, controllerID uint16, device AddressInfo, action DeviceAction) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_ADD_DEVICE, append(device.toPayload(), byte(action))...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+// Removes the device from the kernel's device list of the controller, the zero AddressInfo{}
+// (00:00:00:00:00:00, BR/EDR) removes all devices. Fails with CMD_STATUS_INVALID_PARAMETERS if the device
+// isn't listed. Other mgmt sockets receive a Device Removed event for every removed device.
+func (bm BtMgmt) RemoveDevice(controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.RemoveDeviceContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) RemoveDeviceContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_REMOVE_DEVICE, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+// Entry of the kernel's device list
+type DeviceListEntry struct {
+	Address AddressInfo
+	Action  DeviceAction
+}
+
+func (e DeviceListEntry) String() string {
+	return fmt.Sprintf("%s: %s", e.Address.String(), e.Action.String())
+}
+
+func findDeviceListEntry(entries []DeviceListEntry, device AddressInfo) (idx int) {
+	for idx, e := range entries {
+		if e.Address.Equal(device) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func putDeviceListEntry(entries []DeviceListEntry, entry DeviceListEntry) []DeviceListEntry {
+	if idx := findDeviceListEntry(entries, entry.Address); idx >= 0 {
+		entries[idx] = entry
+		return entries
+	}
+	return append(entries, entry)
+}
+
+func removeDeviceListEntry(entries []DeviceListEntry, device AddressInfo) []DeviceListEntry {
+	if idx := findDeviceListEntry(entries, device); idx >= 0 {
+		return append(entries[:idx], entries[idx+1:]...)
+	}
+	return entries
+}
+
+// Keeps the desired entries in the kernel's device list of a single controller, see RunDeviceListReconciler
+type DeviceListReconciler struct {
+	*sync.Mutex
+	ctx          context.Context // commands are aborted once the reconciler's ctx is done
+	bm           BtMgmt
+	controllerID uint16
+	desired      []DeviceListEntry
+	current      []DeviceListEntry // kernel list, as known from own commands and Device Added / Removed events
+	owned        []DeviceListEntry // entries added by the reconciler, only these are ever removed
+}
+
+// Returns the desired device list
+func (r *DeviceListReconciler) Desired() []DeviceListEntry {
+	r.Lock()
+	defer r.Unlock()
+	return append([]DeviceListEntry{}, r.desired...)
+}
+
+// Returns the device list of the kernel, as far as known. Entries added by other mgmt sockets are only known
+// if they have been reported by events, thus entries present before the reconciler started (or before a reconnect)
+// are missing.
+func (r *DeviceListReconciler) Devices() []DeviceListEntry {
+	r.Lock()
+	defer r.Unlock()
+	return append([]DeviceListEntry{}, r.current...)
+}
+
+// Replaces the desired device list and updates the kernel list accordingly. Later entries for the same
+// address replace earlier ones.
+func (r *DeviceListReconciler) SetDesired(entries []DeviceListEntry) (err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.desired = nil
+	for _, e := range entries {
+		r.desired = putDeviceListEntry(r.desired, e)
+	}
+	return r.reconcile()
+}
+
+// Adds (or changes) a single entry of the desired device list
+func (r *DeviceListReconciler) Add(device AddressInfo, action DeviceAction) (err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.desired = putDeviceListEntry(r.desired, DeviceListEntry{Address: device, Action: action})
+	return r.reconcile()
+}
+
+// Removes a single entry from the desired device list
+func (r *DeviceListReconciler) Remove(device AddressInfo) (err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.desired = removeDeviceListEntry(r.desired, device)
+	return r.reconcile()
+}
+
+// context of a single command: the default timeout, but done as soon as the reconciler's ctx is done
+func (r *DeviceListReconciler) cmdContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.ctx, defaultCommandTimeout)
+}
+
+// removes owned devices which aren't desired anymore from the kernel list and adds missing or changed ones,
+// has to be called with the lock held
+func (r *DeviceListReconciler) reconcile() (err error) {
+	for _, e := range append([]DeviceListEntry{}, r.owned...) {
+		if findDeviceListEntry(r.desired, e.Address) >= 0 {
+			continue
+		}
+		ctx, cancel := r.cmdContext()
+		_, rErr := r.bm.RemoveDeviceContext(ctx, r.controllerID, e.Address)
+		cancel()
+		var mErr *MgmtError
+		// INVALID_PARAMETERS: the device isn't listed anymore
+		if rErr != nil && !(errors.As(rErr, &mErr) && mErr.Status == CMD_STATUS_INVALID_PARAMETERS) {
+			if r.ctx.Err() != nil {
+				return ctxErr(r.ctx) // aborted, the remaining entries would fail the same way
+			}
+			err = fmt.Errorf("removing %s: %v", e.Address.String(), rErr)
+			continue
+		}
+		r.owned = removeDeviceListEntry(r.owned, e.Address)
+		r.current = removeDeviceListEntry(r.current, e.Address)
+	}
+	for _, e := range r.desired {
+		if idx := findDeviceListEntry(r.current, e.Address); idx >= 0 && r.current[idx].Action == e.Action {
+			continue
+		}
+		ctx, cancel := r.cmdContext()
+		_, aErr := r.bm.AddDeviceContext(ctx, r.controllerID, e.Address, e.Action)
+		cancel()
+		if aErr != nil {
+			if r.ctx.Err() != nil {
+				return ctxErr(r.ctx)
+			}
+			err = fmt.Errorf("adding %s: %v", e.String(), aErr)
+			continue
+		}
+		r.current = putDeviceListEntry(r.current, e)
+		r.owned = putDeviceListEntry(r.owned, e)
+	}
+	return
+}
+
+// The kernel has no command to read the device list, thus all desired devices are (re-)added. The list isn't
+// cleared, as this would remove the entries of other mgmt sockets, too.
+func (r *DeviceListReconciler) resync() (err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.current = nil
+	return r.reconcile()
+}
+
+func (r *DeviceListReconciler) handleEvent(evt TypedEvent) (err error) {
+	r.Lock()
+	defer r.Unlock()
+	switch e := evt.Payload.(type) {
+	case *DeviceAddedEvent:
+		// added or changed by another mgmt socket (f.e. bluetoothd), the kernel doesn't report our own commands
+		r.current = putDeviceListEntry(r.current, DeviceListEntry{Address: e.Address, Action: e.Action})
+	case *DeviceRemovedEvent:
+		r.current = removeDeviceListEntry(r.current, e.Address)
+		r.owned = removeDeviceListEntry(r.owned, e.Address)
+	case *IndexAddedEvent:
+		// the device list of a (re-)added controller is empty
+		r.current = nil
+		r.owned = nil
+	default:
+		return
+	}
+	return r.reconcile()
+}
+
+// Adds the desired entries to the kernel's device list of the given controller and keeps them there, till ctx
+// is done: desired devices removed or changed by other mgmt sockets are re-added with the desired action and the
+// entries are re-applied if the controller is re-added. If the BtMgmt uses a MgmtSupervisor, the entries are
+// re-applied after every reconnect, as events could have been missed meanwhile.
+// The reconciler only removes entries it added itself (once they are removed from the desired list), entries
+// of other mgmt sockets (like the auto-connect devices of bluetoothd) are left untouched, unless they conflict
+// with a desired entry. Two reconcilers desiring different actions for the same device would fight each other.
+// Use SetDesired, Add and Remove of the returned reconciler, to change the desired list later on. Cancelling ctx
+// aborts a resync in flight, changes of the desired list aren't applied afterwards.
+func (bm BtMgmt) RunDeviceListReconciler(ctx context.Context, controllerID uint16, desired []DeviceListEntry) (reconciler *DeviceListReconciler, err error) {
+	reconciler = &DeviceListReconciler{
+		Mutex:        &sync.Mutex{},
+		ctx:          ctx,
+		bm:           bm,
+		controllerID: controllerID,
+	}
+	for _, e := range desired {
+		reconciler.desired = putDeviceListEntry(reconciler.desired, e)
+	}
+	evts, err := bm.subscribeInit(ctx, SubscriptionFilter{
+		ControllerIndices: []uint16{controllerID},
+		EventCodes: []EvtCode{
+			EVT_INDEX_ADDED,
+			EVT_DEVICE_ADDED,
+			EVT_DEVICE_REMOVED,
+		},
+	}, reconciler.resync)
+	if err != nil {
+		return nil, err
+	}
+
+	if supervisor, ok := bm.provider.(*MgmtSupervisor); ok {
+		states := supervisor.SubscribeState(ctx)
+		go func() {
+			for state := range states {
+				if state != CONNECTION_STATE_UP {
+					continue
+				}
+				if rErr := reconciler.resync(); rErr != nil {
+					fmt.Printf("Re-applying device list of controller %d after reconnect failed: %v\n", controllerID, rErr)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for evt := range evts {
+			if hErr := reconciler.handleEvent(evt); hErr != nil {
+				fmt.Printf("Device list reconciler failed to handle event %#x of controller %d: %v\n", evt.EventCode, evt.ControllerIdx, hErr)
+			}
+		}
+	}()
+	return
+}
@@ -0,0 +1,100 @@
+package btmgmt_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+	"github.com/mame82/mblue-toolz/btmgmt/mgmttest"
+)
+
+func listsDevices(k *mgmttest.Kernel, controllerIdx uint16, want ...btmgmt.DeviceListEntry) func() bool {
+	return func() bool {
+		c, _ := k.Controller(controllerIdx)
+		return sameDevices(c.Devices, want)
+	}
+}
+
+func sameDevices(a, b []btmgmt.DeviceListEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+outer:
+	for _, ea := range a {
+		for _, eb := range b {
+			if ea.Address.Equal(eb.Address) && ea.Action == eb.Action {
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func TestDeviceListReconcilerRevertsForeignChanges(t *testing.T) {
+	k, bm := newTestKernel(t)
+	foreignConn, err := k.NewMgmtConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer foreignConn.Close()
+	foreign := btmgmt.NewBtMgmtForConnection(foreignConn)
+
+	devA, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}, btmgmt.ADDRESS_TYPE_LE_PUBLIC)
+	devB, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x77}, btmgmt.ADDRESS_TYPE_LE_PUBLIC)
+	devForeign, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, btmgmt.ADDRESS_TYPE_LE_PUBLIC)
+	entryA := btmgmt.DeviceListEntry{Address: devA, Action: btmgmt.DEVICE_ACTION_AUTO_CONNECT}
+	entryB := btmgmt.DeviceListEntry{Address: devB, Action: btmgmt.DEVICE_ACTION_BACKGROUND_SCAN}
+	entryForeign := btmgmt.DeviceListEntry{Address: devForeign, Action: btmgmt.DEVICE_ACTION_AUTO_CONNECT}
+
+	// listed by another daemon, before the reconciler starts
+	if _, err = foreign.AddDevice(0, devForeign, entryForeign.Action); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reconciler, err := bm.RunDeviceListReconciler(ctx, 0, []btmgmt.DeviceListEntry{entryA, entryB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "device list", listsDevices(k, 0, entryForeign, entryA, entryB))
+
+	// foreign removal and change of desired devices are reverted
+	if _, err = foreign.RemoveDevice(0, devA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = foreign.AddDevice(0, devB, btmgmt.DEVICE_ACTION_AUTO_CONNECT); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "device list", listsDevices(k, 0, entryForeign, entryA, entryB))
+
+	// only owned devices are removed, foreign additions stay
+	entryForeign2 := btmgmt.DeviceListEntry{Address: devForeign, Action: btmgmt.DEVICE_ACTION_BACKGROUND_SCAN}
+	if _, err = foreign.AddDevice(0, devForeign, entryForeign2.Action); err != nil {
+		t.Fatal(err)
+	}
+	if err = reconciler.Remove(devA); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "device list", listsDevices(k, 0, entryForeign2, entryB))
+}
+
+func TestDeviceListReconcilerCancelAbortsResync(t *testing.T) {
+	k, bm := newTestKernel(t)
+	k.SetResponseDelay(2 * time.Second)
+	dev, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}, btmgmt.ADDRESS_TYPE_LE_PUBLIC)
+	entry := btmgmt.DeviceListEntry{Address: dev, Action: btmgmt.DEVICE_ACTION_AUTO_CONNECT}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := bm.RunDeviceListReconciler(ctx, 0, []btmgmt.DeviceListEntry{entry}); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("initial resync returned after %v", d)
+	}
+}
@@ -0,0 +1,117 @@
+package btmgmt
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+// Disables the RSSI threshold of StartServiceDiscovery (invalid RSSI value, according to mgmt-api.txt)
+const RSSI_THRESHOLD_NONE = int8(127)
+
+// Result delivered by DiscoveryResults, exactly one of the fields is set
+type DiscoveryResult struct {
+	DeviceFound *DeviceFoundEvent
+	Discovering *DiscoveringEvent
+}
+
+func parseDiscoveryAddressTypes(payload []byte) (res DiscoveryAddressTypes, err error) {
+	if len(payload) != 1 {
+		return 0, ErrPayloadFormat
+	}
+	return DiscoveryAddressTypes(payload[0]), nil
+}
+
+// Starts the discovery process for the given address types. Discovered devices are reported
+// with Device Found events (see DiscoveryResults), the discovery stops on its own.
+func (bm BtMgmt) StartDiscovery(controllerID uint16, addressTypes DiscoveryAddressTypes) (res DiscoveryAddressTypes, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.StartDiscoveryContext(ctx, controllerID, addressTypes)
+}
+
+func (bm BtMgmt) StartDiscoveryContext(ctx context.Context, controllerID uint16, addressTypes DiscoveryAddressTypes) (res DiscoveryAddressTypes, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_START_DICOVERY, byte(addressTypes))
+	if err != nil {
+		return
+	}
+	return parseDiscoveryAddressTypes(payload)
+}
+
+// Starts a discovery, which only reports devices advertising one of the given 128 bit UUIDs
+// (all devices, if no UUID is given) and having a RSSI of at least rssiThreshold.
+// Use RSSI_THRESHOLD_NONE to disable RSSI filtering.
+func (bm BtMgmt) StartServiceDiscovery(controllerID uint16, addressTypes DiscoveryAddressTypes, rssiThreshold int8, uuids ...string) (res DiscoveryAddressTypes, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.StartServiceDiscoveryContext(ctx, controllerID, addressTypes, rssiThreshold, uuids...)
+}
+
+func (bm BtMgmt) StartServiceDiscoveryContext(ctx context.Context, controllerID uint16, addressTypes DiscoveryAddressTypes, rssiThreshold int8, uuids ...string) (res DiscoveryAddressTypes, err error) {
+	params := make([]byte, 4)
+	params[0] = byte(addressTypes)
+	params[1] = byte(rssiThreshold)
+	binary.LittleEndian.PutUint16(params[2:4], uint16(len(uuids)))
+	for _, uuid := range uuids {
+		uuidBytes, uErr := uuidToPayload(uuid)
+		if uErr != nil {
+			return 0, uErr
+		}
+		params = append(params, uuidBytes...)
+	}
+
+	payload, err := bm.runCmd(ctx, controllerID, CMD_START_SERVICE_DISCOVERY, params...)
+	if err != nil {
+		return
+	}
+	return parseDiscoveryAddressTypes(payload)
+}
+
+// Stops a discovery started by StartDiscovery or StartServiceDiscovery, the address types have to
+// match the ones used to start the discovery.
+func (bm BtMgmt) StopDiscovery(controllerID uint16, addressTypes DiscoveryAddressTypes) (res DiscoveryAddressTypes, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.StopDiscoveryContext(ctx, controllerID, addressTypes)
+}
+
+func (bm BtMgmt) StopDiscoveryContext(ctx context.Context, controllerID uint16, addressTypes DiscoveryAddressTypes) (res DiscoveryAddressTypes, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_STOP_DICOVERY, byte(addressTypes))
+	if err != nil {
+		return
+	}
+	return parseDiscoveryAddressTypes(payload)
+}
+
+// Streams Device Found and Discovering events of the given controller, till ctx is done.
+// Should be called before starting the discovery, in order to not miss early results.
+func (bm BtMgmt) DiscoveryResults(ctx context.Context, controllerID uint16) (results <-chan DiscoveryResult, err error) {
+	evts, err := bm.Subscribe(ctx, SubscriptionFilter{
+		ControllerIndices: []uint16{controllerID},
+		EventCodes:        []EvtCode{EVT_DEVICE_FOUND, EVT_DISCOVERING},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resChan := make(chan DiscoveryResult)
+	go func() {
+		defer close(resChan)
+		for evt := range evts {
+			res := DiscoveryResult{}
+			switch payload := evt.Payload.(type) {
+			case *DeviceFoundEvent:
+				res.DeviceFound = payload
+			case *DiscoveringEvent:
+				res.Discovering = payload
+			default:
+				continue
+			}
+			select {
+			case resChan <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return resChan, nil
+}
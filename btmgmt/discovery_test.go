@@ -0,0 +1,156 @@
+package btmgmt_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+)
+
+func nextResult(t *testing.T, results <-chan btmgmt.DiscoveryResult) btmgmt.DiscoveryResult {
+	t.Helper()
+	select {
+	case res, ok := <-results:
+		if !ok {
+			t.Fatal("discovery results ended unexpectedly")
+		}
+		return res
+	case <-time.After(time.Second):
+		t.Fatal("no discovery result received")
+	}
+	return btmgmt.DiscoveryResult{}
+}
+
+func expectDiscovering(t *testing.T, results <-chan btmgmt.DiscoveryResult, addressTypes btmgmt.DiscoveryAddressTypes, discovering bool) {
+	t.Helper()
+	res := nextResult(t, results)
+	if res.Discovering == nil || res.DeviceFound != nil {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if res.Discovering.AddressTypes != addressTypes || res.Discovering.Discovering != discovering {
+		t.Errorf("got %+v, want address types %#x discovering %v", res.Discovering, addressTypes, discovering)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	k, bm := newTestKernel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, err := bm.DiscoveryResults(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mErr *btmgmt.MgmtError
+	_, err = bm.StartDiscovery(0, btmgmt.DISCOVERY_ADDRESS_TYPES_LE)
+	if !errors.As(err, &mErr) || mErr.Status != btmgmt.CMD_STATUS_NOT_POWERED {
+		t.Fatalf("StartDiscovery while powered off returned %v", err)
+	}
+	if _, err = bm.SetPowered(0, true); err != nil {
+		t.Fatal(err)
+	}
+	addressTypes, err := bm.StartDiscovery(0, btmgmt.DISCOVERY_ADDRESS_TYPES_LE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addressTypes != btmgmt.DISCOVERY_ADDRESS_TYPES_LE {
+		t.Errorf("StartDiscovery returned address types %#x", addressTypes)
+	}
+	expectDiscovering(t, results, btmgmt.DISCOVERY_ADDRESS_TYPES_LE, true)
+
+	// Device Found events of other controllers aren't delivered
+	eir := []byte{0x02, 0x01, 0x06, 0x05, 0x09, 'a', 'b', 'c', 'd'}
+	found := append([]byte{0x66, 0x55, 0x44, 0x33, 0x22, 0xc1, byte(btmgmt.ADDRESS_TYPE_LE_RANDOM), 0xc4, 0, 0, 0, 0, byte(len(eir)), 0}, eir...)
+	foreign := append([]byte{}, found...)
+	foreign[0] = 0x77
+	k.EmitEvent(btmgmt.EVT_DEVICE_FOUND, 1, foreign)
+	k.EmitEvent(btmgmt.EVT_DEVICE_FOUND, 0, found)
+	res := nextResult(t, results)
+	if res.DeviceFound == nil || res.Discovering != nil {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if res.DeviceFound.Address.Address.Addr.String() != "c1:22:33:44:55:66" || res.DeviceFound.RSSI != -60 {
+		t.Errorf("wrong address or RSSI %+v", res.DeviceFound)
+	}
+	if name, _ := res.DeviceFound.EIR.Name(); name != "abcd" || res.DeviceFound.EIRErr != nil {
+		t.Errorf("wrong EIR data %v, %v", res.DeviceFound.EIR, res.DeviceFound.EIRErr)
+	}
+
+	// the address types have to match those of the running discovery
+	_, err = bm.StopDiscovery(0, btmgmt.DISCOVERY_ADDRESS_TYPES_ALL)
+	if !errors.As(err, &mErr) || mErr.Status != btmgmt.CMD_STATUS_REJECTED {
+		t.Errorf("StopDiscovery with other address types returned %v", err)
+	}
+	if addressTypes, err = bm.StopDiscovery(0, btmgmt.DISCOVERY_ADDRESS_TYPES_LE); err != nil {
+		t.Fatal(err)
+	}
+	if addressTypes != btmgmt.DISCOVERY_ADDRESS_TYPES_LE {
+		t.Errorf("StopDiscovery returned address types %#x", addressTypes)
+	}
+	expectDiscovering(t, results, btmgmt.DISCOVERY_ADDRESS_TYPES_LE, false)
+
+	cancel()
+	select {
+	case _, ok := <-results:
+		if ok {
+			t.Error("result delivered after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Error("discovery results not closed after cancel")
+	}
+}
+
+func TestStartServiceDiscovery(t *testing.T) {
+	k, bm := newTestKernel(t)
+	if _, err := bm.SetPowered(0, true); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, err := bm.DiscoveryResults(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = bm.StartServiceDiscovery(0, btmgmt.DISCOVERY_ADDRESS_TYPES_ALL, -70, "180d"); err == nil {
+		t.Error("StartServiceDiscovery accepted an invalid UUID")
+	}
+	if n := countCommands(k, btmgmt.CMD_START_SERVICE_DISCOVERY); n != 0 {
+		t.Fatalf("invalid UUID sent to the kernel %d times", n)
+	}
+
+	addressTypes, err := bm.StartServiceDiscovery(0, btmgmt.DISCOVERY_ADDRESS_TYPES_ALL, -70,
+		"0000180d-0000-1000-8000-00805f9b34fb", "6e400001-b5a3-f393-e0a9-e50e24dcca9e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addressTypes != btmgmt.DISCOVERY_ADDRESS_TYPES_ALL {
+		t.Errorf("StartServiceDiscovery returned address types %#x", addressTypes)
+	}
+	want := []byte{
+		0x07, 0xba, // address types, RSSI threshold
+		2, 0, // UUID count
+		0xfb, 0x34, 0x9b, 0x5f, 0x80, 0x00, 0x00, 0x80, 0x00, 0x10, 0x00, 0x00, 0x0d, 0x18, 0x00, 0x00,
+		0x9e, 0xca, 0xdc, 0x24, 0x0e, 0xe5, 0xa9, 0xe0, 0x93, 0xf3, 0xa3, 0xb5, 0x01, 0x00, 0x40, 0x6e,
+	}
+	if params := lastParams(k, btmgmt.CMD_START_SERVICE_DISCOVERY); !bytes.Equal(params, want) {
+		t.Errorf("wrong parameters % x, want % x", params, want)
+	}
+	expectDiscovering(t, results, btmgmt.DISCOVERY_ADDRESS_TYPES_ALL, true)
+	if _, err = bm.StopDiscovery(0, btmgmt.DISCOVERY_ADDRESS_TYPES_ALL); err != nil {
+		t.Fatal(err)
+	}
+	expectDiscovering(t, results, btmgmt.DISCOVERY_ADDRESS_TYPES_ALL, false)
+
+	// without UUIDs and RSSI threshold
+	if _, err = bm.StartServiceDiscovery(0, btmgmt.DISCOVERY_ADDRESS_TYPE_BR_EDR, btmgmt.RSSI_THRESHOLD_NONE); err != nil {
+		t.Fatal(err)
+	}
+	if params := lastParams(k, btmgmt.CMD_START_SERVICE_DISCOVERY); !bytes.Equal(params, []byte{0x01, 0x7f, 0, 0}) {
+		t.Errorf("wrong parameters % x", params)
+	}
+	expectDiscovering(t, results, btmgmt.DISCOVERY_ADDRESS_TYPE_BR_EDR, true)
+}
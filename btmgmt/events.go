@@ -3,7 +3,6 @@ package btmgmt
 import (
 	"context"
 	"encoding/binary"
-	"time"
 )
 
 type Event struct {
@@ -22,15 +21,14 @@ type defaultCmdEvtListener struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 	srcCmd command // the command
-
-	isDone bool
+	connDisposed <-chan interface{} // closed if the connection, the command was sent on, dies
 
 	resParam []byte
 	resErr   error
 }
 
 func (l *defaultCmdEvtListener) Filter(event Event) (consume bool) {
-	if l.isDone { return true } // send ANY event to handler(), in order to assure the handler can indicate that the listener has finished
+	if l.ctx.Err() != nil { return true } // done (result received or timed out), send ANY event to handler(), in order to assure the handler can indicate that the listener has finished
 
 	// check if event is for same controller as the command
 	//fmt.Printf("Default command listener received Event: %+v\n", event)
@@ -65,7 +63,7 @@ func (l *defaultCmdEvtListener) Filter(event Event) (consume bool) {
 }
 
 func (l *defaultCmdEvtListener) Handle(event Event) (finished bool) {
-	if l.isDone { return true } // indicate handle is finished
+	if l.ctx.Err() != nil { return true } // indicate handle is finished, without consuming the result of another command
 
 	switch event.EventCode {
 	case EVT_COMMAND_STATUS:
@@ -76,11 +74,7 @@ func (l *defaultCmdEvtListener) Handle(event Event) (finished bool) {
 			if cmdStatus.CmdCode == l.srcCmd.CommandCode {  //ToDo: remove, already checked by filter
 				//fmt.Println("... CommandCode matches, cancelling listener")
 				// set correct error value (read by WaitResult)
-				if statusErr, exists := CmdStatusErrorMap[cmdStatus.Status]; exists {
-					l.resErr = statusErr
-				} else {
-					l.resErr = ErrUnknownCommandStatus
-				}
+				l.resErr = newMgmtError(l.srcCmd, cmdStatus.Status)
 				l.cancel()
 				return true // indicate listener could be removed
 			}
@@ -94,12 +88,8 @@ func (l *defaultCmdEvtListener) Handle(event Event) (finished bool) {
 			if cmdComplete.CmdCode == l.srcCmd.CommandCode { //ToDo: remove, already checked by filter
 				//fmt.Println("... CommandCode matches, cancelling listener")
 				// set correct error value and result params (read by WaitResult)
-				if statusErr, exists := CmdStatusErrorMap[cmdComplete.Status]; exists {
-					l.resErr = statusErr
-					l.resParam = cmdComplete.ReturnParams
-				} else {
-					l.resErr = ErrUnknownCommandStatus
-				}
+				l.resErr = newMgmtError(l.srcCmd, cmdComplete.Status)
+				l.resParam = cmdComplete.ReturnParams
 				l.cancel()
 				return true // indicate listener could be removed
 			}
@@ -111,36 +101,33 @@ func (l *defaultCmdEvtListener) Handle(event Event) (finished bool) {
 }
 
 func (l *defaultCmdEvtListener) SetDone() {
-	l.isDone = true
 	l.cancel()
 }
 
-func (l *defaultCmdEvtListener) WaitResult(timeout time.Duration) ([]byte, error) {
-	timeoutCtx, cancelWait := context.WithTimeout(context.Background(), timeout)
+func (l *defaultCmdEvtListener) WaitResult(ctx context.Context) ([]byte, error) {
 	select {
-	case <-timeoutCtx.Done():
-		// free cancel func by calling
-		cancelWait()
-		// cancelListener
-		l.cancel()
-		// return error
-		l.resErr = ErrCmdTimeout
+	case <-ctx.Done():
+		// the listener isn't cancelled, as it still has to consume the late result (see RunCmdContext)
+		return nil, ctxErr(ctx)
+	case <-l.connDisposed:
+		// no result will arrive anymore
+		return nil, ErrConnectionLost
 	case <-l.ctx.Done():
 		// The context of the listener was closed, this could happen because:
 		// 1) A command status event was received (maybe with error)
 		// 2) A command complete event was received (with success / error)
-		cancelWait() //free local cancel listener
 	}
 
 	return l.resParam, l.resErr
 }
 
-func newDefaultCmdEvtListener(srcCmd command) (cmdResultListener *defaultCmdEvtListener) {
+func newDefaultCmdEvtListener(srcCmd command, connDisposed <-chan interface{}) (cmdResultListener *defaultCmdEvtListener) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cmdResultListener = &defaultCmdEvtListener{
-		ctx:    ctx,
-		cancel: cancel,
-		srcCmd: srcCmd,
+		ctx:          ctx,
+		cancel:       cancel,
+		srcCmd:       srcCmd,
+		connDisposed: connDisposed,
 	}
 
 	return cmdResultListener
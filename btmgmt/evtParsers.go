@@ -3,7 +3,7 @@ package btmgmt
 import (
 	"encoding/binary"
 	"fmt"
-	"github.com/mame82/P4wnP1_aloa/mnetlink"
+	"github.com/mame82/mblue-toolz/bt_cod"
 	"net"
 )
 
@@ -62,7 +62,7 @@ type ControllerInformation struct {
 	Manufacturer      uint16
 	SupportedSettings ControllerSettings
 	CurrentSettings   ControllerSettings
-	ClassOfDevice     DeviceClass // 3, till clear how to parse
+	ClassOfDevice     bt_cod.CoD
 	Name              string      //[249]byte, 0x00 terminated
 	ShortName         string      //[11]byte, 0x00 terminated
 
@@ -95,22 +95,6 @@ func (ci ControllerInformation) String() string {
 	return res
 }
 
-type DeviceClass struct {
-	Octets []byte
-}
-
-func (c *DeviceClass) String() string {
-	return fmt.Sprintf("0x%.2x%.2x%.2x", c.Octets[0], c.Octets[1], c.Octets[2])
-}
-
-func (c *DeviceClass) UpdateFromPayload(pay []byte) (err error) {
-	if len(pay) != 3 {
-		return ErrPayloadFormat
-	}
-	c.Octets = copyReverse(pay)
-	return
-}
-
 type Address struct {
 	Addr net.HardwareAddr
 }
@@ -128,50 +112,18 @@ func (a *Address) UpdateFromPayload(pay []byte) (err error) {
 	return
 }
 
-// ToDo: Stringer interface for ControllerSettings (needs map)
-type ControllerSettings struct {
-	Powered                 bool
-	Connectable             bool
-	FastConnectable         bool
-	Discoverable            bool
-	Bondable                bool
-	LinkLevelSecurity       bool
-	SecureSimplePairing     bool
-	BrEdr                   bool
-	HighSpeed               bool
-	LowEnergy               bool
-	Advertising             bool
-	SecureConnections       bool
-	DebugKeys               bool
-	Privacy                 bool
-	ControllerConfiguration bool
-	StaticAddress           bool
+// Text representation "AA:BB:CC:DD:EE:FF", used for JSON encoding (f.e. by FileKeyStore)
+func (a Address) MarshalText() (text []byte, err error) {
+	return []byte(a.Addr.String()), nil
 }
 
-func (cd *ControllerSettings) UpdateFromPayload(pay []byte) (err error) {
-	if len(pay) != 4 {
-		return ErrPayloadFormat
+func (a *Address) UnmarshalText(text []byte) (err error) {
+	addr, err := net.ParseMAC(string(text))
+	if err != nil || len(addr) != 6 {
+		return ErrInvalidAddress
 	}
-	//b := (pay)[0]
-	b := mnetlink.Hbo().Uint32(pay[0:4])
-
-	cd.Powered = testBit(b, 0)
-	cd.Connectable = testBit(b, 1)
-	cd.FastConnectable = testBit(b, 2)
-	cd.Discoverable = testBit(b, 3)
-	cd.Bondable = testBit(b, 4)
-	cd.LinkLevelSecurity = testBit(b, 5)
-	cd.SecureSimplePairing = testBit(b, 6)
-	cd.BrEdr = testBit(b, 7)
-	cd.HighSpeed = testBit(b, 8)
-	cd.LowEnergy = testBit(b, 9)
-	cd.Advertising = testBit(b, 10)
-	cd.SecureConnections = testBit(b, 11)
-	cd.DebugKeys = testBit(b, 12)
-	cd.Privacy = testBit(b, 13)
-	cd.ControllerConfiguration = testBit(b, 14)
-	cd.StaticAddress = testBit(b, 15)
-	return nil
+	a.Addr = addr
+	return
 }
 
 type ControllerIndexList struct {
@@ -0,0 +1,643 @@
+package btmgmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/mame82/mblue-toolz/bt_ad"
+	"github.com/mame82/mblue-toolz/bt_cod"
+)
+
+// Typed payloads of the asynchronous events described in mgmt-api.txt.
+// Every struct implements ParsePayload, Event.Decode() picks the correct one based on the EvtCode.
+
+type AddressInfo struct {
+	Address     Address
+	AddressType AddressType
+}
+
+func (ai *AddressInfo) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 7 {
+		return ErrPayloadFormat
+	}
+	err = ai.Address.UpdateFromPayload(pay[0:6])
+	if err != nil {
+		return
+	}
+	ai.AddressType = AddressType(pay[6])
+	return
+}
+
+func NewAddressInfo(addr net.HardwareAddr, addrType AddressType) (ai AddressInfo, err error) {
+	if len(addr) != 6 {
+		return ai, ErrInvalidAddress
+	}
+	ai.Address.Addr = addr
+	ai.AddressType = addrType
+	return
+}
+
+func (ai AddressInfo) Equal(other AddressInfo) bool {
+	return ai.AddressType == other.AddressType && bytes.Equal(ai.Address.Addr, other.Address.Addr)
+}
+
+// wire format (little endian address, followed by address type) as used in command parameters
+func (ai AddressInfo) toPayload() []byte {
+	pay := make([]byte, 7)
+	copy(pay[0:6], copyReverse(ai.Address.Addr))
+	pay[6] = byte(ai.AddressType)
+	return pay
+}
+
+func (ai AddressInfo) String() string {
+	return fmt.Sprintf("%s (%s)", ai.Address.String(), ai.AddressType.String())
+}
+
+// Flags of Device Found and Device Connected events
+type DeviceFlags uint32
+
+const (
+	DEVICE_FLAG_CONFIRM_NAME    DeviceFlags = 1 << 0
+	DEVICE_FLAG_LEGACY_PAIRING  DeviceFlags = 1 << 1
+	DEVICE_FLAG_NOT_CONNECTABLE DeviceFlags = 1 << 2
+)
+
+func (f DeviceFlags) ConfirmName() bool    { return f&DEVICE_FLAG_CONFIRM_NAME > 0 }
+func (f DeviceFlags) LegacyPairing() bool  { return f&DEVICE_FLAG_LEGACY_PAIRING > 0 }
+func (f DeviceFlags) NotConnectable() bool { return f&DEVICE_FLAG_NOT_CONNECTABLE > 0 }
+
+type DisconnectReason byte
+
+const (
+	DISCONNECT_REASON_UNSPECIFIED              DisconnectReason = 0x00
+	DISCONNECT_REASON_CONNECTION_TIMEOUT       DisconnectReason = 0x01
+	DISCONNECT_REASON_TERMINATED_LOCAL_HOST    DisconnectReason = 0x02
+	DISCONNECT_REASON_TERMINATED_REMOTE_HOST   DisconnectReason = 0x03
+	DISCONNECT_REASON_AUTHENTICATION_FAILURE   DisconnectReason = 0x04
+	DISCONNECT_REASON_TERMINATED_LOCAL_SUSPEND DisconnectReason = 0x05
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DISCONNECT_REASON_UNSPECIFIED:
+		return "Unspecified"
+	case DISCONNECT_REASON_CONNECTION_TIMEOUT:
+		return "Connection timeout"
+	case DISCONNECT_REASON_TERMINATED_LOCAL_HOST:
+		return "Connection terminated by local host"
+	case DISCONNECT_REASON_TERMINATED_REMOTE_HOST:
+		return "Connection terminated by remote host"
+	case DISCONNECT_REASON_AUTHENTICATION_FAILURE:
+		return "Connection terminated due to authentication failure"
+	case DISCONNECT_REASON_TERMINATED_LOCAL_SUSPEND:
+		return "Connection terminated by local host for suspend"
+	default:
+		return fmt.Sprintf("Unknown reason 0x%.2x", byte(r))
+	}
+}
+
+// Events without parameters (Index Added, Index Removed, Unconfigured Index Added/Removed)
+type NoParamEvent struct{}
+
+func (e *NoParamEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 0 {
+		return ErrPayloadFormat
+	}
+	return
+}
+
+type IndexAddedEvent struct{ NoParamEvent }
+type IndexRemovedEvent struct{ NoParamEvent }
+type UnconfiguredIndexAddedEvent struct{ NoParamEvent }
+type UnconfiguredIndexRemovedEvent struct{ NoParamEvent }
+
+// Used for events with unknown EvtCode, carries the raw payload
+type UnknownEvent struct {
+	Payload []byte
+}
+
+func (e *UnknownEvent) UpdateFromPayload(pay []byte) (err error) {
+	e.Payload = pay
+	return
+}
+
+type ControllerErrorEvent struct {
+	ErrorCode byte
+}
+
+func (e *ControllerErrorEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 1 {
+		return ErrPayloadFormat
+	}
+	e.ErrorCode = pay[0]
+	return
+}
+
+type NewSettingsEvent struct {
+	CurrentSettings ControllerSettings
+}
+
+func (e *NewSettingsEvent) UpdateFromPayload(pay []byte) (err error) {
+	return e.CurrentSettings.UpdateFromPayload(pay)
+}
+
+type ClassOfDeviceChangedEvent struct {
+	ClassOfDevice bt_cod.CoD
+}
+
+func (e *ClassOfDeviceChangedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if e.ClassOfDevice.UpdateFromPayload(pay) != nil {
+		return ErrPayloadFormat
+	}
+	return
+}
+
+type LocalNameChangedEvent struct {
+	Name      string //[249]byte, 0x00 terminated
+	ShortName string //[11]byte, 0x00 terminated
+}
+
+func (e *LocalNameChangedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 260 {
+		return ErrPayloadFormat
+	}
+	e.Name = string(zeroTerminateSlice(pay[0:249]))
+	e.ShortName = string(zeroTerminateSlice(pay[249:]))
+	return
+}
+
+type NewLinkKeyEvent struct {
+	StoreHint bool
+	Key       LinkKey
+}
+
+func (e *NewLinkKeyEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 26 {
+		return ErrPayloadFormat
+	}
+	e.StoreHint = pay[0] != 0
+	return e.Key.UpdateFromPayload(pay[1:])
+}
+
+type NewLongTermKeyEvent struct {
+	StoreHint bool
+	Key       LongTermKey
+}
+
+func (e *NewLongTermKeyEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 37 {
+		return ErrPayloadFormat
+	}
+	e.StoreHint = pay[0] != 0
+	return e.Key.UpdateFromPayload(pay[1:])
+}
+
+type DeviceConnectedEvent struct {
+	Address AddressInfo
+	Flags   DeviceFlags
+	EIR     bt_ad.AdvertisingData
+	EIRErr  error // set if the EIR data of the remote device is malformed, EIR holds the fields up to the malformed one
+}
+
+func (e *DeviceConnectedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) < 13 {
+		return ErrPayloadFormat
+	}
+	err = e.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	e.Flags = DeviceFlags(binary.LittleEndian.Uint32(pay[7:11]))
+	e.EIRErr = parseRemoteEIR(&e.EIR, binary.LittleEndian.Uint16(pay[11:13]), pay[13:])
+	return
+}
+
+type DeviceDisconnectedEvent struct {
+	Address AddressInfo
+	Reason  DisconnectReason
+}
+
+func (e *DeviceDisconnectedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 8 {
+		return ErrPayloadFormat
+	}
+	err = e.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	e.Reason = DisconnectReason(pay[7])
+	return
+}
+
+type ConnectFailedEvent struct {
+	Address AddressInfo
+	Status  CmdStatus
+}
+
+func (e *ConnectFailedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 8 {
+		return ErrPayloadFormat
+	}
+	err = e.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	e.Status = CmdStatus(pay[7])
+	return
+}
+
+type PinCodeRequestEvent struct {
+	Address AddressInfo
+	Secure  bool
+}
+
+func (e *PinCodeRequestEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 8 {
+		return ErrPayloadFormat
+	}
+	err = e.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	e.Secure = pay[7] != 0
+	return
+}
+
+type UserConfirmationRequestEvent struct {
+	Address     AddressInfo
+	ConfirmHint byte
+	Value       uint32
+}
+
+func (e *UserConfirmationRequestEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 12 {
+		return ErrPayloadFormat
+	}
+	err = e.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	e.ConfirmHint = pay[7]
+	e.Value = binary.LittleEndian.Uint32(pay[8:12])
+	return
+}
+
+type UserPasskeyRequestEvent struct {
+	Address AddressInfo
+}
+
+func (e *UserPasskeyRequestEvent) UpdateFromPayload(pay []byte) (err error) {
+	return e.Address.UpdateFromPayload(pay)
+}
+
+type AuthenticationFailedEvent struct {
+	Address AddressInfo
+	Status  CmdStatus
+}
+
+func (e *AuthenticationFailedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 8 {
+		return ErrPayloadFormat
+	}
+	err = e.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	e.Status = CmdStatus(pay[7])
+	return
+}
+
+type DeviceFoundEvent struct {
+	Address AddressInfo
+	RSSI    int8
+	Flags   DeviceFlags
+	EIR     bt_ad.AdvertisingData
+	EIRErr  error // set if the EIR data of the remote device is malformed, EIR holds the fields up to the malformed one
+}
+
+func (e *DeviceFoundEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) < 14 {
+		return ErrPayloadFormat
+	}
+	err = e.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	e.RSSI = int8(pay[7])
+	e.Flags = DeviceFlags(binary.LittleEndian.Uint32(pay[8:12]))
+	e.EIRErr = parseRemoteEIR(&e.EIR, binary.LittleEndian.Uint16(pay[12:14]), pay[14:])
+	return
+}
+
+// EIR data of Device Connected / Device Found is sent by the remote device and could be malformed (or get
+// truncated). As the event is useful without it, the error is returned instead of failing the whole event.
+func parseRemoteEIR(eir *bt_ad.AdvertisingData, eirLen uint16, pay []byte) (err error) {
+	if len(pay) != int(eirLen) {
+		err = ErrPayloadFormat
+		if len(pay) > int(eirLen) {
+			pay = pay[:eirLen]
+		}
+	}
+	if pErr := eir.UpdateFromPayload(pay); err == nil {
+		err = pErr
+	}
+	return
+}
+
+type DiscoveringEvent struct {
+	AddressTypes DiscoveryAddressTypes
+	Discovering  bool
+}
+
+func (e *DiscoveringEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 2 {
+		return ErrPayloadFormat
+	}
+	e.AddressTypes = DiscoveryAddressTypes(pay[0])
+	e.Discovering = pay[1] != 0
+	return
+}
+
+type DeviceBlockedEvent struct {
+	Address AddressInfo
+}
+
+func (e *DeviceBlockedEvent) UpdateFromPayload(pay []byte) (err error) {
+	return e.Address.UpdateFromPayload(pay)
+}
+
+type DeviceUnblockedEvent struct {
+	Address AddressInfo
+}
+
+func (e *DeviceUnblockedEvent) UpdateFromPayload(pay []byte) (err error) {
+	return e.Address.UpdateFromPayload(pay)
+}
+
+type DeviceUnpairedEvent struct {
+	Address AddressInfo
+}
+
+func (e *DeviceUnpairedEvent) UpdateFromPayload(pay []byte) (err error) {
+	return e.Address.UpdateFromPayload(pay)
+}
+
+type PasskeyNotifyEvent struct {
+	Address AddressInfo
+	Passkey uint32
+	Entered byte
+}
+
+func (e *PasskeyNotifyEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 12 {
+		return ErrPayloadFormat
+	}
+	err = e.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	e.Passkey = binary.LittleEndian.Uint32(pay[7:11])
+	e.Entered = pay[11]
+	return
+}
+
+type NewIdentityResolvingKeyEvent struct {
+	StoreHint     bool
+	RandomAddress Address // resolvable private address in use, zero if the identity address was used
+	Key           IdentityResolvingKey
+}
+
+func (e *NewIdentityResolvingKeyEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 30 {
+		return ErrPayloadFormat
+	}
+	e.StoreHint = pay[0] != 0
+	err = e.RandomAddress.UpdateFromPayload(pay[1:7])
+	if err != nil {
+		return
+	}
+	return e.Key.UpdateFromPayload(pay[7:])
+}
+
+type NewSignatureResolvingKeyEvent struct {
+	StoreHint bool
+	Key       SignatureResolvingKey
+}
+
+func (e *NewSignatureResolvingKeyEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 25 {
+		return ErrPayloadFormat
+	}
+	e.StoreHint = pay[0] != 0
+	return e.Key.UpdateFromPayload(pay[1:])
+}
+
+type DeviceAddedEvent struct {
+	Address AddressInfo
+	Action  DeviceAction
+}
+
+func (e *DeviceAddedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 8 {
+		return ErrPayloadFormat
+	}
+	err = e.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	e.Action = DeviceAction(pay[7])
+	return
+}
+
+type DeviceRemovedEvent struct {
+	Address AddressInfo
+}
+
+func (e *DeviceRemovedEvent) UpdateFromPayload(pay []byte) (err error) {
+	return e.Address.UpdateFromPayload(pay)
+}
+
+type NewConnectionParameterEvent struct {
+	StoreHint             bool
+	Address               AddressInfo
+	MinConnectionInterval uint16
+	MaxConnectionInterval uint16
+	ConnectionLatency     uint16
+	SupervisionTimeout    uint16
+}
+
+func (e *NewConnectionParameterEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 16 {
+		return ErrPayloadFormat
+	}
+	e.StoreHint = pay[0] != 0
+	err = e.Address.UpdateFromPayload(pay[1:8])
+	if err != nil {
+		return
+	}
+	e.MinConnectionInterval = binary.LittleEndian.Uint16(pay[8:10])
+	e.MaxConnectionInterval = binary.LittleEndian.Uint16(pay[10:12])
+	e.ConnectionLatency = binary.LittleEndian.Uint16(pay[12:14])
+	e.SupervisionTimeout = binary.LittleEndian.Uint16(pay[14:16])
+	return
+}
+
+type NewConfigurationOptionsEvent struct {
+	MissingOptions uint32
+}
+
+func (e *NewConfigurationOptionsEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 4 {
+		return ErrPayloadFormat
+	}
+	e.MissingOptions = binary.LittleEndian.Uint32(pay)
+	return
+}
+
+type ExtendedIndexEvent struct {
+	ControllerType byte
+	ControllerBus  byte
+}
+
+func (e *ExtendedIndexEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 2 {
+		return ErrPayloadFormat
+	}
+	e.ControllerType = pay[0]
+	e.ControllerBus = pay[1]
+	return
+}
+
+type ExtendedIndexAddedEvent struct{ ExtendedIndexEvent }
+type ExtendedIndexRemovedEvent struct{ ExtendedIndexEvent }
+
+type LocalOutOfBandExtendedDataUpdatedEvent struct {
+	AddressType byte
+	EIR         bt_ad.AdvertisingData
+}
+
+func (e *LocalOutOfBandExtendedDataUpdatedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) < 3 {
+		return ErrPayloadFormat
+	}
+	e.AddressType = pay[0]
+	eirLen := int(binary.LittleEndian.Uint16(pay[1:3]))
+	if len(pay) != 3+eirLen {
+		return ErrPayloadFormat
+	}
+	return e.EIR.UpdateFromPayload(pay[3:])
+}
+
+type AdvertisingAddedEvent struct {
+	Instance byte
+}
+
+func (e *AdvertisingAddedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 1 {
+		return ErrPayloadFormat
+	}
+	e.Instance = pay[0]
+	return
+}
+
+type AdvertisingRemovedEvent struct {
+	Instance byte
+}
+
+func (e *AdvertisingRemovedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 1 {
+		return ErrPayloadFormat
+	}
+	e.Instance = pay[0]
+	return
+}
+
+type ExtendedControllerInformationChangedEvent struct {
+	EIR bt_ad.AdvertisingData
+}
+
+func (e *ExtendedControllerInformationChangedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) < 2 {
+		return ErrPayloadFormat
+	}
+	eirLen := int(binary.LittleEndian.Uint16(pay[0:2]))
+	if len(pay) != 2+eirLen {
+		return ErrPayloadFormat
+	}
+	return e.EIR.UpdateFromPayload(pay[2:])
+}
+
+type PhyConfigurationChangedEvent struct {
+	SelectedPhys uint32
+}
+
+func (e *PhyConfigurationChangedEvent) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 4 {
+		return ErrPayloadFormat
+	}
+	e.SelectedPhys = binary.LittleEndian.Uint32(pay)
+	return
+}
+
+var evtPayloadConstructors = genEvtPayloadConstructors()
+
+func genEvtPayloadConstructors() (cMap map[EvtCode]func() ParsePayload) {
+	cMap = make(map[EvtCode]func() ParsePayload)
+	cMap[EVT_COMMAND_COMPLETE] = func() ParsePayload { return &CommandCompleteEvent{} }
+	cMap[EVT_COMMAND_STATUS] = func() ParsePayload { return &CommandStatusEvent{} }
+	cMap[EVT_CONTROLLER_ERROR] = func() ParsePayload { return &ControllerErrorEvent{} }
+	cMap[EVT_INDEX_ADDED] = func() ParsePayload { return &IndexAddedEvent{} }
+	cMap[EVT_INDEX_REMOVED] = func() ParsePayload { return &IndexRemovedEvent{} }
+	cMap[EVT_NEW_SETTINGS] = func() ParsePayload { return &NewSettingsEvent{} }
+	cMap[EVT_CLASS_OF_DEVICE_CHANGED] = func() ParsePayload { return &ClassOfDeviceChangedEvent{} }
+	cMap[EVT_LOCAL_NAME_CHANGED] = func() ParsePayload { return &LocalNameChangedEvent{} }
+	cMap[EVT_NEW_LINK_KEY] = func() ParsePayload { return &NewLinkKeyEvent{} }
+	cMap[EVT_NEW_LONG_TERM_KEY] = func() ParsePayload { return &NewLongTermKeyEvent{} }
+	cMap[EVT_DEVICE_CONNECTED] = func() ParsePayload { return &DeviceConnectedEvent{} }
+	cMap[EVT_DEVICE_DISCONNECTED] = func() ParsePayload { return &DeviceDisconnectedEvent{} }
+	cMap[EVT_CONNECT_FAILED] = func() ParsePayload { return &ConnectFailedEvent{} }
+	cMap[EVT_PIN_CODE_REQUEST] = func() ParsePayload { return &PinCodeRequestEvent{} }
+	cMap[EVT_USER_CONFIRMATION_REQUEST] = func() ParsePayload { return &UserConfirmationRequestEvent{} }
+	cMap[EVT_USER_PASSKEY_REQUEST] = func() ParsePayload { return &UserPasskeyRequestEvent{} }
+	cMap[EVT_AUTHENTICATION_FAILED] = func() ParsePayload { return &AuthenticationFailedEvent{} }
+	cMap[EVT_DEVICE_FOUND] = func() ParsePayload { return &DeviceFoundEvent{} }
+	cMap[EVT_DISCOVERING] = func() ParsePayload { return &DiscoveringEvent{} }
+	cMap[EVT_DEVICE_BLOCKED] = func() ParsePayload { return &DeviceBlockedEvent{} }
+	cMap[EVT_DEVICE_UNBLOCKED] = func() ParsePayload { return &DeviceUnblockedEvent{} }
+	cMap[EVT_DEVICE_UNPAIRED] = func() ParsePayload { return &DeviceUnpairedEvent{} }
+	cMap[EVT_PASSKEY_NOTIFY] = func() ParsePayload { return &PasskeyNotifyEvent{} }
+	cMap[EVT_NEW_IDENTITY_RESOLVING_KEY] = func() ParsePayload { return &NewIdentityResolvingKeyEvent{} }
+	cMap[EVT_NEW_SIGNATURE_RESOLVING_KEY] = func() ParsePayload { return &NewSignatureResolvingKeyEvent{} }
+	cMap[EVT_DEVICE_ADDED] = func() ParsePayload { return &DeviceAddedEvent{} }
+	cMap[EVT_DEVICE_REMOVED] = func() ParsePayload { return &DeviceRemovedEvent{} }
+	cMap[EVT_NEW_CONNECTION_PARAMETER] = func() ParsePayload { return &NewConnectionParameterEvent{} }
+	cMap[EVT_UNCONFIGURED_INDEX_ADDED] = func() ParsePayload { return &UnconfiguredIndexAddedEvent{} }
+	cMap[EVT_UNCONFIGURED_INDEX_REMOVED] = func() ParsePayload { return &UnconfiguredIndexRemovedEvent{} }
+	cMap[EVT_NEW_CONFIGURATION_OPTIONS] = func() ParsePayload { return &NewConfigurationOptionsEvent{} }
+	cMap[EVT_EXTENDED_INDEX_ADDED] = func() ParsePayload { return &ExtendedIndexAddedEvent{} }
+	cMap[EVT_EXTENDED_INDEX_REMOVED] = func() ParsePayload { return &ExtendedIndexRemovedEvent{} }
+	cMap[EVT_LOCAL_OUT_OF_BAND_EXTENDED_DATA_UPDATE] = func() ParsePayload { return &LocalOutOfBandExtendedDataUpdatedEvent{} }
+	cMap[EVT_EXTENDED_ADVERTISING_ADDED] = func() ParsePayload { return &AdvertisingAddedEvent{} }
+	cMap[EVT_EXTENDED_ADVERTISING_REMOVED] = func() ParsePayload { return &AdvertisingRemovedEvent{} }
+	cMap[EVT_EXTENDED_CONTROLLER_INFORMATION_CHANGED] = func() ParsePayload { return &ExtendedControllerInformationChangedEvent{} }
+	cMap[EVT_PHY_CONFIGURATION_CHANGED] = func() ParsePayload { return &PhyConfigurationChangedEvent{} }
+
+	return cMap
+}
+
+// Decodes the event payload into the typed struct for the EventCode of the event.
+// Events with an unknown EventCode are returned as *UnknownEvent
+func (e Event) Decode() (payload ParsePayload, err error) {
+	if constructor, exists := evtPayloadConstructors[e.EventCode]; exists {
+		payload = constructor()
+	} else {
+		payload = &UnknownEvent{}
+	}
+	err = payload.UpdateFromPayload(e.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
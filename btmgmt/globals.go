@@ -1,7 +1,9 @@
 package btmgmt
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -20,10 +22,28 @@ var (
 	ErrPayloadFormat        = errors.New("Unexpected payload format")
 	ErrSockClose            = errors.New("Error closing socket")
 	ErrCmdTimeout           = errors.New("command reached timeout")
+	ErrInvalidUUID          = errors.New("Invalid UUID format")
+	ErrInvalidAddress       = errors.New("Invalid Bluetooth address")
+	ErrPinCodeLength        = errors.New("PIN code exceeds 16 bytes")
+	ErrMgmtConnDown         = errors.New("Management connection is down (reconnecting)")
+	ErrConnectionLost       = errors.New("Management connection died before command result arrived")
 )
 
 const defaultCommandTimeout = time.Second * 30 // Indicates when a command without an event in response should time out
 
+// Context for commands issued without a caller supplied context
+func defaultCmdContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), defaultCommandTimeout)
+}
+
+// Maps the error of an ended context to the errors returned by command methods
+func ctxErr(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrCmdTimeout
+	}
+	return ctx.Err()
+}
+
 /*
 Packet Structures
 =================
@@ -97,6 +117,72 @@ const (
 	LIMITED_DISCOVERABLE Discoverability = 0x02
 )
 
+type AddressType byte
+
+const (
+	ADDRESS_TYPE_BR_EDR    AddressType = 0x00
+	ADDRESS_TYPE_LE_PUBLIC AddressType = 0x01
+	ADDRESS_TYPE_LE_RANDOM AddressType = 0x02
+)
+
+// IO capabilities used for Set IO Capability and Pair Device
+type IoCapability byte
+
+const (
+	IO_CAPABILITY_DISPLAY_ONLY       IoCapability = 0x00
+	IO_CAPABILITY_DISPLAY_YES_NO     IoCapability = 0x01
+	IO_CAPABILITY_KEYBOARD_ONLY      IoCapability = 0x02
+	IO_CAPABILITY_NO_INPUT_NO_OUTPUT IoCapability = 0x03
+	IO_CAPABILITY_KEYBOARD_DISPLAY   IoCapability = 0x04
+)
+
+// Actions of Add Device and the Device Added event
+type DeviceAction byte
+
+const (
+	DEVICE_ACTION_BACKGROUND_SCAN DeviceAction = 0x00 // LE only, report the device while background scanning
+	DEVICE_ACTION_ALLOW_INCOMING  DeviceAction = 0x01 // BR/EDR: allow incoming connections, LE: connect on directed advertising
+	DEVICE_ACTION_AUTO_CONNECT    DeviceAction = 0x02 // LE only, connect whenever the device advertises
+)
+
+// Bitmask of address types, used by Start Discovery, Stop Discovery and the Discovering event
+type DiscoveryAddressTypes byte
+
+const (
+	DISCOVERY_ADDRESS_TYPE_BR_EDR    DiscoveryAddressTypes = 1 << 0
+	DISCOVERY_ADDRESS_TYPE_LE_PUBLIC DiscoveryAddressTypes = 1 << 1
+	DISCOVERY_ADDRESS_TYPE_LE_RANDOM DiscoveryAddressTypes = 1 << 2
+
+	DISCOVERY_ADDRESS_TYPES_LE  = DISCOVERY_ADDRESS_TYPE_LE_PUBLIC | DISCOVERY_ADDRESS_TYPE_LE_RANDOM
+	DISCOVERY_ADDRESS_TYPES_ALL = DISCOVERY_ADDRESS_TYPE_BR_EDR | DISCOVERY_ADDRESS_TYPES_LE
+)
+
+func (a DeviceAction) String() string {
+	switch a {
+	case DEVICE_ACTION_BACKGROUND_SCAN:
+		return "background scan"
+	case DEVICE_ACTION_ALLOW_INCOMING:
+		return "allow incoming"
+	case DEVICE_ACTION_AUTO_CONNECT:
+		return "auto-connect"
+	default:
+		return fmt.Sprintf("unknown device action 0x%.2x", byte(a))
+	}
+}
+
+func (at AddressType) String() string {
+	switch at {
+	case ADDRESS_TYPE_BR_EDR:
+		return "BR/EDR"
+	case ADDRESS_TYPE_LE_PUBLIC:
+		return "LE Public"
+	case ADDRESS_TYPE_LE_RANDOM:
+		return "LE Random"
+	default:
+		return fmt.Sprintf("unknown address type 0x%.2x", byte(at))
+	}
+}
+
 type CmdCode uint16
 
 const (
@@ -143,8 +229,22 @@ const (
 	CMD_SET_ADVERTISING                     CmdCode = 0x29
 	CMD_SET_BR_EDR                          CmdCode = 0x2A
 	CMD_SET_STATIC_ADDRESS                  CmdCode = 0x2B
+	CMD_SET_PRIVACY                         CmdCode = 0x2F
+	CMD_LOAD_IDENTITY_RESOLVING_KEYS        CmdCode = 0x30
+	CMD_GET_CONNECTION_INFORMATION          CmdCode = 0x31
+	CMD_GET_CLOCK_INFORMATION               CmdCode = 0x32
+	CMD_ADD_DEVICE                          CmdCode = 0x33
+	CMD_REMOVE_DEVICE                       CmdCode = 0x34
+	CMD_START_SERVICE_DISCOVERY             CmdCode = 0x3A
+	CMD_READ_EXT_LOCAL_OUT_OF_BOUND_DATA    CmdCode = 0x3B
+	CMD_READ_ADVERTISING_FEATURES           CmdCode = 0x3D
+	CMD_ADD_ADVERTISING                     CmdCode = 0x3E
+	CMD_REMOVE_ADVERTISING                  CmdCode = 0x3F
+	CMD_GET_ADVERTISING_SIZE_INFORMATION    CmdCode = 0x40
 	// ToDo: define missing
-	CMD_SET_PHY_CONFIGURATION CmdCode = 0x44
+	CMD_SET_PHY_CONFIGURATION          CmdCode = 0x44
+	CMD_ADD_EXT_ADVERTISING_PARAMETERS CmdCode = 0x54
+	CMD_ADD_EXT_ADVERTISING_DATA       CmdCode = 0x55
 )
 
 type EvtCode uint16
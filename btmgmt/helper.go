@@ -1,5 +1,7 @@
 package btmgmt
 
+import "github.com/mame82/mblue-toolz/bt_ad"
+
 func copyReverse(src []byte) []byte {
 	dst := make([]byte, len(src))
 	copy(dst, src)
@@ -22,3 +24,13 @@ func testBit(in uint32, n uint8) bool {
 	return in&(1<<n) > 0
 }
 
+
+// converts a 128 bit UUID string (f.e. "0000180d-0000-1000-8000-00805f9b34fb") to its
+// little endian wire format
+func uuidToPayload(uuid string) (pay []byte, err error) {
+	pay, err = bt_ad.UUIDToPayload(uuid)
+	if err != nil {
+		return nil, ErrInvalidUUID
+	}
+	return
+}
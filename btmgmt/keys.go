@@ -0,0 +1,196 @@
+package btmgmt
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+type LinkKeyType byte
+
+const (
+	LINK_KEY_TYPE_COMBINATION               LinkKeyType = 0x00
+	LINK_KEY_TYPE_LOCAL_UNIT                LinkKeyType = 0x01
+	LINK_KEY_TYPE_REMOTE_UNIT               LinkKeyType = 0x02
+	LINK_KEY_TYPE_DEBUG_COMBINATION         LinkKeyType = 0x03
+	LINK_KEY_TYPE_UNAUTHENTICATED_COMB_P192 LinkKeyType = 0x04
+	LINK_KEY_TYPE_AUTHENTICATED_COMB_P192   LinkKeyType = 0x05
+	LINK_KEY_TYPE_CHANGED_COMBINATION       LinkKeyType = 0x06
+	LINK_KEY_TYPE_UNAUTHENTICATED_COMB_P256 LinkKeyType = 0x07
+	LINK_KEY_TYPE_AUTHENTICATED_COMB_P256   LinkKeyType = 0x08
+)
+
+type LongTermKeyType byte
+
+const (
+	LTK_TYPE_UNAUTHENTICATED_LEGACY LongTermKeyType = 0x00
+	LTK_TYPE_AUTHENTICATED_LEGACY   LongTermKeyType = 0x01
+	LTK_TYPE_UNAUTHENTICATED_P256   LongTermKeyType = 0x02
+	LTK_TYPE_AUTHENTICATED_P256     LongTermKeyType = 0x03
+	LTK_TYPE_DEBUG_P256             LongTermKeyType = 0x04
+)
+
+// BR/EDR link key, as used by New Link Key event and Load Link Keys command
+type LinkKey struct {
+	Address   AddressInfo
+	KeyType   LinkKeyType
+	Value     [16]byte
+	PinLength byte
+}
+
+func (k *LinkKey) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 25 {
+		return ErrPayloadFormat
+	}
+	err = k.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	k.KeyType = LinkKeyType(pay[7])
+	copy(k.Value[:], pay[8:24])
+	k.PinLength = pay[24]
+	return
+}
+
+func (k LinkKey) toPayload() []byte {
+	pay := append(k.Address.toPayload(), byte(k.KeyType))
+	pay = append(pay, k.Value[:]...)
+	return append(pay, k.PinLength)
+}
+
+// LE long term key, as used by New Long Term Key event and Load Long Term Keys command
+type LongTermKey struct {
+	Address               AddressInfo
+	KeyType               LongTermKeyType
+	Master                bool // true if the key is used when acting as master (initiator)
+	EncryptionSize        byte
+	EncryptionDiversifier uint16
+	RandomNumber          [8]byte
+	Value                 [16]byte
+}
+
+func (k *LongTermKey) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 36 {
+		return ErrPayloadFormat
+	}
+	err = k.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	k.KeyType = LongTermKeyType(pay[7])
+	k.Master = pay[8] != 0
+	k.EncryptionSize = pay[9]
+	k.EncryptionDiversifier = binary.LittleEndian.Uint16(pay[10:12])
+	copy(k.RandomNumber[:], pay[12:20])
+	copy(k.Value[:], pay[20:36])
+	return
+}
+
+func (k LongTermKey) toPayload() []byte {
+	pay := append(k.Address.toPayload(), byte(k.KeyType))
+	var bMaster byte
+	if k.Master {
+		bMaster = 1
+	}
+	pay = append(pay, bMaster, k.EncryptionSize, 0, 0)
+	binary.LittleEndian.PutUint16(pay[len(pay)-2:], k.EncryptionDiversifier)
+	pay = append(pay, k.RandomNumber[:]...)
+	return append(pay, k.Value[:]...)
+}
+
+// LE identity resolving key of a remote device
+type IdentityResolvingKey struct {
+	Address AddressInfo // identity address
+	Value   [16]byte
+}
+
+func (k *IdentityResolvingKey) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 23 {
+		return ErrPayloadFormat
+	}
+	err = k.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	copy(k.Value[:], pay[7:23])
+	return
+}
+
+func (k IdentityResolvingKey) toPayload() []byte {
+	return append(k.Address.toPayload(), k.Value[:]...)
+}
+
+// LE connection signature resolving key of a remote device
+type SignatureResolvingKey struct {
+	Address AddressInfo
+	KeyType byte
+	Value   [16]byte
+}
+
+func (k *SignatureResolvingKey) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 24 {
+		return ErrPayloadFormat
+	}
+	err = k.Address.UpdateFromPayload(pay[0:7])
+	if err != nil {
+		return
+	}
+	k.KeyType = pay[7]
+	copy(k.Value[:], pay[8:24])
+	return
+}
+
+// Replaces the link keys known by the kernel for the given controller with the given keys.
+// If debugKeys is true, debug keys are accepted and stored by the kernel.
+func (bm BtMgmt) LoadLinkKeys(controllerID uint16, debugKeys bool, keys []LinkKey) (err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.LoadLinkKeysContext(ctx, controllerID, debugKeys, keys)
+}
+
+func (bm BtMgmt) LoadLinkKeysContext(ctx context.Context, controllerID uint16, debugKeys bool, keys []LinkKey) (err error) {
+	params := make([]byte, 3)
+	if debugKeys {
+		params[0] = 1
+	}
+	binary.LittleEndian.PutUint16(params[1:3], uint16(len(keys)))
+	for _, key := range keys {
+		params = append(params, key.toPayload()...)
+	}
+	_, err = bm.runCmd(ctx, controllerID, CMD_LOAD_LINK_KEYS, params...)
+	return
+}
+
+// Replaces the long term keys known by the kernel for the given controller with the given keys.
+func (bm BtMgmt) LoadLongTermKeys(controllerID uint16, keys []LongTermKey) (err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.LoadLongTermKeysContext(ctx, controllerID, keys)
+}
+
+func (bm BtMgmt) LoadLongTermKeysContext(ctx context.Context, controllerID uint16, keys []LongTermKey) (err error) {
+	params := make([]byte, 2)
+	binary.LittleEndian.PutUint16(params[0:2], uint16(len(keys)))
+	for _, key := range keys {
+		params = append(params, key.toPayload()...)
+	}
+	_, err = bm.runCmd(ctx, controllerID, CMD_LOAD_LONG_TERM_KEYS, params...)
+	return
+}
+
+// Replaces the identity resolving keys of remote devices known by the kernel for the given controller, which
+// are used to resolve the private addresses of these devices to their identity address.
+func (bm BtMgmt) LoadIdentityResolvingKeys(controllerID uint16, keys []IdentityResolvingKey) (err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.LoadIdentityResolvingKeysContext(ctx, controllerID, keys)
+}
+
+func (bm BtMgmt) LoadIdentityResolvingKeysContext(ctx context.Context, controllerID uint16, keys []IdentityResolvingKey) (err error) {
+	params := make([]byte, 2)
+	binary.LittleEndian.PutUint16(params[0:2], uint16(len(keys)))
+	for _, key := range keys {
+		params = append(params, key.toPayload()...)
+	}
+	_, err = bm.runCmd(ctx, controllerID, CMD_LOAD_IDENTITY_RESOLVING_KEYS, params...)
+	return
+}
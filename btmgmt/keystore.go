@@ -0,0 +1,319 @@
+package btmgmt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Keys of remote devices, bonded with a single controller
+type StoredKeys struct {
+	LinkKeys               []LinkKey
+	LongTermKeys           []LongTermKey
+	IdentityResolvingKeys  []IdentityResolvingKey
+	SignatureResolvingKeys []SignatureResolvingKey
+}
+
+// Persistent storage for the keys handed out by the kernel. Keys are stored per controller, identified
+// by the controller address (controller indices could change between reboots).
+// Storing a key replaces an existing key of the same kind for the same device.
+type KeyStore interface {
+	StoreLinkKey(controller Address, key LinkKey) error
+	StoreLongTermKey(controller Address, key LongTermKey) error
+	StoreIdentityResolvingKey(controller Address, key IdentityResolvingKey) error
+	StoreSignatureResolvingKey(controller Address, key SignatureResolvingKey) error
+	RemoveDeviceKeys(controller Address, device AddressInfo) error
+	LoadKeys(controller Address) (keys *StoredKeys, err error)
+}
+
+func (sk *StoredKeys) putLinkKey(key LinkKey) {
+	for i, k := range sk.LinkKeys {
+		if k.Address.Equal(key.Address) {
+			sk.LinkKeys[i] = key
+			return
+		}
+	}
+	sk.LinkKeys = append(sk.LinkKeys, key)
+}
+
+func (sk *StoredKeys) putLongTermKey(key LongTermKey) {
+	// a device could have two LTKs (one for each role) with legacy pairing
+	for i, k := range sk.LongTermKeys {
+		if k.Address.Equal(key.Address) && k.Master == key.Master {
+			sk.LongTermKeys[i] = key
+			return
+		}
+	}
+	sk.LongTermKeys = append(sk.LongTermKeys, key)
+}
+
+func (sk *StoredKeys) putIdentityResolvingKey(key IdentityResolvingKey) {
+	for i, k := range sk.IdentityResolvingKeys {
+		if k.Address.Equal(key.Address) {
+			sk.IdentityResolvingKeys[i] = key
+			return
+		}
+	}
+	sk.IdentityResolvingKeys = append(sk.IdentityResolvingKeys, key)
+}
+
+func (sk *StoredKeys) putSignatureResolvingKey(key SignatureResolvingKey) {
+	for i, k := range sk.SignatureResolvingKeys {
+		if k.Address.Equal(key.Address) && k.KeyType == key.KeyType {
+			sk.SignatureResolvingKeys[i] = key
+			return
+		}
+	}
+	sk.SignatureResolvingKeys = append(sk.SignatureResolvingKeys, key)
+}
+
+func (sk *StoredKeys) removeDevice(device AddressInfo) {
+	lks := sk.LinkKeys[:0]
+	for _, k := range sk.LinkKeys {
+		if !k.Address.Equal(device) {
+			lks = append(lks, k)
+		}
+	}
+	sk.LinkKeys = lks
+	ltks := sk.LongTermKeys[:0]
+	for _, k := range sk.LongTermKeys {
+		if !k.Address.Equal(device) {
+			ltks = append(ltks, k)
+		}
+	}
+	sk.LongTermKeys = ltks
+	irks := sk.IdentityResolvingKeys[:0]
+	for _, k := range sk.IdentityResolvingKeys {
+		if !k.Address.Equal(device) {
+			irks = append(irks, k)
+		}
+	}
+	sk.IdentityResolvingKeys = irks
+	csrks := sk.SignatureResolvingKeys[:0]
+	for _, k := range sk.SignatureResolvingKeys {
+		if !k.Address.Equal(device) {
+			csrks = append(csrks, k)
+		}
+	}
+	sk.SignatureResolvingKeys = csrks
+}
+
+// KeyStore implementation, which keeps the keys of every controller in a JSON file
+// (<dir>/<controller address>.json)
+type FileKeyStore struct {
+	*sync.Mutex
+	dir string
+}
+
+func NewFileKeyStore(dir string) (store *FileKeyStore, err error) {
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileKeyStore{
+		Mutex: &sync.Mutex{},
+		dir:   dir,
+	}, nil
+}
+
+func (fs *FileKeyStore) path(controller Address) string {
+	return filepath.Join(fs.dir, controller.Addr.String()+".json")
+}
+
+func (fs *FileKeyStore) read(controller Address) (keys *StoredKeys, err error) {
+	keys = &StoredKeys{}
+	data, err := ioutil.ReadFile(fs.path(controller))
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, keys)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// writes to a temporary file first, to avoid corrupting the store if the device looses power
+func (fs *FileKeyStore) write(controller Address, keys *StoredKeys) (err error) {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return
+	}
+	tmpPath := fs.path(controller) + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return
+	}
+	return os.Rename(tmpPath, fs.path(controller))
+}
+
+func (fs *FileKeyStore) update(controller Address, modify func(keys *StoredKeys)) (err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	keys, err := fs.read(controller)
+	if err != nil {
+		return
+	}
+	modify(keys)
+	return fs.write(controller, keys)
+}
+
+func (fs *FileKeyStore) StoreLinkKey(controller Address, key LinkKey) error {
+	return fs.update(controller, func(keys *StoredKeys) { keys.putLinkKey(key) })
+}
+
+func (fs *FileKeyStore) StoreLongTermKey(controller Address, key LongTermKey) error {
+	return fs.update(controller, func(keys *StoredKeys) { keys.putLongTermKey(key) })
+}
+
+func (fs *FileKeyStore) StoreIdentityResolvingKey(controller Address, key IdentityResolvingKey) error {
+	return fs.update(controller, func(keys *StoredKeys) { keys.putIdentityResolvingKey(key) })
+}
+
+func (fs *FileKeyStore) StoreSignatureResolvingKey(controller Address, key SignatureResolvingKey) error {
+	return fs.update(controller, func(keys *StoredKeys) { keys.putSignatureResolvingKey(key) })
+}
+
+func (fs *FileKeyStore) RemoveDeviceKeys(controller Address, device AddressInfo) error {
+	return fs.update(controller, func(keys *StoredKeys) { keys.removeDevice(device) })
+}
+
+func (fs *FileKeyStore) LoadKeys(controller Address) (keys *StoredKeys, err error) {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.read(controller)
+}
+
+type keyStoreRunner struct {
+	bm          BtMgmt
+	store       KeyStore
+	controllers map[uint16]Address
+}
+
+func (r *keyStoreRunner) controllerAddress(controllerID uint16) (addr Address, err error) {
+	if addr, exists := r.controllers[controllerID]; exists {
+		return addr, nil
+	}
+	info, err := r.bm.ReadControllerInformation(controllerID)
+	if err != nil {
+		return
+	}
+	r.controllers[controllerID] = info.Address
+	return info.Address, nil
+}
+
+// hands the stored keys of the given controller over to the kernel. The key types are loaded independently,
+// so a failing load (f.e. of LE keys on a BR/EDR only controller) doesn't prevent loading the others.
+func (r *keyStoreRunner) loadKeys(controllerID uint16) (err error) {
+	addr, err := r.controllerAddress(controllerID)
+	if err != nil {
+		return
+	}
+	keys, err := r.store.LoadKeys(addr)
+	if err != nil {
+		return
+	}
+	return errors.Join(
+		ignoreNotSupported(r.bm.LoadLinkKeys(controllerID, false, keys.LinkKeys)),
+		ignoreNotSupported(r.bm.LoadLongTermKeys(controllerID, keys.LongTermKeys)),
+		ignoreNotSupported(r.bm.LoadIdentityResolvingKeys(controllerID, keys.IdentityResolvingKeys)),
+	)
+}
+
+// controllers without BR/EDR or LE support reject loading the respective keys
+func ignoreNotSupported(err error) error {
+	var mErr *MgmtError
+	if errors.As(err, &mErr) && mErr.Status == CMD_STATUS_NOT_SUPPORTED {
+		return nil
+	}
+	return err
+}
+
+func (r *keyStoreRunner) handleEvent(evt TypedEvent) (err error) {
+	if evt.EventCode == EVT_INDEX_REMOVED {
+		delete(r.controllers, evt.ControllerIdx)
+		return
+	}
+	if evt.EventCode == EVT_INDEX_ADDED {
+		return r.loadKeys(evt.ControllerIdx)
+	}
+
+	addr, err := r.controllerAddress(evt.ControllerIdx)
+	if err != nil {
+		return
+	}
+	switch e := evt.Payload.(type) {
+	case *NewLinkKeyEvent:
+		if e.StoreHint {
+			err = r.store.StoreLinkKey(addr, e.Key)
+		}
+	case *NewLongTermKeyEvent:
+		if e.StoreHint {
+			err = r.store.StoreLongTermKey(addr, e.Key)
+		}
+	case *NewIdentityResolvingKeyEvent:
+		if e.StoreHint {
+			err = r.store.StoreIdentityResolvingKey(addr, e.Key)
+		}
+	case *NewSignatureResolvingKeyEvent:
+		if e.StoreHint {
+			err = r.store.StoreSignatureResolvingKey(addr, e.Key)
+		}
+	case *DeviceUnpairedEvent:
+		err = r.store.RemoveDeviceKeys(addr, e.Address)
+	}
+	return
+}
+
+// Feeds the given KeyStore with all new keys (only those the kernel hints to store persistently) and
+// removes keys of unpaired devices, till ctx is done. The stored link keys, long term keys and identity
+// resolving keys are loaded into the kernel for all present controllers and for each controller added later on.
+func (bm BtMgmt) RunKeyStore(ctx context.Context, store KeyStore) (err error) {
+	runner := &keyStoreRunner{
+		bm:          bm,
+		store:       store,
+		controllers: make(map[uint16]Address),
+	}
+	evts, err := bm.subscribeInit(ctx, SubscriptionFilter{
+		EventCodes: []EvtCode{
+			EVT_INDEX_ADDED,
+			EVT_INDEX_REMOVED,
+			EVT_NEW_LINK_KEY,
+			EVT_NEW_LONG_TERM_KEY,
+			EVT_NEW_IDENTITY_RESOLVING_KEY,
+			EVT_NEW_SIGNATURE_RESOLVING_KEY,
+			EVT_DEVICE_UNPAIRED,
+		},
+	}, func() error {
+		indexList, rErr := bm.ReadControllerIndexList()
+		if rErr != nil {
+			return rErr
+		}
+		for _, idx := range indexList.Indices {
+			if lErr := runner.loadKeys(idx); lErr != nil {
+				fmt.Printf("Loading stored keys for controller %d failed: %v\n", idx, lErr)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	go func() {
+		for evt := range evts {
+			if hErr := runner.handleEvent(evt); hErr != nil {
+				fmt.Printf("Key store failed to handle event %#x of controller %d: %v\n", evt.EventCode, evt.ControllerIdx, hErr)
+			}
+		}
+	}()
+	return
+}
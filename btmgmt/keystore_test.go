@@ -0,0 +1,48 @@
+package btmgmt_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+	"github.com/mame82/mblue-toolz/btmgmt/mgmttest"
+)
+
+// the fake kernel doesn't know Load Link Keys / Load Long Term Keys, which must not prevent loading the IRKs
+func TestKeyStoreLoadsKeyTypesIndependently(t *testing.T) {
+	k, bm := newTestKernel(t)
+	k.HandleCmd(btmgmt.CMD_LOAD_LINK_KEYS, func(req *mgmttest.Request) (btmgmt.CmdStatus, []byte) {
+		return btmgmt.CMD_STATUS_NOT_SUPPORTED, nil
+	})
+	k.HandleCmd(btmgmt.CMD_LOAD_LONG_TERM_KEYS, func(req *mgmttest.Request) (btmgmt.CmdStatus, []byte) {
+		return btmgmt.CMD_STATUS_FAILED, nil
+	})
+
+	store, err := btmgmt.NewFileKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl := btmgmt.Address{Addr: mgmttest.DefaultController().Address}
+	dev, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}, btmgmt.ADDRESS_TYPE_LE_PUBLIC)
+	irk := btmgmt.IdentityResolvingKey{Address: dev, Value: [16]byte{1, 2, 3, 4}}
+	for _, sErr := range []error{
+		store.StoreLinkKey(ctrl, btmgmt.LinkKey{Address: dev}),
+		store.StoreLongTermKey(ctrl, btmgmt.LongTermKey{Address: dev}),
+		store.StoreIdentityResolvingKey(ctrl, irk),
+	} {
+		if sErr != nil {
+			t.Fatal(sErr)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err = bm.RunKeyStore(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "loaded IRKs", func() bool { c, _ := k.Controller(0); return len(c.IRKs) == 1 })
+	if c, _ := k.Controller(0); !c.IRKs[0].Address.Equal(dev) || c.IRKs[0].Value != irk.Value {
+		t.Errorf("loaded IRK %+v, want %+v", c.IRKs[0], irk)
+	}
+}
@@ -0,0 +1,198 @@
+package btmgmt
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mame82/mblue-toolz/bt_cod"
+)
+
+const (
+	MAX_LOCAL_NAME_LENGTH       = 248 // without terminating 0x00
+	MAX_SHORT_LOCAL_NAME_LENGTH = 10  // without terminating 0x00
+)
+
+// Passed to RemoveUUID, in order to remove all UUIDs
+const UUID_ALL = "00000000-0000-0000-0000-000000000000"
+
+func parseDeviceClassResult(payload []byte) (res *bt_cod.CoD, err error) {
+	res = new(bt_cod.CoD)
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Sets major and minor device class (f.e. bt_cod.MAJOR_PERIPHERAL, bt_cod.MINOR_PERIPHERAL_KEYBOARD), the major
+// service classes are derived from the UUIDs added with AddUUID. The resulting Class of Device is returned.
+func (bm BtMgmt) SetDeviceClass(controllerID uint16, major bt_cod.MajorDeviceClass, minor bt_cod.MinorDeviceClass) (res *bt_cod.CoD, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetDeviceClassContext(ctx, controllerID, major, minor)
+}
+
+func (bm BtMgmt) SetDeviceClassContext(ctx context.Context, controllerID uint16, major bt_cod.MajorDeviceClass, minor bt_cod.MinorDeviceClass) (res *bt_cod.CoD, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_SET_DEVICE_CLASS, byte(major&0x1f), byte(minor&0x3f)<<2)
+	if err != nil {
+		return
+	}
+	return parseDeviceClassResult(payload)
+}
+
+// Sets the local name (max. 248 bytes) and short name (max. 10 bytes). Returns ErrPayloadFormat if the names
+// exceed the maximum length.
+func (bm BtMgmt) SetLocalName(controllerID uint16, name string, shortName string) (res *LocalNameChangedEvent, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetLocalNameContext(ctx, controllerID, name, shortName)
+}
+
+func (bm BtMgmt) SetLocalNameContext(ctx context.Context, controllerID uint16, name string, shortName string) (res *LocalNameChangedEvent, err error) {
+	if len(name) > MAX_LOCAL_NAME_LENGTH || len(shortName) > MAX_SHORT_LOCAL_NAME_LENGTH {
+		return nil, ErrPayloadFormat
+	}
+	params := make([]byte, 260) // 0x00 padded
+	copy(params[0:249], name)
+	copy(params[249:260], shortName)
+	payload, err := bm.runCmd(ctx, controllerID, CMD_SET_LOCAL_NAME, params...)
+	if err != nil {
+		return
+	}
+	res = &LocalNameChangedEvent{}
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Adds a 128 bit UUID to the EIR data. The service hint is merged into the major service classes of the Class of
+// Device, which is returned. Only service classes from bt_cod.SERVICE_CLASS_POSITIONING upwards could be hinted.
+func (bm BtMgmt) AddUUID(controllerID uint16, uuid string, serviceHint bt_cod.ServiceClass) (res *bt_cod.CoD, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.AddUUIDContext(ctx, controllerID, uuid, serviceHint)
+}
+
+func (bm BtMgmt) AddUUIDContext(ctx context.Context, controllerID uint16, uuid string, serviceHint bt_cod.ServiceClass) (res *bt_cod.CoD, err error) {
+	params, err := uuidToPayload(uuid)
+	if err != nil {
+		return
+	}
+	params = append(params, byte(serviceHint>>16))
+	payload, err := bm.runCmd(ctx, controllerID, CMD_ADD_UUID, params...)
+	if err != nil {
+		return
+	}
+	return parseDeviceClassResult(payload)
+}
+
+// Removes a UUID added with AddUUID (all UUIDs for UUID_ALL), the resulting Class of Device is returned.
+func (bm BtMgmt) RemoveUUID(controllerID uint16, uuid string) (res *bt_cod.CoD, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.RemoveUUIDContext(ctx, controllerID, uuid)
+}
+
+func (bm BtMgmt) RemoveUUIDContext(ctx context.Context, controllerID uint16, uuid string) (res *bt_cod.CoD, err error) {
+	params, err := uuidToPayload(uuid)
+	if err != nil {
+		return
+	}
+	payload, err := bm.runCmd(ctx, controllerID, CMD_REMOVE_UUID, params...)
+	if err != nil {
+		return
+	}
+	return parseDeviceClassResult(payload)
+}
+
+// Applies changes carried by New Settings, Class Of Device Changed and Local Name Changed events, as well as by
+// the results of commands changing settings, class or name. Returns true if the event has been applied.
+// The kernel doesn't send change events to the socket which issued the command, thus command results have
+// to be taken into account, too.
+func (ci *ControllerInformation) UpdateFromEvent(evt TypedEvent) (applied bool) {
+	switch e := evt.Payload.(type) {
+	case *NewSettingsEvent:
+		ci.CurrentSettings = e.CurrentSettings
+	case *ClassOfDeviceChangedEvent:
+		ci.ClassOfDevice = e.ClassOfDevice
+	case *LocalNameChangedEvent:
+		ci.Name = e.Name
+		ci.ShortName = e.ShortName
+	case *CommandCompleteEvent:
+		if e.Status != CMD_STATUS_SUCCESS {
+			return false
+		}
+		return ci.updateFromCmdResult(e.CmdCode, e.ReturnParams)
+	default:
+		return false
+	}
+	return true
+}
+
+func (ci *ControllerInformation) updateFromCmdResult(cmdCode CmdCode, payload []byte) (applied bool) {
+	switch cmdCode {
+	case CMD_SET_POWERED, CMD_SET_DISCOVERABLE, CMD_SET_CONNECTABLE, CMD_SET_FAST_CONNECTABLE, CMD_SET_BONDABLE,
+		CMD_SET_LINK_SECURITY, CMD_SET_SECURE_SIMPLE_PAIRING, CMD_SET_HIGH_SPEED, CMD_SET_LOW_ENERGY,
+		CMD_SET_ADVERTISING, CMD_SET_BR_EDR:
+		return ci.CurrentSettings.UpdateFromPayload(payload) == nil
+	case CMD_SET_DEVICE_CLASS, CMD_ADD_UUID, CMD_REMOVE_UUID:
+		return ci.ClassOfDevice.UpdateFromPayload(payload) == nil
+	case CMD_SET_LOCAL_NAME:
+		names := &LocalNameChangedEvent{}
+		if names.UpdateFromPayload(payload) != nil {
+			return false
+		}
+		ci.Name = names.Name
+		ci.ShortName = names.ShortName
+		return true
+	}
+	return false
+}
+
+// Keeps the ControllerInformation of a single controller current, see TrackControllerInformation
+type ControllerInformationTracker struct {
+	*sync.Mutex
+	info ControllerInformation
+}
+
+// Returns a copy of the current information
+func (t *ControllerInformationTracker) Info() ControllerInformation {
+	t.Lock()
+	defer t.Unlock()
+	return t.info
+}
+
+// Reads the controller information and keeps it current, till ctx is done
+func (bm BtMgmt) TrackControllerInformation(ctx context.Context, controllerID uint16) (tracker *ControllerInformationTracker, err error) {
+	tracker = &ControllerInformationTracker{Mutex: &sync.Mutex{}}
+	// subscribe before reading, to not miss changes in between
+	evts, err := bm.subscribeInit(ctx, SubscriptionFilter{
+		ControllerIndices: []uint16{controllerID},
+		EventCodes: []EvtCode{
+			EVT_COMMAND_COMPLETE,
+			EVT_NEW_SETTINGS,
+			EVT_CLASS_OF_DEVICE_CHANGED,
+			EVT_LOCAL_NAME_CHANGED,
+		},
+	}, func() error {
+		info, rErr := bm.ReadControllerInformationContext(ctx, controllerID)
+		if rErr != nil {
+			return rErr
+		}
+		tracker.info = *info
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for evt := range evts {
+			tracker.Lock()
+			tracker.info.UpdateFromEvent(evt)
+			tracker.Unlock()
+		}
+	}()
+	return
+}
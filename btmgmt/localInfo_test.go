@@ -0,0 +1,118 @@
+package btmgmt_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/mame82/mblue-toolz/bt_cod"
+	"github.com/mame82/mblue-toolz/btmgmt"
+)
+
+func TestSetDeviceClass(t *testing.T) {
+	k, bm := newTestKernel(t)
+	class, err := bm.SetDeviceClass(0, bt_cod.MAJOR_PERIPHERAL, bt_cod.MINOR_PERIPHERAL_KEYBOARD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the minor class is sent in bits 2..7
+	if params := lastParams(k, btmgmt.CMD_SET_DEVICE_CLASS); !bytes.Equal(params, []byte{0x05, 0x40}) {
+		t.Errorf("wrong parameters % x", params)
+	}
+	if *class != 0x000540 || class.MajorDeviceClass() != bt_cod.MAJOR_PERIPHERAL || class.MinorDeviceClass() != bt_cod.MINOR_PERIPHERAL_KEYBOARD {
+		t.Errorf("wrong class %v", class)
+	}
+	if ctrl, _ := k.Controller(0); ctrl.ClassOfDevice != [3]byte{0x40, 0x05, 0x00} {
+		t.Errorf("kernel has class % x", ctrl.ClassOfDevice)
+	}
+}
+
+func TestAddUUIDServiceHint(t *testing.T) {
+	k, bm := newTestKernel(t)
+	if _, err := bm.SetDeviceClass(0, bt_cod.MAJOR_PERIPHERAL, bt_cod.MINOR_PERIPHERAL_KEYBOARD); err != nil {
+		t.Fatal(err)
+	}
+	class, err := bm.AddUUID(0, "00001124-0000-1000-8000-00805f9b34fb", bt_cod.SERVICE_CLASS_RENDERING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 128 bit UUID in little endian order, followed by the service hint (bits 16..23 of the CoD)
+	want := []byte{0xfb, 0x34, 0x9b, 0x5f, 0x80, 0x00, 0x00, 0x80, 0x00, 0x10, 0x00, 0x00, 0x24, 0x11, 0x00, 0x00, 0x04}
+	if params := lastParams(k, btmgmt.CMD_ADD_UUID); !bytes.Equal(params, want) {
+		t.Errorf("wrong parameters % x, want % x", params, want)
+	}
+	if *class != 0x040540 || !class.HasServiceClass(bt_cod.SERVICE_CLASS_RENDERING) {
+		t.Errorf("wrong class %v", class)
+	}
+
+	if class, err = bm.AddUUID(0, "0000110a-0000-1000-8000-00805f9b34fb", bt_cod.SERVICE_CLASS_AUDIO); err != nil {
+		t.Fatal(err)
+	}
+	if class.ServiceClasses() != bt_cod.SERVICE_CLASS_RENDERING|bt_cod.SERVICE_CLASS_AUDIO {
+		t.Errorf("service hints not merged: %v", class)
+	}
+	if class, err = bm.RemoveUUID(0, btmgmt.UUID_ALL); err != nil {
+		t.Fatal(err)
+	}
+	if *class != 0x000540 {
+		t.Errorf("service classes not removed: %v", class)
+	}
+	if ctrl, _ := k.Controller(0); len(ctrl.UUIDs) != 0 {
+		t.Errorf("kernel has UUIDs %v", ctrl.UUIDs)
+	}
+	if _, err = bm.AddUUID(0, "1124", 0); err == nil {
+		t.Error("AddUUID accepted a 16 bit UUID")
+	}
+}
+
+func TestTrackControllerInformation(t *testing.T) {
+	k, bm := newTestKernel(t)
+	conn, err := k.NewMgmtConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	other := btmgmt.NewBtMgmtForConnection(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker, err := bm.TrackControllerInformation(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := tracker.Info(); info.Name != "fake controller" || info.CurrentSettings.Powered {
+		t.Fatalf("wrong initial information %+v", info)
+	}
+
+	// own commands are tracked by their results, those of other sockets by events
+	if _, err = bm.SetLocalName(0, "P4wnP1", "P4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bm.SetDeviceClass(0, bt_cod.MAJOR_PERIPHERAL, bt_cod.MINOR_PERIPHERAL_KEYBOARD); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.AddUUID(0, "00001124-0000-1000-8000-00805f9b34fb", bt_cod.SERVICE_CLASS_RENDERING); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.SetPowered(0, true); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "current information", func() bool {
+		info := tracker.Info()
+		return info.Name == "P4wnP1" && info.ShortName == "P4" && info.ClassOfDevice == 0x040540 && info.CurrentSettings.Powered
+	})
+	if _, err = other.SetLocalName(0, "other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bm.SetPowered(0, false); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "current information", func() bool {
+		info := tracker.Info()
+		return info.Name == "other" && info.ShortName == "" && !info.CurrentSettings.Powered
+	})
+
+	if _, err = bm.SetLocalName(0, string(make([]byte, btmgmt.MAX_LOCAL_NAME_LENGTH+1)), ""); err != btmgmt.ErrPayloadFormat {
+		t.Errorf("SetLocalName with 249 octets returned %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package btmgmt
+
+import "fmt"
+
+// Error returned for commands, which the kernel answered with a status other than CMD_STATUS_SUCCESS.
+// errors.Is matches the sentinel errors of CmdStatusErrorMap (ErrUnknownCommandStatus for statuses not contained),
+// f.e. errors.Is(err, CmdStatusErrorMap[CMD_STATUS_BUSY])
+type MgmtError struct {
+	Opcode        CmdCode
+	ControllerIdx uint16
+	Status        CmdStatus
+}
+
+// returns nil for CMD_STATUS_SUCCESS
+func newMgmtError(cmd command, status CmdStatus) error {
+	if status == CMD_STATUS_SUCCESS {
+		return nil
+	}
+	return &MgmtError{
+		Opcode:        cmd.CommandCode,
+		ControllerIdx: cmd.ControllerIdx,
+		Status:        status,
+	}
+}
+
+func (e *MgmtError) Error() string {
+	ctrl := fmt.Sprintf("controller %d", e.ControllerIdx)
+	if e.ControllerIdx == INDEX_CONTROLLER_NONE {
+		ctrl = "no controller"
+	}
+	return fmt.Sprintf("mgmt command %#04x (%s) failed with status %#02x: %s", uint16(e.Opcode), ctrl, uint16(e.Status), e.Status.String())
+}
+
+func (e *MgmtError) Is(target error) bool {
+	sentinel, known := CmdStatusErrorMap[e.Status]
+	if !known {
+		return target == ErrUnknownCommandStatus
+	}
+	return sentinel != nil && target == sentinel
+}
+
+// Description of the status, according to mgmt-api.txt
+func (s CmdStatus) String() string {
+	if desc, exists := cmdStatusDescriptionMap[s]; exists {
+		return desc
+	}
+	return fmt.Sprintf("Unknown status %#02x", uint16(s))
+}
+
+var cmdStatusDescriptionMap = genCmdStatusDescriptionMap()
+
+func genCmdStatusDescriptionMap() (dMap map[CmdStatus]string) {
+	dMap = make(map[CmdStatus]string)
+	dMap[CMD_STATUS_SUCCESS] = "Success"
+	dMap[CMD_STATUS_UNKNOWN_COMMAND] = "Unknown Command (the opcode isn't supported by the kernel)"
+	dMap[CMD_STATUS_NOT_CONNECTED] = "Not Connected (no connection to the remote device)"
+	dMap[CMD_STATUS_FAILED] = "Failed (generic failure, f.e. the HCI command failed)"
+	dMap[CMD_STATUS_CONNECT_FAILED] = "Connect Failed (connection to the remote device couldn't be established)"
+	dMap[CMD_STATUS_AUTHENTICATION_FAILED] = "Authentication Failed (pairing or authentication with the remote device failed)"
+	dMap[CMD_STATUS_NOT_PAIRED] = "Not Paired (the remote device isn't paired)"
+	dMap[CMD_STATUS_NO_RESOURCES] = "No Resources (the kernel or controller ran out of resources, f.e. memory or advertising instances)"
+	dMap[CMD_STATUS_TIMEOUT] = "Timeout (the controller or remote device didn't respond in time)"
+	dMap[CMD_STATUS_ALREADY_CONNECTED] = "Already Connected (a connection to the remote device exists already)"
+	dMap[CMD_STATUS_BUSY] = "Busy (another operation is in progress, f.e. a running discovery or pairing)"
+	dMap[CMD_STATUS_REJECTED] = "Rejected (the command isn't allowed in the current state, f.e. a required setting is disabled)"
+	dMap[CMD_STATUS_NOT_SUPPORTED] = "Not Supported (the controller doesn't support the requested feature)"
+	dMap[CMD_STATUS_INVALID_PARAMETERS] = "Invalid Parameters (malformed or out of range command parameters)"
+	dMap[CMD_STATUS_DISCONNECTED] = "Disconnected (the connection was terminated while the command was processed)"
+	dMap[CMD_STATUS_NOT_POWERED] = "Not Powered (the command requires a powered controller)"
+	dMap[CMD_STATUS_CANCELLED] = "Cancelled (the operation was cancelled, f.e. by Cancel Pair Device)"
+	dMap[CMD_STATUS_INVALID_INDEX] = "Invalid Index (no controller with the given index exists, or an index was given for a global command)"
+	dMap[CMD_STATUS_RF_KILLED] = "RFKilled (the controller is blocked by rfkill)"
+	dMap[CMD_STATUS_ALREADY_PAIRED] = "Already Paired (the remote device is paired already)"
+	dMap[CMD_STATUS_PERMISSION_DENIED] = "Permission Denied (the command requires CAP_NET_ADMIN or isn't allowed on this socket)"
+	return dMap
+}
@@ -0,0 +1,1018 @@
+package mgmttest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/mame82/mblue-toolz/bt_ad"
+	"github.com/mame82/mblue-toolz/btmgmt"
+)
+
+// Version reported by Read Management Version Information
+const (
+	MGMT_VERSION  = 1
+	MGMT_REVISION = 14
+)
+
+// Bits of the supported and current settings of a controller
+const (
+	SETTING_POWERED            = uint32(btmgmt.SETTING_POWERED)
+	SETTING_CONNECTABLE        = uint32(btmgmt.SETTING_CONNECTABLE)
+	SETTING_FAST_CONNECTABLE   = uint32(btmgmt.SETTING_FAST_CONNECTABLE)
+	SETTING_DISCOVERABLE       = uint32(btmgmt.SETTING_DISCOVERABLE)
+	SETTING_BONDABLE           = uint32(btmgmt.SETTING_BONDABLE)
+	SETTING_LINK_SECURITY      = uint32(btmgmt.SETTING_LINK_SECURITY)
+	SETTING_SSP                = uint32(btmgmt.SETTING_SSP)
+	SETTING_BR_EDR             = uint32(btmgmt.SETTING_BR_EDR)
+	SETTING_HIGH_SPEED         = uint32(btmgmt.SETTING_HIGH_SPEED)
+	SETTING_LE                 = uint32(btmgmt.SETTING_LE)
+	SETTING_ADVERTISING        = uint32(btmgmt.SETTING_ADVERTISING)
+	SETTING_SECURE_CONNECTIONS = uint32(btmgmt.SETTING_SECURE_CONNECTIONS)
+	SETTING_PRIVACY            = uint32(btmgmt.SETTING_PRIVACY)
+	SETTING_STATIC_ADDRESS     = uint32(btmgmt.SETTING_STATIC_ADDRESS)
+)
+
+// State of a fake controller
+type Controller struct {
+	Address           net.HardwareAddr
+	BluetoothVersion  byte
+	Manufacturer      uint16
+	SupportedSettings uint32
+	CurrentSettings   uint32
+	ClassOfDevice     [3]byte // wire order (minor, major, service classes)
+	Name              string
+	ShortName         string
+	Discovering       btmgmt.DiscoveryAddressTypes  // 0 if no discovery is running
+	UUIDs             []UUID                        // added by Add UUID, replaced (not modified) on changes
+	Connections       []Connection                  // see Kernel.ConnectDevice, replaced (not modified) on changes
+	Blocked           []btmgmt.AddressInfo          // block list, replaced (not modified) on changes
+	Advertising       []byte                        // registered advertising instances, replaced (not modified) on changes
+	RemoteOOBData     []RemoteOOBData               // added by Add Remote OOB Data, replaced (not modified) on changes
+	StaticAddress     net.HardwareAddr              // set by Set Static Address, nil if not set
+	LocalIRK          [16]byte                      // set by Set Privacy
+	IRKs              []btmgmt.IdentityResolvingKey // loaded by Load Identity Resolving Keys
+	Devices           []btmgmt.DeviceListEntry      // added by Add Device, replaced (not modified) on changes
+}
+
+// OOB data of a remote device, added by Add Remote Out Of Band Data
+type RemoteOOBData struct {
+	Address btmgmt.AddressInfo
+	Data    btmgmt.OOBData
+}
+
+// Connection to a remote device, with the values reported by Get Connection Information
+type Connection struct {
+	Address    btmgmt.AddressInfo
+	RSSI       int8
+	TxPower    int8
+	MaxTxPower int8
+}
+
+// UUID added by Add UUID (wire order)
+type UUID struct {
+	Value       [16]byte
+	ServiceHint byte
+}
+
+// Powered off dual mode controller, supporting all settings handled by the fake kernel
+func DefaultController() Controller {
+	return Controller{
+		Address:          net.HardwareAddr{0x00, 0x1a, 0x7d, 0xda, 0x71, 0x13},
+		BluetoothVersion: 0x08, // 4.2
+		Manufacturer:     0x000a,
+		SupportedSettings: SETTING_POWERED | SETTING_CONNECTABLE | SETTING_FAST_CONNECTABLE | SETTING_DISCOVERABLE |
+			SETTING_BONDABLE | SETTING_LINK_SECURITY | SETTING_SSP | SETTING_BR_EDR | SETTING_HIGH_SPEED |
+			SETTING_LE | SETTING_ADVERTISING | SETTING_PRIVACY | SETTING_STATIC_ADDRESS,
+		CurrentSettings: SETTING_BR_EDR | SETTING_LE,
+		Name:            "fake controller",
+	}
+}
+
+func (c *Controller) toInfoPayload() []byte {
+	pay := make([]byte, 280)
+	copy(pay[0:6], addressToPayload(c.Address))
+	pay[6] = c.BluetoothVersion
+	binary.LittleEndian.PutUint16(pay[7:9], c.Manufacturer)
+	binary.LittleEndian.PutUint32(pay[9:13], c.SupportedSettings)
+	binary.LittleEndian.PutUint32(pay[13:17], c.CurrentSettings)
+	copy(pay[17:20], c.ClassOfDevice[:])
+	copy(pay[20:268], c.Name) // keeps at least one terminating 0x00
+	copy(pay[269:279], c.ShortName)
+	return pay
+}
+
+func settingsPayload(settings uint32) []byte {
+	pay := make([]byte, 4)
+	binary.LittleEndian.PutUint32(pay, settings)
+	return pay
+}
+
+func genDefaultHandlers() (handlers map[btmgmt.CmdCode]CmdHandler) {
+	handlers = make(map[btmgmt.CmdCode]CmdHandler)
+	handlers[btmgmt.CMD_READ_MANAGEMENT_VERSION_INFORMATION] = handleReadVersion
+	handlers[btmgmt.CMD_READ_MANAGEMENT_SUPPORTED_COMMANDS] = handleReadCommands
+	handlers[btmgmt.CMD_READ_CONTROLLER_INDEX_LIST] = handleReadIndexList
+	handlers[btmgmt.CMD_READ_CONTROLLER_INFORMATION] = handleReadInfo
+	handlers[btmgmt.CMD_SET_POWERED] = settingHandler(SETTING_POWERED)
+	handlers[btmgmt.CMD_SET_DISCOVERABLE] = settingHandler(SETTING_DISCOVERABLE)
+	handlers[btmgmt.CMD_SET_CONNECTABLE] = settingHandler(SETTING_CONNECTABLE)
+	handlers[btmgmt.CMD_SET_FAST_CONNECTABLE] = settingHandler(SETTING_FAST_CONNECTABLE)
+	handlers[btmgmt.CMD_SET_BONDABLE] = settingHandler(SETTING_BONDABLE)
+	handlers[btmgmt.CMD_SET_LINK_SECURITY] = settingHandler(SETTING_LINK_SECURITY)
+	handlers[btmgmt.CMD_SET_SECURE_SIMPLE_PAIRING] = settingHandler(SETTING_SSP)
+	handlers[btmgmt.CMD_SET_HIGH_SPEED] = settingHandler(SETTING_HIGH_SPEED)
+	handlers[btmgmt.CMD_SET_LOW_ENERGY] = settingHandler(SETTING_LE)
+	handlers[btmgmt.CMD_SET_ADVERTISING] = settingHandler(SETTING_ADVERTISING)
+	handlers[btmgmt.CMD_SET_BR_EDR] = settingHandler(SETTING_BR_EDR)
+	handlers[btmgmt.CMD_SET_DEVICE_CLASS] = handleSetDeviceClass
+	handlers[btmgmt.CMD_SET_LOCAL_NAME] = handleSetLocalName
+	handlers[btmgmt.CMD_ADD_UUID] = handleAddUUID
+	handlers[btmgmt.CMD_REMOVE_UUID] = handleRemoveUUID
+	handlers[btmgmt.CMD_START_DICOVERY] = handleStartDiscovery
+	handlers[btmgmt.CMD_STOP_DICOVERY] = handleStopDiscovery
+	handlers[btmgmt.CMD_START_SERVICE_DISCOVERY] = handleStartServiceDiscovery
+	handlers[btmgmt.CMD_DISCONNECT] = handleDisconnect
+	handlers[btmgmt.CMD_GET_CONECTIONS] = handleGetConnections
+	handlers[btmgmt.CMD_GET_CONNECTION_INFORMATION] = handleGetConnectionInformation
+	handlers[btmgmt.CMD_GET_CLOCK_INFORMATION] = handleGetClockInformation
+	handlers[btmgmt.CMD_BLOCK_DEVICE] = handleBlockDevice
+	handlers[btmgmt.CMD_UNBLOCK_DEVICE] = handleUnblockDevice
+	handlers[btmgmt.CMD_READ_ADVERTISING_FEATURES] = handleReadAdvertisingFeatures
+	handlers[btmgmt.CMD_ADD_ADVERTISING] = handleAddAdvertising
+	handlers[btmgmt.CMD_REMOVE_ADVERTISING] = handleRemoveAdvertising
+	handlers[btmgmt.CMD_ADD_EXT_ADVERTISING_PARAMETERS] = handleAddExtAdvertisingParameters
+	handlers[btmgmt.CMD_ADD_EXT_ADVERTISING_DATA] = handleAddExtAdvertisingData
+	handlers[btmgmt.CMD_READ_LOCAL_OUT_OF_BOUND_DATA] = handleReadLocalOOBData
+	handlers[btmgmt.CMD_READ_EXT_LOCAL_OUT_OF_BOUND_DATA] = handleReadExtLocalOOBData
+	handlers[btmgmt.CMD_ADD_REMOTE_OUT_OF_BOUND_DATA] = handleAddRemoteOOBData
+	handlers[btmgmt.CMD_REMOVE_REMOTE_OUT_OF_BOUND_DATA] = handleRemoveRemoteOOBData
+	handlers[btmgmt.CMD_SET_STATIC_ADDRESS] = handleSetStaticAddress
+	handlers[btmgmt.CMD_SET_PRIVACY] = handleSetPrivacy
+	handlers[btmgmt.CMD_LOAD_IDENTITY_RESOLVING_KEYS] = handleLoadIRKs
+	handlers[btmgmt.CMD_ADD_DEVICE] = handleAddDevice
+	handlers[btmgmt.CMD_REMOVE_DEVICE] = handleRemoveDevice
+	return
+}
+
+// Fetches the addressed controller, status is CMD_STATUS_INVALID_INDEX if it doesn't exist
+func (r *Request) controller() (ctrl Controller, status btmgmt.CmdStatus) {
+	ctrl, ok := r.Kernel.Controller(r.ControllerIdx)
+	if !ok {
+		return ctrl, btmgmt.CMD_STATUS_INVALID_INDEX
+	}
+	return ctrl, btmgmt.CMD_STATUS_SUCCESS
+}
+
+func handleReadVersion(req *Request) (btmgmt.CmdStatus, []byte) {
+	if req.ControllerIdx != btmgmt.INDEX_CONTROLLER_NONE || len(req.Params) != 0 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	pay := []byte{MGMT_VERSION, 0, 0}
+	binary.LittleEndian.PutUint16(pay[1:3], MGMT_REVISION)
+	return btmgmt.CMD_STATUS_SUCCESS, pay
+}
+
+func handleReadCommands(req *Request) (btmgmt.CmdStatus, []byte) {
+	if req.ControllerIdx != btmgmt.INDEX_CONTROLLER_NONE || len(req.Params) != 0 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	req.Kernel.Lock()
+	var cmds []uint16
+	for code := range req.Kernel.handlers {
+		cmds = append(cmds, uint16(code))
+	}
+	req.Kernel.Unlock()
+	pay := make([]byte, 4, 4+2*len(cmds))
+	binary.LittleEndian.PutUint16(pay[0:2], uint16(len(cmds)))
+	// supported events aren't reported, as any event could be injected
+	for _, code := range cmds {
+		pay = append(pay, byte(code), byte(code>>8))
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, pay
+}
+
+func handleReadIndexList(req *Request) (btmgmt.CmdStatus, []byte) {
+	if req.ControllerIdx != btmgmt.INDEX_CONTROLLER_NONE || len(req.Params) != 0 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	indices := req.Kernel.controllerIndices()
+	pay := make([]byte, 2, 2+2*len(indices))
+	binary.LittleEndian.PutUint16(pay[0:2], uint16(len(indices)))
+	for _, idx := range indices {
+		pay = append(pay, byte(idx), byte(idx>>8))
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, pay
+}
+
+func handleReadInfo(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, ctrl.toInfoPayload()
+}
+
+// Handles the Set Powered, Set Connectable ... commands, which switch a single setting and reply with the current settings
+func settingHandler(setting uint32) CmdHandler {
+	return func(req *Request) (btmgmt.CmdStatus, []byte) {
+		ctrl, status := req.controller()
+		if status != btmgmt.CMD_STATUS_SUCCESS {
+			return status, nil
+		}
+		if ctrl.SupportedSettings&setting == 0 {
+			return btmgmt.CMD_STATUS_NOT_SUPPORTED, nil
+		}
+		if setting == SETTING_DISCOVERABLE {
+			// additional timeout parameter, 0x02 is limited discoverable mode
+			if len(req.Params) != 3 || req.Params[0] > 2 {
+				return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+			}
+		} else if len(req.Params) != 1 || req.Params[0] > 1 {
+			return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+		}
+
+		var settings, oldSettings uint32
+		req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+			oldSettings = c.CurrentSettings
+			if req.Params[0] != 0 {
+				c.CurrentSettings |= setting
+			} else {
+				c.CurrentSettings &^= setting
+			}
+			settings = c.CurrentSettings
+		})
+		if settings != oldSettings {
+			req.EmitEventToOthers(btmgmt.EVT_NEW_SETTINGS, req.ControllerIdx, settingsPayload(settings))
+		}
+		return btmgmt.CMD_STATUS_SUCCESS, settingsPayload(settings)
+	}
+}
+
+func handleSetDeviceClass(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	// minor class uses bits 2..7, major class bits 0..4
+	if len(req.Params) != 2 || req.Params[1]&0x03 != 0 || req.Params[0]&0xe0 != 0 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	var class [3]byte
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		c.ClassOfDevice[0] = req.Params[1]
+		c.ClassOfDevice[1] = req.Params[0]
+		class = c.ClassOfDevice
+	})
+	req.EmitEventToOthers(btmgmt.EVT_CLASS_OF_DEVICE_CHANGED, req.ControllerIdx, class[:])
+	return btmgmt.CMD_STATUS_SUCCESS, class[:]
+}
+
+// the major service classes are the combined service hints of all UUIDs
+func (c *Controller) updateServiceClasses() {
+	var hints byte
+	for _, u := range c.UUIDs {
+		hints |= u.ServiceHint
+	}
+	c.ClassOfDevice[2] = hints
+}
+
+func handleAddUUID(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if len(req.Params) != 17 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	added := UUID{ServiceHint: req.Params[16]}
+	copy(added.Value[:], req.Params[0:16])
+	var class [3]byte
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		c.UUIDs = append(append([]UUID{}, c.UUIDs...), added)
+		c.updateServiceClasses()
+		class = c.ClassOfDevice
+	})
+	req.EmitEventToOthers(btmgmt.EVT_CLASS_OF_DEVICE_CHANGED, req.ControllerIdx, class[:])
+	return btmgmt.CMD_STATUS_SUCCESS, class[:]
+}
+
+func handleRemoveUUID(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if len(req.Params) != 16 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	var removed [16]byte
+	copy(removed[:], req.Params)
+	found := false
+	var class [3]byte
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		var remaining []UUID
+		for _, u := range c.UUIDs {
+			if removed == [16]byte{} || u.Value == removed {
+				found = true
+				continue
+			}
+			remaining = append(remaining, u)
+		}
+		if removed == [16]byte{} {
+			found = true // removing all UUIDs always succeeds
+		}
+		c.UUIDs = remaining
+		c.updateServiceClasses()
+		class = c.ClassOfDevice
+	})
+	if !found {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	req.EmitEventToOthers(btmgmt.EVT_CLASS_OF_DEVICE_CHANGED, req.ControllerIdx, class[:])
+	return btmgmt.CMD_STATUS_SUCCESS, class[:]
+}
+
+func handleSetLocalName(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if len(req.Params) != 260 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		c.Name = string(zeroTerminated(req.Params[0:249]))
+		c.ShortName = string(zeroTerminated(req.Params[249:260]))
+	})
+	req.EmitEventToOthers(btmgmt.EVT_LOCAL_NAME_CHANGED, req.ControllerIdx, req.Params)
+	return btmgmt.CMD_STATUS_SUCCESS, req.Params
+}
+
+func handleStartDiscovery(req *Request) (btmgmt.CmdStatus, []byte) {
+	if len(req.Params) != 1 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, req.Params
+	}
+	return req.startDiscovery()
+}
+
+// The UUID and RSSI filters aren't applied, devices are reported by the test with Kernel.EmitEvent anyways
+func handleStartServiceDiscovery(req *Request) (btmgmt.CmdStatus, []byte) {
+	p := req.Params
+	if len(p) < 4 || len(p) != 4+16*int(binary.LittleEndian.Uint16(p[2:4])) {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, p[:1]
+	}
+	return req.startDiscovery()
+}
+
+// starts the discovery for the address types of the first parameter
+func (req *Request) startDiscovery() (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	addressTypes := req.Params[0]
+	if addressTypes == 0 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, req.Params[:1]
+	}
+	if ctrl.CurrentSettings&SETTING_POWERED == 0 {
+		return btmgmt.CMD_STATUS_NOT_POWERED, req.Params[:1]
+	}
+	if ctrl.Discovering != 0 {
+		return btmgmt.CMD_STATUS_BUSY, req.Params[:1]
+	}
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		c.Discovering = btmgmt.DiscoveryAddressTypes(addressTypes)
+	})
+	req.Kernel.EmitEvent(btmgmt.EVT_DISCOVERING, req.ControllerIdx, []byte{addressTypes, 1})
+	return btmgmt.CMD_STATUS_SUCCESS, req.Params[:1]
+}
+
+func handleStopDiscovery(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if len(req.Params) != 1 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	if ctrl.Discovering == 0 || byte(ctrl.Discovering) != req.Params[0] {
+		return btmgmt.CMD_STATUS_REJECTED, req.Params
+	}
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		c.Discovering = 0
+	})
+	req.Kernel.EmitEvent(btmgmt.EVT_DISCOVERING, req.ControllerIdx, []byte{req.Params[0], 0})
+	return btmgmt.CMD_STATUS_SUCCESS, req.Params
+}
+
+// Parses the Address Info parameter (address and type) of a command
+func (r *Request) addressParam() (addr btmgmt.AddressInfo, status btmgmt.CmdStatus) {
+	if len(r.Params) != 7 || addr.UpdateFromPayload(r.Params) != nil {
+		return addr, btmgmt.CMD_STATUS_INVALID_PARAMETERS
+	}
+	return addr, btmgmt.CMD_STATUS_SUCCESS
+}
+
+func (c *Controller) connection(addr btmgmt.AddressInfo) (conn Connection, ok bool) {
+	for _, conn = range c.Connections {
+		if conn.Address.Equal(addr) {
+			return conn, true
+		}
+	}
+	return
+}
+
+func handleDisconnect(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	addr, status := req.addressParam()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if ctrl.CurrentSettings&SETTING_POWERED == 0 {
+		return btmgmt.CMD_STATUS_NOT_POWERED, req.Params
+	}
+	if !req.Kernel.removeConnection(req.ControllerIdx, addr) {
+		return btmgmt.CMD_STATUS_NOT_CONNECTED, req.Params
+	}
+	req.EmitEventToOthers(btmgmt.EVT_DEVICE_DISCONNECTED, req.ControllerIdx,
+		append(addressInfoPayload(addr), byte(btmgmt.DISCONNECT_REASON_TERMINATED_LOCAL_HOST)))
+	return btmgmt.CMD_STATUS_SUCCESS, req.Params
+}
+
+func handleGetConnections(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if len(req.Params) != 0 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	if ctrl.CurrentSettings&SETTING_POWERED == 0 {
+		return btmgmt.CMD_STATUS_NOT_POWERED, nil
+	}
+	pay := make([]byte, 2, 2+7*len(ctrl.Connections))
+	binary.LittleEndian.PutUint16(pay[0:2], uint16(len(ctrl.Connections)))
+	for _, conn := range ctrl.Connections {
+		pay = append(pay, addressInfoPayload(conn.Address)...)
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, pay
+}
+
+func handleGetConnectionInformation(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	addr, status := req.addressParam()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	// the kernel replies with the address and "not available" values on failure
+	unavailable := append(append([]byte{}, req.Params...), 127, 127, 127)
+	if ctrl.CurrentSettings&SETTING_POWERED == 0 {
+		return btmgmt.CMD_STATUS_NOT_POWERED, unavailable
+	}
+	conn, ok := ctrl.connection(addr)
+	if !ok {
+		return btmgmt.CMD_STATUS_NOT_CONNECTED, unavailable
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, append(append([]byte{}, req.Params...), byte(conn.RSSI), byte(conn.TxPower), byte(conn.MaxTxPower))
+}
+
+func handleGetClockInformation(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	addr, status := req.addressParam()
+	if status != btmgmt.CMD_STATUS_SUCCESS || addr.AddressType != btmgmt.ADDRESS_TYPE_BR_EDR {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	pay := make([]byte, 17)
+	copy(pay[0:7], req.Params)
+	if ctrl.CurrentSettings&SETTING_POWERED == 0 {
+		return btmgmt.CMD_STATUS_NOT_POWERED, pay
+	}
+	// the native clock ticks every 312.5 µs
+	binary.LittleEndian.PutUint32(pay[7:11], uint32(time.Now().UnixNano()/312500)&0x0fffffff)
+	binary.LittleEndian.PutUint16(pay[15:17], btmgmt.CLOCK_ACCURACY_UNKNOWN)
+	if !bytes.Equal(req.Params[0:6], make([]byte, 6)) {
+		if _, ok := ctrl.connection(addr); !ok {
+			return btmgmt.CMD_STATUS_NOT_CONNECTED, pay
+		}
+		copy(pay[11:15], pay[7:11]) // piconet clock of the fake controller is its own clock
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, pay
+}
+
+func handleBlockDevice(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	addr, status := req.addressParam()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	added := false
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		for _, b := range c.Blocked {
+			if b.Equal(addr) {
+				return
+			}
+		}
+		c.Blocked = append(append([]btmgmt.AddressInfo{}, c.Blocked...), addr)
+		added = true
+	})
+	if !added {
+		return btmgmt.CMD_STATUS_FAILED, req.Params // like the kernel, if the device is already blocked
+	}
+	req.EmitEventToOthers(btmgmt.EVT_DEVICE_BLOCKED, req.ControllerIdx, req.Params)
+	return btmgmt.CMD_STATUS_SUCCESS, req.Params
+}
+
+func handleUnblockDevice(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	addr, status := req.addressParam()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	removed := false
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		var remaining []btmgmt.AddressInfo
+		for _, b := range c.Blocked {
+			if b.Equal(addr) {
+				removed = true
+				continue
+			}
+			remaining = append(remaining, b)
+		}
+		c.Blocked = remaining
+	})
+	if !removed {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, req.Params
+	}
+	req.EmitEventToOthers(btmgmt.EVT_DEVICE_UNBLOCKED, req.ControllerIdx, req.Params)
+	return btmgmt.CMD_STATUS_SUCCESS, req.Params
+}
+
+// Limits reported by Read Advertising Features (legacy advertising)
+const (
+	advMaxInstances = 5
+	advMaxDataLen   = 31
+)
+
+func (c *Controller) hasAdvertising(instance byte) bool {
+	for _, i := range c.Advertising {
+		if i == instance {
+			return true
+		}
+	}
+	return false
+}
+
+// registers the instance, added is false if it has already been registered (thus it is replaced)
+func (req *Request) registerAdvertising(instance byte) (added bool, status btmgmt.CmdStatus) {
+	status = btmgmt.CMD_STATUS_SUCCESS
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		if c.hasAdvertising(instance) {
+			return
+		}
+		if len(c.Advertising) >= advMaxInstances {
+			status = btmgmt.CMD_STATUS_INVALID_PARAMETERS
+			return
+		}
+		c.Advertising = append(append([]byte{}, c.Advertising...), instance)
+		added = true
+	})
+	return
+}
+
+func handleReadAdvertisingFeatures(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if ctrl.SupportedSettings&SETTING_LE == 0 {
+		return btmgmt.CMD_STATUS_REJECTED, nil
+	}
+	supported := btmgmt.ADV_FLAG_CONNECTABLE | btmgmt.ADV_FLAG_DISCOVERABLE | btmgmt.ADV_FLAG_LIMITED_DISCOVERABLE |
+		btmgmt.ADV_FLAG_MANAGED_FLAGS | btmgmt.ADV_FLAG_TX_POWER | btmgmt.ADV_FLAG_APPEARANCE | btmgmt.ADV_FLAG_LOCAL_NAME
+	pay := make([]byte, 8)
+	binary.LittleEndian.PutUint32(pay[0:4], uint32(supported))
+	pay[4] = advMaxDataLen
+	pay[5] = advMaxDataLen
+	pay[6] = advMaxInstances
+	pay[7] = byte(len(ctrl.Advertising))
+	return btmgmt.CMD_STATUS_SUCCESS, append(pay, ctrl.Advertising...)
+}
+
+// checks the data lengths of Add Advertising and Add Extended Advertising Data, starting at the length octets
+func validAdvertisingData(p []byte) bool {
+	if len(p) < 2 {
+		return false
+	}
+	advLen, rspLen := int(p[0]), int(p[1])
+	return len(p) == 2+advLen+rspLen && advLen <= advMaxDataLen && rspLen <= advMaxDataLen
+}
+
+func handleAddAdvertising(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if ctrl.SupportedSettings&SETTING_LE == 0 {
+		return btmgmt.CMD_STATUS_REJECTED, nil
+	}
+	p := req.Params
+	if len(p) < 9 || p[0] < 1 || p[0] > advMaxInstances || !validAdvertisingData(p[9:]) {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	added, status := req.registerAdvertising(p[0])
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if added {
+		req.EmitEventToOthers(btmgmt.EVT_EXTENDED_ADVERTISING_ADDED, req.ControllerIdx, p[0:1])
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, p[0:1]
+}
+
+func handleRemoveAdvertising(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if len(req.Params) != 1 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	instance := req.Params[0]
+	var removed []byte
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		var remaining []byte
+		for _, i := range c.Advertising {
+			if instance == 0 || i == instance {
+				removed = append(removed, i)
+				continue
+			}
+			remaining = append(remaining, i)
+		}
+		c.Advertising = remaining
+	})
+	// like the kernel, removing an unknown instance fails, removing all instances doesn't
+	if instance != 0 && len(removed) == 0 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	for _, i := range removed {
+		req.EmitEventToOthers(btmgmt.EVT_EXTENDED_ADVERTISING_REMOVED, req.ControllerIdx, []byte{i})
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, req.Params
+}
+
+// Registers the instance without announcing it, the Advertising Added event is sent with the data
+func handleAddExtAdvertisingParameters(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if ctrl.SupportedSettings&SETTING_LE == 0 {
+		return btmgmt.CMD_STATUS_REJECTED, nil
+	}
+	p := req.Params
+	if len(p) != 18 || p[0] < 1 || p[0] > advMaxInstances {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	if _, status = req.registerAdvertising(p[0]); status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	txPower := int8(p[17])
+	if btmgmt.AdvertisingFlags(binary.LittleEndian.Uint32(p[1:5]))&btmgmt.ADV_PARAM_TX_POWER == 0 ||
+		txPower == btmgmt.ADV_TX_POWER_NO_PREFERENCE {
+		txPower = 0 // selected by the fake controller
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, []byte{p[0], byte(txPower), advMaxDataLen, advMaxDataLen}
+}
+
+func handleAddExtAdvertisingData(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	p := req.Params
+	if len(p) < 1 || !ctrl.hasAdvertising(p[0]) || !validAdvertisingData(p[1:]) {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	req.EmitEventToOthers(btmgmt.EVT_EXTENDED_ADVERTISING_ADDED, req.ControllerIdx, p[0:1])
+	return btmgmt.CMD_STATUS_SUCCESS, p[0:1]
+}
+
+// fresh random hash and randomizer values, P-256 values only with Secure Connections enabled
+func (c *Controller) newLocalOOBData() (data btmgmt.OOBData) {
+	rand.Read(data.Hash192[:])
+	rand.Read(data.Randomizer192[:])
+	if c.CurrentSettings&SETTING_SECURE_CONNECTIONS != 0 {
+		rand.Read(data.Hash256[:])
+		rand.Read(data.Randomizer256[:])
+	}
+	return
+}
+
+func handleReadLocalOOBData(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if len(req.Params) != 0 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	if ctrl.CurrentSettings&SETTING_POWERED == 0 {
+		return btmgmt.CMD_STATUS_NOT_POWERED, nil
+	}
+	if ctrl.CurrentSettings&SETTING_SSP == 0 {
+		return btmgmt.CMD_STATUS_NOT_SUPPORTED, nil
+	}
+	data := ctrl.newLocalOOBData()
+	return btmgmt.CMD_STATUS_SUCCESS, data.Payload()
+}
+
+func handleReadExtLocalOOBData(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if len(req.Params) != 1 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	addressTypes := btmgmt.DiscoveryAddressTypes(req.Params[0])
+	if ctrl.CurrentSettings&SETTING_POWERED == 0 {
+		return btmgmt.CMD_STATUS_NOT_POWERED, req.Params
+	}
+	data := ctrl.newLocalOOBData()
+	ad := bt_ad.New()
+	switch addressTypes {
+	case btmgmt.DISCOVERY_ADDRESS_TYPE_BR_EDR:
+		if ctrl.CurrentSettings&SETTING_BR_EDR == 0 {
+			return btmgmt.CMD_STATUS_REJECTED, req.Params
+		}
+		ad.Add(bt_ad.AD_CLASS_OF_DEVICE, ctrl.ClassOfDevice[:])
+		if ctrl.CurrentSettings&SETTING_SSP != 0 {
+			ad.Add(bt_ad.AD_SSP_HASH_P192, data.Hash192[:]).Add(bt_ad.AD_SSP_RANDOMIZER_P192, data.Randomizer192[:])
+		}
+		if ctrl.CurrentSettings&SETTING_SECURE_CONNECTIONS != 0 {
+			ad.Add(bt_ad.AD_SSP_HASH_P256, data.Hash256[:]).Add(bt_ad.AD_SSP_RANDOMIZER_P256, data.Randomizer256[:])
+		}
+	case btmgmt.DISCOVERY_ADDRESS_TYPES_LE:
+		if ctrl.CurrentSettings&SETTING_LE == 0 {
+			return btmgmt.CMD_STATUS_REJECTED, req.Params
+		}
+		addr := append(addressToPayload(ctrl.Address), 0x00) // public address
+		ad.Add(bt_ad.AD_LE_BLUETOOTH_DEVICE_ADDRESS, addr).Add(bt_ad.AD_LE_ROLE, []byte{byte(btmgmt.LE_ROLE_PERIPHERAL_PREFERRED)})
+		if ctrl.CurrentSettings&SETTING_SECURE_CONNECTIONS != 0 {
+			ad.Add(bt_ad.AD_LE_SC_CONFIRMATION_VALUE, data.Hash256[:]).Add(bt_ad.AD_LE_SC_RANDOM_VALUE, data.Randomizer256[:])
+		}
+		ad.AddFlags(bt_ad.FLAG_BR_EDR_NOT_SUPPORTED)
+	default:
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, req.Params
+	}
+	eir, _ := ad.Payload(0)
+	pay := []byte{byte(addressTypes), 0, 0}
+	binary.LittleEndian.PutUint16(pay[1:3], uint16(len(eir)))
+	pay = append(pay, eir...)
+	req.EmitEventToOthers(btmgmt.EVT_LOCAL_OUT_OF_BAND_EXTENDED_DATA_UPDATE, req.ControllerIdx, pay)
+	return btmgmt.CMD_STATUS_SUCCESS, pay
+}
+
+func handleAddRemoteOOBData(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	p := req.Params
+	if len(p) != 7+32 && len(p) != 7+64 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	entry := RemoteOOBData{}
+	if entry.Address.UpdateFromPayload(p[0:7]) != nil || entry.Data.UpdateFromPayload(p[7:]) != nil {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	if entry.Address.AddressType != btmgmt.ADDRESS_TYPE_BR_EDR && (len(p) != 7+64 || entry.Data.HasP192()) {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, p[0:7] // LE supports P-256 values only
+	}
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		remaining := []RemoteOOBData{entry}
+		for _, r := range c.RemoteOOBData {
+			if !r.Address.Equal(entry.Address) {
+				remaining = append(remaining, r)
+			}
+		}
+		c.RemoteOOBData = remaining
+	})
+	return btmgmt.CMD_STATUS_SUCCESS, p[0:7]
+}
+
+func handleRemoveRemoteOOBData(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	addr, status := req.addressParam()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	all := bytes.Equal(req.Params[0:6], make([]byte, 6))
+	removed := false
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		var remaining []RemoteOOBData
+		for _, r := range c.RemoteOOBData {
+			if all || r.Address.Equal(addr) {
+				removed = true
+				continue
+			}
+			remaining = append(remaining, r)
+		}
+		c.RemoteOOBData = remaining
+	})
+	if !removed && !all {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, req.Params
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, req.Params
+}
+
+// updates the settings of the controller with modify and announces changed settings to other sockets
+func (req *Request) updateSettings(modify func(c *Controller)) (settings uint32) {
+	var oldSettings uint32
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		oldSettings = c.CurrentSettings
+		modify(c)
+		settings = c.CurrentSettings
+	})
+	if settings != oldSettings {
+		req.EmitEventToOthers(btmgmt.EVT_NEW_SETTINGS, req.ControllerIdx, settingsPayload(settings))
+	}
+	return
+}
+
+func handleSetStaticAddress(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if ctrl.SupportedSettings&SETTING_LE == 0 {
+		return btmgmt.CMD_STATUS_NOT_SUPPORTED, nil
+	}
+	if ctrl.CurrentSettings&SETTING_POWERED != 0 {
+		return btmgmt.CMD_STATUS_REJECTED, nil
+	}
+	if len(req.Params) != 6 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	var addr btmgmt.Address
+	addr.UpdateFromPayload(req.Params)
+	isAny := bytes.Equal(req.Params, make([]byte, 6))
+	if !isAny && (addr.Addr[0]&0xc0 != 0xc0 || bytes.Equal(req.Params, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})) {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	settings := req.updateSettings(func(c *Controller) {
+		if isAny {
+			c.StaticAddress = nil
+			c.CurrentSettings &^= SETTING_STATIC_ADDRESS
+		} else {
+			c.StaticAddress = addr.Addr
+			c.CurrentSettings |= SETTING_STATIC_ADDRESS
+		}
+	})
+	return btmgmt.CMD_STATUS_SUCCESS, settingsPayload(settings)
+}
+
+func handleSetPrivacy(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if ctrl.SupportedSettings&SETTING_LE == 0 {
+		return btmgmt.CMD_STATUS_NOT_SUPPORTED, nil
+	}
+	if len(req.Params) != 17 || req.Params[0] > 2 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	if ctrl.CurrentSettings&SETTING_POWERED != 0 {
+		return btmgmt.CMD_STATUS_REJECTED, nil
+	}
+	settings := req.updateSettings(func(c *Controller) {
+		if req.Params[0] != 0 {
+			copy(c.LocalIRK[:], req.Params[1:17])
+			c.CurrentSettings |= SETTING_PRIVACY
+		} else {
+			c.LocalIRK = [16]byte{}
+			c.CurrentSettings &^= SETTING_PRIVACY
+		}
+	})
+	return btmgmt.CMD_STATUS_SUCCESS, settingsPayload(settings)
+}
+
+func handleLoadIRKs(req *Request) (btmgmt.CmdStatus, []byte) {
+	ctrl, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if ctrl.SupportedSettings&SETTING_LE == 0 {
+		return btmgmt.CMD_STATUS_NOT_SUPPORTED, nil
+	}
+	p := req.Params
+	if len(p) < 2 || len(p) != 2+23*int(binary.LittleEndian.Uint16(p[0:2])) {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	keys := make([]btmgmt.IdentityResolvingKey, 0, len(p)/23)
+	for off := 2; off < len(p); off += 23 {
+		var key btmgmt.IdentityResolvingKey
+		key.UpdateFromPayload(p[off : off+23])
+		if key.Address.AddressType == btmgmt.ADDRESS_TYPE_BR_EDR {
+			return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+		}
+		keys = append(keys, key)
+	}
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) { c.IRKs = keys })
+	return btmgmt.CMD_STATUS_SUCCESS, nil
+}
+
+func handleAddDevice(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	if len(req.Params) != 8 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, nil
+	}
+	entry := btmgmt.DeviceListEntry{Action: btmgmt.DeviceAction(req.Params[7])}
+	entry.Address.UpdateFromPayload(req.Params[0:7])
+	reply := req.Params[0:7]
+	if entry.Action > btmgmt.DEVICE_ACTION_AUTO_CONNECT || entry.Address.AddressType > btmgmt.ADDRESS_TYPE_LE_RANDOM {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, reply
+	}
+	// like the kernel, BR/EDR devices could only be allowed to connect
+	if entry.Address.AddressType == btmgmt.ADDRESS_TYPE_BR_EDR && entry.Action != btmgmt.DEVICE_ACTION_ALLOW_INCOMING {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, reply
+	}
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		devices := []btmgmt.DeviceListEntry{}
+		for _, d := range c.Devices {
+			if !d.Address.Equal(entry.Address) {
+				devices = append(devices, d)
+			}
+		}
+		c.Devices = append(devices, entry)
+	})
+	req.EmitEventToOthers(btmgmt.EVT_DEVICE_ADDED, req.ControllerIdx, req.Params)
+	return btmgmt.CMD_STATUS_SUCCESS, reply
+}
+
+func handleRemoveDevice(req *Request) (btmgmt.CmdStatus, []byte) {
+	_, status := req.controller()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	addr, status := req.addressParam()
+	if status != btmgmt.CMD_STATUS_SUCCESS {
+		return status, nil
+	}
+	all := bytes.Equal(req.Params[0:6], make([]byte, 6))
+	if all && addr.AddressType != btmgmt.ADDRESS_TYPE_BR_EDR {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, req.Params
+	}
+	var removed []btmgmt.DeviceListEntry
+	req.Kernel.UpdateController(req.ControllerIdx, func(c *Controller) {
+		var remaining []btmgmt.DeviceListEntry
+		for _, d := range c.Devices {
+			if all || d.Address.Equal(addr) {
+				removed = append(removed, d)
+				continue
+			}
+			remaining = append(remaining, d)
+		}
+		c.Devices = remaining
+	})
+	if !all && len(removed) == 0 {
+		return btmgmt.CMD_STATUS_INVALID_PARAMETERS, req.Params
+	}
+	for _, d := range removed {
+		req.EmitEventToOthers(btmgmt.EVT_DEVICE_REMOVED, req.ControllerIdx, addressInfoPayload(d.Address))
+	}
+	return btmgmt.CMD_STATUS_SUCCESS, req.Params
+}
+
+func zeroTerminated(src []byte) []byte {
+	for idx, v := range src {
+		if v == 0 {
+			return src[:idx]
+		}
+	}
+	return src
+}
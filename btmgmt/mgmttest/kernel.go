@@ -0,0 +1,368 @@
+// Package mgmttest provides an in-process fake of the kernel side of the Bluetooth management API, in order
+// to run btmgmt without Bluetooth hardware (f.e. in unit tests).
+//
+// The Kernel answers the most common commands (index list, controller information, settings, name, class,
+// UUIDs, discovery, connections, block list, device list, advertising, OOB data, static address, privacy)
+// like the Linux kernel does, including the events sent to other mgmt sockets. Every command handler could be
+// replaced with HandleCmd and arbitrary events could be injected with EmitEvent.
+//
+// Example:
+//
+//	k := mgmttest.NewKernel()
+//	k.AddController(mgmttest.DefaultController())
+//	conn, _ := k.NewMgmtConnection()
+//	bm := btmgmt.NewBtMgmtForConnection(conn)
+//	settings, err := bm.SetPowered(0, true)
+package mgmttest
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	ErrKernelClosed = errors.New("Fake kernel has been closed")
+)
+
+// Command received by the fake kernel
+type Command struct {
+	Code          btmgmt.CmdCode
+	ControllerIdx uint16
+	Params        []byte
+}
+
+// Handles a command. If status isn't CMD_STATUS_SUCCESS and returnParams is nil, a Command Status event is sent,
+// a Command Complete event otherwise.
+type CmdHandler func(req *Request) (status btmgmt.CmdStatus, returnParams []byte)
+
+// Command currently handled by a CmdHandler
+type Request struct {
+	Command
+	Kernel *Kernel
+	client *client
+}
+
+// Sends an event to all mgmt sockets, except the one which issued the command (like the kernel does for
+// New Settings, Local Name Changed ...)
+func (r *Request) EmitEventToOthers(code btmgmt.EvtCode, controllerIdx uint16, payload []byte) {
+	r.Kernel.emit(code, controllerIdx, payload, r.client)
+}
+
+// Fake of the Linux kernel's mgmt interface, serving any number of connections
+type Kernel struct {
+	*sync.Mutex
+	controllers   map[uint16]*Controller
+	handlers      map[btmgmt.CmdCode]CmdHandler
+	clients       map[*client]bool
+	received      []Command
+	responseDelay time.Duration
+	closed        bool
+}
+
+func NewKernel() (k *Kernel) {
+	k = &Kernel{
+		Mutex:       &sync.Mutex{},
+		controllers: make(map[uint16]*Controller),
+		clients:     make(map[*client]bool),
+	}
+	k.handlers = genDefaultHandlers()
+	return k
+}
+
+// Replaces the handler for the given command, a nil handler makes the command unknown
+func (k *Kernel) HandleCmd(code btmgmt.CmdCode, handler CmdHandler) {
+	k.Lock()
+	defer k.Unlock()
+	if handler == nil {
+		delete(k.handlers, code)
+		return
+	}
+	k.handlers[code] = handler
+}
+
+// Delays all command results (events caused by the command are sent immediately)
+func (k *Kernel) SetResponseDelay(delay time.Duration) {
+	k.Lock()
+	defer k.Unlock()
+	k.responseDelay = delay
+}
+
+// Returns all commands received so far, in order of arrival
+func (k *Kernel) ReceivedCommands() []Command {
+	k.Lock()
+	defer k.Unlock()
+	return append([]Command{}, k.received...)
+}
+
+// Opens a new connection to the fake kernel (like a newly bound HCI control socket)
+func (k *Kernel) Dial() (transport btmgmt.Transport, err error) {
+	k.Lock()
+	defer k.Unlock()
+	if k.closed {
+		return nil, ErrKernelClosed
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		wMutex: &sync.Mutex{},
+		fd:     fds[1],
+	}
+	k.clients[c] = true
+	go k.serve(c)
+	return btmgmt.NewFdTransport(fds[0]), nil
+}
+
+// Opens a MgmtConnection to the fake kernel, could be used as btmgmt.DialFunc
+func (k *Kernel) NewMgmtConnection() (conn *btmgmt.MgmtConnection, err error) {
+	transport, err := k.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return btmgmt.NewMgmtConnectionWithTransport(transport), nil
+}
+
+// Closes the kernel side of all connections, like a dying mgmt socket. New connections could still be dialed.
+func (k *Kernel) DropConnections() {
+	k.Lock()
+	defer k.Unlock()
+	for c := range k.clients {
+		c.close()
+		delete(k.clients, c)
+	}
+}
+
+// Drops all connections, dialing new connections fails afterwards
+func (k *Kernel) Close() {
+	k.DropConnections()
+	k.Lock()
+	k.closed = true
+	k.Unlock()
+}
+
+// Sends an event to all connections
+func (k *Kernel) EmitEvent(code btmgmt.EvtCode, controllerIdx uint16, payload []byte) {
+	k.emit(code, controllerIdx, payload, nil)
+}
+
+func (k *Kernel) emit(code btmgmt.EvtCode, controllerIdx uint16, payload []byte, skip *client) {
+	pkt := eventPacket(code, controllerIdx, payload)
+	k.Lock()
+	defer k.Unlock()
+	for c := range k.clients {
+		if c != skip {
+			c.write(pkt)
+		}
+	}
+}
+
+// Adds the controller with the lowest free index and sends an Index Added event
+func (k *Kernel) AddController(ctrl Controller) (controllerIdx uint16) {
+	k.Lock()
+	for k.controllers[controllerIdx] != nil {
+		controllerIdx++
+	}
+	k.controllers[controllerIdx] = &ctrl
+	k.Unlock()
+	k.EmitEvent(btmgmt.EVT_INDEX_ADDED, controllerIdx, nil)
+	return controllerIdx
+}
+
+// Removes the controller and sends an Index Removed event
+func (k *Kernel) RemoveController(controllerIdx uint16) {
+	k.Lock()
+	_, exists := k.controllers[controllerIdx]
+	delete(k.controllers, controllerIdx)
+	k.Unlock()
+	if exists {
+		k.EmitEvent(btmgmt.EVT_INDEX_REMOVED, controllerIdx, nil)
+	}
+}
+
+// Returns a copy of the controller's state, ok is false if there's no controller with the given index
+func (k *Kernel) Controller(controllerIdx uint16) (ctrl Controller, ok bool) {
+	k.Lock()
+	defer k.Unlock()
+	c, ok := k.controllers[controllerIdx]
+	if !ok {
+		return
+	}
+	return *c, true
+}
+
+// Modifies the state of the controller, returns false if there's no controller with the given index
+func (k *Kernel) UpdateController(controllerIdx uint16, modify func(ctrl *Controller)) (ok bool) {
+	k.Lock()
+	defer k.Unlock()
+	c, ok := k.controllers[controllerIdx]
+	if ok {
+		modify(c)
+	}
+	return
+}
+
+// Adds a connection to the controller and sends a Device Connected event (without EIR data), returns false if
+// there's no controller with the given index
+func (k *Kernel) ConnectDevice(controllerIdx uint16, conn Connection) (ok bool) {
+	ok = k.UpdateController(controllerIdx, func(c *Controller) {
+		c.Connections = append(append([]Connection{}, c.Connections...), conn)
+	})
+	if ok {
+		// address info, flags, EIR data length
+		k.EmitEvent(btmgmt.EVT_DEVICE_CONNECTED, controllerIdx, append(addressInfoPayload(conn.Address), 0, 0, 0, 0, 0, 0))
+	}
+	return
+}
+
+// Removes the connection and sends a Device Disconnected event with the given reason (like a remote
+// disconnect), returns false if there's no such connection
+func (k *Kernel) DisconnectDevice(controllerIdx uint16, addr btmgmt.AddressInfo, reason btmgmt.DisconnectReason) (ok bool) {
+	ok = k.removeConnection(controllerIdx, addr)
+	if ok {
+		k.EmitEvent(btmgmt.EVT_DEVICE_DISCONNECTED, controllerIdx, append(addressInfoPayload(addr), byte(reason)))
+	}
+	return
+}
+
+func (k *Kernel) removeConnection(controllerIdx uint16, addr btmgmt.AddressInfo) (removed bool) {
+	k.UpdateController(controllerIdx, func(c *Controller) {
+		var remaining []Connection
+		for _, conn := range c.Connections {
+			if conn.Address.Equal(addr) {
+				removed = true
+				continue
+			}
+			remaining = append(remaining, conn)
+		}
+		c.Connections = remaining
+	})
+	return
+}
+
+func (k *Kernel) controllerIndices() (indices []uint16) {
+	k.Lock()
+	defer k.Unlock()
+	for idx := range k.controllers {
+		indices = append(indices, idx)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return
+}
+
+func (k *Kernel) serve(c *client) {
+	buf := make([]byte, 6+0xffff)
+	for {
+		n, err := unix.Read(c.fd, buf)
+		if err != nil || n == 0 {
+			k.Lock()
+			delete(k.clients, c)
+			k.Unlock()
+			c.close()
+			unix.Close(c.fd) // closed by the reader only, the fd number could be reused while it is blocked in Read
+			return
+		}
+		if n < 6 || int(binary.LittleEndian.Uint16(buf[4:6])) != n-6 {
+			continue // the kernel drops malformed packets
+		}
+		cmd := Command{
+			Code:          btmgmt.CmdCode(binary.LittleEndian.Uint16(buf[0:2])),
+			ControllerIdx: binary.LittleEndian.Uint16(buf[2:4]),
+			Params:        append([]byte{}, buf[6:n]...),
+		}
+		k.handle(c, cmd)
+	}
+}
+
+// commands of a single connection are handled sequentially, like the kernel does
+func (k *Kernel) handle(c *client, cmd Command) {
+	k.Lock()
+	k.received = append(k.received, cmd)
+	handler, known := k.handlers[cmd.Code]
+	delay := k.responseDelay
+	k.Unlock()
+
+	var status btmgmt.CmdStatus
+	var returnParams []byte
+	if known {
+		status, returnParams = handler(&Request{Command: cmd, Kernel: k, client: c})
+	} else {
+		status = btmgmt.CMD_STATUS_UNKNOWN_COMMAND
+	}
+
+	var pkt []byte
+	if status != btmgmt.CMD_STATUS_SUCCESS && returnParams == nil {
+		pkt = eventPacket(btmgmt.EVT_COMMAND_STATUS, cmd.ControllerIdx, cmdResultPayload(cmd.Code, status, nil))
+	} else {
+		pkt = eventPacket(btmgmt.EVT_COMMAND_COMPLETE, cmd.ControllerIdx, cmdResultPayload(cmd.Code, status, returnParams))
+	}
+	if delay > 0 {
+		go func() {
+			time.Sleep(delay)
+			c.write(pkt)
+		}()
+		return
+	}
+	c.write(pkt)
+}
+
+type client struct {
+	wMutex *sync.Mutex
+	fd     int
+	closed bool
+}
+
+func (c *client) write(pkt []byte) {
+	c.wMutex.Lock()
+	defer c.wMutex.Unlock()
+	if c.closed {
+		return
+	}
+	unix.Write(c.fd, pkt)
+}
+
+func (c *client) close() {
+	c.wMutex.Lock()
+	defer c.wMutex.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	unix.Shutdown(c.fd, unix.SHUT_RDWR) // wakes up serve, which closes the fd
+}
+
+func eventPacket(code btmgmt.EvtCode, controllerIdx uint16, payload []byte) []byte {
+	pkt := make([]byte, 6, 6+len(payload))
+	binary.LittleEndian.PutUint16(pkt[0:2], uint16(code))
+	binary.LittleEndian.PutUint16(pkt[2:4], controllerIdx)
+	binary.LittleEndian.PutUint16(pkt[4:6], uint16(len(payload)))
+	return append(pkt, payload...)
+}
+
+func cmdResultPayload(code btmgmt.CmdCode, status btmgmt.CmdStatus, returnParams []byte) []byte {
+	pay := make([]byte, 3, 3+len(returnParams))
+	binary.LittleEndian.PutUint16(pay[0:2], uint16(code))
+	pay[2] = byte(status)
+	return append(pay, returnParams...)
+}
+
+// Address (wire order) followed by address type
+func addressInfoPayload(addr btmgmt.AddressInfo) []byte {
+	return append(addressToPayload(addr.Address.Addr), byte(addr.AddressType))
+}
+
+// Reverses a Bluetooth address to wire order
+func addressToPayload(addr net.HardwareAddr) []byte {
+	pay := make([]byte, 6)
+	for i := 0; i < 6 && i < len(addr); i++ {
+		pay[5-i] = addr[i]
+	}
+	return pay
+}
@@ -0,0 +1,397 @@
+package btmgmt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/mame82/mblue-toolz/bt_ad"
+	"github.com/mame82/mblue-toolz/bt_cod"
+)
+
+var (
+	ErrOOBRecordFormat = errors.New("Invalid OOB record")
+	ErrNDEFFormat      = errors.New("Invalid or unsupported NDEF message")
+)
+
+// MIME types of the NDEF records carrying OOB data (see "Bluetooth Secure Simple Pairing Using NFC")
+const (
+	OOB_MIME_TYPE_BR_EDR = "application/vnd.bluetooth.ep.oob"
+	OOB_MIME_TYPE_LE     = "application/vnd.bluetooth.le.oob"
+)
+
+// LE Role AD structure of LE OOB records
+type LERole byte
+
+const (
+	LE_ROLE_PERIPHERAL_ONLY      LERole = 0x00
+	LE_ROLE_CENTRAL_ONLY         LERole = 0x01
+	LE_ROLE_PERIPHERAL_PREFERRED LERole = 0x02
+	LE_ROLE_CENTRAL_PREFERRED    LERole = 0x03
+)
+
+// Hash and randomizer values for Secure Simple Pairing (P-192) and Secure Connections (P-256) OOB pairing,
+// in wire order (little endian). All zero values are treated as "not present".
+type OOBData struct {
+	Hash192       [16]byte
+	Randomizer192 [16]byte
+	Hash256       [16]byte // LE Secure Connections confirmation value for LE devices
+	Randomizer256 [16]byte // LE Secure Connections random value for LE devices
+}
+
+func (d *OOBData) UpdateFromPayload(p []byte) (err error) {
+	if len(p) != 32 && len(p) != 64 {
+		return ErrPayloadFormat
+	}
+	*d = OOBData{}
+	copy(d.Hash192[:], p[0:16])
+	copy(d.Randomizer192[:], p[16:32])
+	if len(p) == 64 {
+		copy(d.Hash256[:], p[32:48])
+		copy(d.Randomizer256[:], p[48:64])
+	}
+	return
+}
+
+func (d OOBData) HasP192() bool {
+	return d.Hash192 != [16]byte{} || d.Randomizer192 != [16]byte{}
+}
+
+func (d OOBData) HasP256() bool {
+	return d.Hash256 != [16]byte{} || d.Randomizer256 != [16]byte{}
+}
+
+// Hash and randomizer values, without P-256 values if there are none (the 32 octet form, which BR/EDR only
+// controllers without Secure Connections support)
+func (d OOBData) Payload() (pay []byte) {
+	pay = append(pay, d.Hash192[:]...)
+	pay = append(pay, d.Randomizer192[:]...)
+	if d.HasP256() {
+		pay = append(pay, d.Hash256[:]...)
+		pay = append(pay, d.Randomizer256[:]...)
+	}
+	return
+}
+
+// Result of Read Local Out Of Band Extended Data, see Record to decode the EIR data
+type LocalOOBExtendedData struct {
+	AddressTypes DiscoveryAddressTypes
+	EIR          bt_ad.AdvertisingData
+}
+
+func (d *LocalOOBExtendedData) UpdateFromPayload(p []byte) (err error) {
+	if len(p) < 3 {
+		return ErrPayloadFormat
+	}
+	d.AddressTypes = DiscoveryAddressTypes(p[0])
+	eirLen := int(binary.LittleEndian.Uint16(p[1:3]))
+	if len(p) != 3+eirLen {
+		return ErrPayloadFormat
+	}
+	return d.EIR.UpdateFromPayload(p[3:])
+}
+
+// Decodes the EIR data. The kernel includes the address for LE only, for BR/EDR the Address of the record
+// has to be set to the controller address (f.e. from ReadControllerInformation).
+func (d *LocalOOBExtendedData) Record() (rec *OOBRecord) {
+	rec = &OOBRecord{}
+	rec.updateFromAD(&d.EIR)
+	return
+}
+
+// Returns the P-192 hash and randomizer of the controller, plus the P-256 values if Secure Connections
+// are enabled. The values change with every call and are invalidated by a power cycle.
+func (bm BtMgmt) ReadLocalOOBData(controllerID uint16) (res *OOBData, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.ReadLocalOOBDataContext(ctx, controllerID)
+}
+
+func (bm BtMgmt) ReadLocalOOBDataContext(ctx context.Context, controllerID uint16) (res *OOBData, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_READ_LOCAL_OUT_OF_BOUND_DATA)
+	if err != nil {
+		return
+	}
+	res = &OOBData{}
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Returns the OOB data of the given transport (DISCOVERY_ADDRESS_TYPE_BR_EDR or DISCOVERY_ADDRESS_TYPES_LE) as
+// EIR data, ready to be put into an OOB record. Other mgmt sockets receive a Local Out Of Band Extended Data
+// Updated event.
+func (bm BtMgmt) ReadLocalOOBExtendedData(controllerID uint16, addressTypes DiscoveryAddressTypes) (res *LocalOOBExtendedData, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.ReadLocalOOBExtendedDataContext(ctx, controllerID, addressTypes)
+}
+
+func (bm BtMgmt) ReadLocalOOBExtendedDataContext(ctx context.Context, controllerID uint16, addressTypes DiscoveryAddressTypes) (res *LocalOOBExtendedData, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_READ_EXT_LOCAL_OUT_OF_BOUND_DATA, byte(addressTypes))
+	if err != nil {
+		return
+	}
+	res = &LocalOOBExtendedData{}
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Stores the OOB data of a remote device, used by the next pairing with this device. For LE devices only the
+// P-256 values are used, the P-192 values have to be zero.
+func (bm BtMgmt) AddRemoteOOBData(controllerID uint16, device AddressInfo, data OOBData) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.AddRemoteOOBDataContext(ctx, controllerID, device, data)
+}
+
+func (bm BtMgmt) AddRemoteOOBDataContext(ctx context.Context, controllerID uint16, device AddressInfo, data OOBData) (res *AddressInfo, err error) {
+	params := device.toPayload()
+	if device.AddressType == ADDRESS_TYPE_BR_EDR {
+		params = append(params, data.Payload()...)
+	} else {
+		// the 32 octet form would be interpreted as P-192 data, which LE doesn't support
+		params = append(params, make([]byte, 32)...)
+		params = append(params, data.Hash256[:]...)
+		params = append(params, data.Randomizer256[:]...)
+	}
+	payload, err := bm.runCmd(ctx, controllerID, CMD_ADD_REMOTE_OUT_OF_BOUND_DATA, params...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+// Stores address and OOB data of the record, f.e. read from an NFC tag or QR code with ParseOOBRecordNDEF
+func (bm BtMgmt) AddRemoteOOBRecord(controllerID uint16, rec *OOBRecord) (res *AddressInfo, err error) {
+	return bm.AddRemoteOOBData(controllerID, rec.Address, rec.Data)
+}
+
+// Removes the OOB data of the given device, the zero AddressInfo{} (BDADDR_ANY) removes the data of all devices
+func (bm BtMgmt) RemoveRemoteOOBData(controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.RemoveRemoteOOBDataContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) RemoveRemoteOOBDataContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_REMOVE_REMOTE_OUT_OF_BOUND_DATA, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+// Content of a Bluetooth OOB record, as exchanged via NFC (NDEF) or QR code. The BR/EDR record
+// (OOB_MIME_TYPE_BR_EDR) carries class of device and P-192/P-256 values, the LE record (OOB_MIME_TYPE_LE) the
+// role and the LE Secure Connections values (Hash256 and Randomizer256 of Data).
+type OOBRecord struct {
+	Address       AddressInfo
+	Data          OOBData
+	ClassOfDevice bt_cod.CoD // BR/EDR only, 0 if unknown
+	Role          LERole     // LE only
+	Name          string     // optional
+}
+
+func (rec OOBRecord) String() string {
+	return fmt.Sprintf("OOB record %s name %q P-192 %v P-256 %v", rec.Address.String(), rec.Name, rec.Data.HasP192(), rec.Data.HasP256())
+}
+
+func (rec *OOBRecord) updateFromAD(ad *bt_ad.AdvertisingData) {
+	if data, exists := ad.Field(bt_ad.AD_LE_BLUETOOTH_DEVICE_ADDRESS); exists && len(data) == 7 {
+		rec.Address.Address.UpdateFromPayload(data[0:6])
+		rec.Address.AddressType = ADDRESS_TYPE_LE_PUBLIC
+		if data[6]&0x01 != 0 {
+			rec.Address.AddressType = ADDRESS_TYPE_LE_RANDOM
+		}
+	}
+	if data, exists := ad.Field(bt_ad.AD_LE_ROLE); exists && len(data) == 1 {
+		rec.Role = LERole(data[0])
+	}
+	if class, exists := ad.ClassOfDevice(); exists {
+		rec.ClassOfDevice = class
+	}
+	if name, exists := ad.Name(); exists {
+		rec.Name = name
+	}
+	values := []struct {
+		t   bt_ad.ADType
+		dst *[16]byte
+	}{
+		{bt_ad.AD_SSP_HASH_P192, &rec.Data.Hash192},
+		{bt_ad.AD_SSP_RANDOMIZER_P192, &rec.Data.Randomizer192},
+		{bt_ad.AD_SSP_HASH_P256, &rec.Data.Hash256},
+		{bt_ad.AD_SSP_RANDOMIZER_P256, &rec.Data.Randomizer256},
+		{bt_ad.AD_LE_SC_CONFIRMATION_VALUE, &rec.Data.Hash256},
+		{bt_ad.AD_LE_SC_RANDOM_VALUE, &rec.Data.Randomizer256},
+	}
+	for _, v := range values {
+		if data, exists := ad.Field(v.t); exists && len(data) == 16 {
+			copy(v.dst[:], data)
+		}
+	}
+}
+
+// Encodes the payload of an OOB_MIME_TYPE_BR_EDR record: total length (2 octets), address and EIR data
+func (rec OOBRecord) MarshalBREDR() (pay []byte, err error) {
+	if rec.Address.AddressType != ADDRESS_TYPE_BR_EDR || len(rec.Address.Address.Addr) != 6 {
+		return nil, ErrInvalidAddress
+	}
+	ad := bt_ad.New()
+	if rec.ClassOfDevice != 0 {
+		ad.AddClassOfDevice(rec.ClassOfDevice)
+	}
+	if rec.Data.HasP192() {
+		ad.Add(bt_ad.AD_SSP_HASH_P192, rec.Data.Hash192[:]).Add(bt_ad.AD_SSP_RANDOMIZER_P192, rec.Data.Randomizer192[:])
+	}
+	if rec.Data.HasP256() {
+		ad.Add(bt_ad.AD_SSP_HASH_P256, rec.Data.Hash256[:]).Add(bt_ad.AD_SSP_RANDOMIZER_P256, rec.Data.Randomizer256[:])
+	}
+	if rec.Name != "" {
+		ad.AddName(rec.Name)
+	}
+	eir, err := ad.Payload(0)
+	if err != nil {
+		return
+	}
+	pay = make([]byte, 2, 8+len(eir))
+	binary.LittleEndian.PutUint16(pay, uint16(8+len(eir)))
+	pay = append(pay, rec.Address.toPayload()[0:6]...)
+	return append(pay, eir...), nil
+}
+
+// Encodes the payload of an OOB_MIME_TYPE_LE record (AD structures only)
+func (rec OOBRecord) MarshalLE() (pay []byte, err error) {
+	if rec.Address.AddressType == ADDRESS_TYPE_BR_EDR || len(rec.Address.Address.Addr) != 6 {
+		return nil, ErrInvalidAddress
+	}
+	addr := rec.Address.toPayload()
+	addr[6] = 0x00 // public
+	if rec.Address.AddressType == ADDRESS_TYPE_LE_RANDOM {
+		addr[6] = 0x01
+	}
+	ad := bt_ad.New().Add(bt_ad.AD_LE_BLUETOOTH_DEVICE_ADDRESS, addr).Add(bt_ad.AD_LE_ROLE, []byte{byte(rec.Role)})
+	if rec.Data.HasP256() {
+		ad.Add(bt_ad.AD_LE_SC_CONFIRMATION_VALUE, rec.Data.Hash256[:]).Add(bt_ad.AD_LE_SC_RANDOM_VALUE, rec.Data.Randomizer256[:])
+	}
+	if rec.Name != "" {
+		ad.AddName(rec.Name)
+	}
+	return ad.Payload(0)
+}
+
+func ParseOOBRecordBREDR(pay []byte) (rec *OOBRecord, err error) {
+	if len(pay) < 8 || int(binary.LittleEndian.Uint16(pay[0:2])) != len(pay) {
+		return nil, ErrOOBRecordFormat
+	}
+	ad := &bt_ad.AdvertisingData{}
+	if err = ad.UpdateFromPayload(pay[8:]); err != nil {
+		return nil, ErrOOBRecordFormat
+	}
+	rec = &OOBRecord{}
+	rec.updateFromAD(ad)
+	rec.Address.Address.UpdateFromPayload(pay[2:8])
+	rec.Address.AddressType = ADDRESS_TYPE_BR_EDR
+	return
+}
+
+func ParseOOBRecordLE(pay []byte) (rec *OOBRecord, err error) {
+	ad := &bt_ad.AdvertisingData{}
+	if err = ad.UpdateFromPayload(pay); err != nil {
+		return nil, ErrOOBRecordFormat
+	}
+	if _, exists := ad.Field(bt_ad.AD_LE_BLUETOOTH_DEVICE_ADDRESS); !exists {
+		return nil, ErrOOBRecordFormat
+	}
+	rec = &OOBRecord{}
+	rec.updateFromAD(ad)
+	if rec.Address.Address.Addr == nil {
+		return nil, ErrOOBRecordFormat
+	}
+	return
+}
+
+// NDEF record header flags
+const (
+	ndefMessageBegin = 0x80
+	ndefMessageEnd   = 0x40
+	ndefShortRecord  = 0x10
+	ndefIDLength     = 0x08
+	ndefTNFMask      = 0x07
+	ndefTNFMime      = 0x02
+)
+
+// Encodes the record as NDEF message with a single MIME record (BR/EDR or LE, depending on the address type),
+// as written to NFC tags. For QR codes the message could be encoded as text (f.e. base64).
+func (rec OOBRecord) MarshalNDEF() (msg []byte, err error) {
+	mimeType := OOB_MIME_TYPE_LE
+	marshal := rec.MarshalLE
+	if rec.Address.AddressType == ADDRESS_TYPE_BR_EDR {
+		mimeType = OOB_MIME_TYPE_BR_EDR
+		marshal = rec.MarshalBREDR
+	}
+	pay, err := marshal()
+	if err != nil {
+		return
+	}
+	if len(pay) > 255 {
+		msg = []byte{ndefMessageBegin | ndefMessageEnd | ndefTNFMime, byte(len(mimeType)), 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(msg[2:6], uint32(len(pay)))
+	} else {
+		msg = []byte{ndefMessageBegin | ndefMessageEnd | ndefShortRecord | ndefTNFMime, byte(len(mimeType)), byte(len(pay))}
+	}
+	msg = append(msg, mimeType...)
+	return append(msg, pay...), nil
+}
+
+// Decodes the first Bluetooth OOB record (BR/EDR or LE) of an NDEF message, other records are skipped
+func ParseOOBRecordNDEF(msg []byte) (rec *OOBRecord, err error) {
+	for off := 0; off < len(msg); {
+		flags := msg[off]
+		hdrLen := 2 + 4
+		if flags&ndefShortRecord != 0 {
+			hdrLen = 2 + 1
+		}
+		if flags&ndefIDLength != 0 {
+			hdrLen++
+		}
+		if off+hdrLen > len(msg) {
+			return nil, ErrNDEFFormat
+		}
+		typeLen := int(msg[off+1])
+		var payLen, idLen int
+		if flags&ndefShortRecord != 0 {
+			payLen = int(msg[off+2])
+		} else {
+			payLen = int(binary.BigEndian.Uint32(msg[off+2 : off+6]))
+		}
+		if flags&ndefIDLength != 0 {
+			idLen = int(msg[off+hdrLen-1])
+		}
+		typeOff := off + hdrLen
+		payOff := typeOff + typeLen + idLen
+		if payLen < 0 || payOff+payLen > len(msg) {
+			return nil, ErrNDEFFormat
+		}
+		if flags&ndefTNFMask == ndefTNFMime {
+			pay := msg[payOff : payOff+payLen]
+			switch string(msg[typeOff : typeOff+typeLen]) {
+			case OOB_MIME_TYPE_BR_EDR:
+				return ParseOOBRecordBREDR(pay)
+			case OOB_MIME_TYPE_LE:
+				return ParseOOBRecordLE(pay)
+			}
+		}
+		if flags&ndefMessageEnd != 0 {
+			break
+		}
+		off = payOff + payLen
+	}
+	return nil, ErrNDEFFormat
+}
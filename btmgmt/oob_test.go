@@ -0,0 +1,173 @@
+package btmgmt_test
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mame82/mblue-toolz/bt_cod"
+	"github.com/mame82/mblue-toolz/btmgmt"
+)
+
+func testOOBData(p192 bool) (data btmgmt.OOBData) {
+	for i := 0; i < 16; i++ {
+		if p192 {
+			data.Hash192[i] = byte(0x10 + i)
+			data.Randomizer192[i] = byte(0x20 + i)
+		}
+		data.Hash256[i] = byte(0x30 + i)
+		data.Randomizer256[i] = byte(0x40 + i)
+	}
+	return
+}
+
+func checkOOBRecord(t *testing.T, got *btmgmt.OOBRecord, exp btmgmt.OOBRecord) {
+	t.Helper()
+	if !got.Address.Equal(exp.Address) || got.Data != exp.Data || got.ClassOfDevice != exp.ClassOfDevice ||
+		got.Role != exp.Role || got.Name != exp.Name {
+		t.Errorf("got %+v, want %+v", *got, exp)
+	}
+}
+
+func TestOOBRecordNDEFRoundTrip(t *testing.T) {
+	brEdr, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x00, 0x1a, 0x7d, 0xda, 0x71, 0x13}, btmgmt.ADDRESS_TYPE_BR_EDR)
+	le, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0xc1, 0x22, 0x33, 0x44, 0x55, 0x66}, btmgmt.ADDRESS_TYPE_LE_RANDOM)
+	records := map[string]btmgmt.OOBRecord{
+		"BR/EDR": {
+			Address:       brEdr,
+			Data:          testOOBData(true),
+			ClassOfDevice: bt_cod.CoD(0x240404),
+			Name:          "headset",
+		},
+		"LE": {
+			Address: le,
+			Data:    testOOBData(false),
+			Role:    btmgmt.LE_ROLE_PERIPHERAL_PREFERRED,
+			Name:    "tag",
+		},
+		// payload exceeds 255 octets, thus a normal (not short) NDEF record is used
+		"long": {
+			Address: le,
+			Data:    testOOBData(false),
+			Role:    btmgmt.LE_ROLE_CENTRAL_ONLY,
+			Name:    strings.Repeat("n", 250),
+		},
+	}
+	for name, rec := range records {
+		msg, err := rec.MarshalNDEF()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if short := msg[0]&0x10 != 0; short != (name != "long") {
+			t.Errorf("%s: short record flag %v", name, short)
+		}
+		parsed, err := btmgmt.ParseOOBRecordNDEF(msg)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkOOBRecord(t, parsed, rec)
+	}
+
+	// LE records carry P-256 values only
+	rec := records["LE"]
+	rec.Data = testOOBData(true)
+	pay, err := rec.MarshalLE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := btmgmt.ParseOOBRecordLE(pay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Data != testOOBData(false) {
+		t.Errorf("P-192 values in LE record: %+v", parsed.Data)
+	}
+
+	if _, err = records["LE"].MarshalBREDR(); err != btmgmt.ErrInvalidAddress {
+		t.Errorf("BR/EDR record with LE address: got %v, want %v", err, btmgmt.ErrInvalidAddress)
+	}
+	if _, err = records["BR/EDR"].MarshalLE(); err != btmgmt.ErrInvalidAddress {
+		t.Errorf("LE record with BR/EDR address: got %v, want %v", err, btmgmt.ErrInvalidAddress)
+	}
+}
+
+func TestParseOOBRecordNDEFInvalid(t *testing.T) {
+	le, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0xc1, 0x22, 0x33, 0x44, 0x55, 0x66}, btmgmt.ADDRESS_TYPE_LE_RANDOM)
+	msg, err := btmgmt.OOBRecord{Address: le, Data: testOOBData(false)}.MarshalNDEF()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// records of other types are skipped
+	other := []byte{0x80 | 0x10 | 0x01, 1, 2, 'T', 'h', 'i'} // short well-known text record, message begin
+	multi := append(append([]byte{}, other...), msg...)
+	multi[len(other)] &^= 0x80
+	if parsed, pErr := btmgmt.ParseOOBRecordNDEF(multi); pErr != nil || !parsed.Address.Equal(le) {
+		t.Errorf("OOB record following another record not found: %v", pErr)
+	}
+
+	for l := 0; l < len(msg); l++ {
+		if _, pErr := btmgmt.ParseOOBRecordNDEF(msg[:l]); pErr == nil {
+			t.Errorf("message truncated to %d octets accepted", l)
+		}
+	}
+
+	invalid := map[string][]byte{
+		"other record only":      append([]byte{0xc0 | 0x10 | 0x01}, other[1:]...),
+		"huge payload length":    {0xc2, 1, 0xff, 0xff, 0xff, 0xff, 'x'},
+		"type exceeding message": {0xd2, 0xff, 0x00, 'x'},
+		"ID exceeding message":   {0xda, 0x01, 0x00, 0xff, 'x'},
+		"garbage":                {0x00, 0x00},
+		"LE without address": append([]byte{0xd2, byte(len(btmgmt.OOB_MIME_TYPE_LE)), 3},
+			append([]byte(btmgmt.OOB_MIME_TYPE_LE), 0x02, 0x1c, 0x00)...),
+		"malformed AD": append([]byte{0xd2, byte(len(btmgmt.OOB_MIME_TYPE_LE)), 2},
+			append([]byte(btmgmt.OOB_MIME_TYPE_LE), 0x08, 0x1b)...),
+		"BR/EDR length mismatch": append([]byte{0xd2, byte(len(btmgmt.OOB_MIME_TYPE_BR_EDR)), 8},
+			append([]byte(btmgmt.OOB_MIME_TYPE_BR_EDR), 0x09, 0x00, 1, 2, 3, 4, 5, 6)...),
+	}
+	for name, msg := range invalid {
+		if rec, pErr := btmgmt.ParseOOBRecordNDEF(msg); pErr == nil {
+			t.Errorf("%s: accepted as %+v", name, rec)
+		}
+	}
+}
+
+func TestAddRemoteOOBData(t *testing.T) {
+	k, bm := newTestKernel(t)
+	le, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0xc1, 0x22, 0x33, 0x44, 0x55, 0x66}, btmgmt.ADDRESS_TYPE_LE_RANDOM)
+	brEdr, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x00, 0x1a, 0x7d, 0xda, 0x71, 0x14}, btmgmt.ADDRESS_TYPE_BR_EDR)
+
+	// the kernel rejects P-192 values for LE devices
+	data := testOOBData(true)
+	if _, err := bm.AddRemoteOOBData(0, le, data); err != nil {
+		t.Fatal(err)
+	}
+	cmds := k.ReceivedCommands()
+	params := cmds[len(cmds)-1].Params
+	if len(params) != 7+64 || !bytes.Equal(params[7:39], make([]byte, 32)) ||
+		!bytes.Equal(params[39:55], data.Hash256[:]) || !bytes.Equal(params[55:71], data.Randomizer256[:]) {
+		t.Errorf("wrong LE parameters % x", params)
+	}
+
+	// BR/EDR without Secure Connections values uses the 32 octet form
+	data = btmgmt.OOBData{Hash192: data.Hash192, Randomizer192: data.Randomizer192}
+	if _, err := bm.AddRemoteOOBData(0, brEdr, data); err != nil {
+		t.Fatal(err)
+	}
+	cmds = k.ReceivedCommands()
+	if params = cmds[len(cmds)-1].Params; len(params) != 7+32 {
+		t.Errorf("wrong BR/EDR parameters % x", params)
+	}
+
+	ctrl, _ := k.Controller(0)
+	if len(ctrl.RemoteOOBData) != 2 {
+		t.Fatalf("kernel stores %d OOB data entries, want 2", len(ctrl.RemoteOOBData))
+	}
+	if _, err := bm.RemoveRemoteOOBData(0, btmgmt.AddressInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if ctrl, _ = k.Controller(0); len(ctrl.RemoteOOBData) != 0 {
+		t.Errorf("OOB data not removed: %+v", ctrl.RemoteOOBData)
+	}
+}
@@ -0,0 +1,240 @@
+package btmgmt
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// Most pairing related commands return the address of the remote device
+func parseAddressInfoResult(payload []byte) (res *AddressInfo, err error) {
+	res = &AddressInfo{}
+	err = res.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (bm BtMgmt) SetIoCapability(controllerID uint16, ioCapability IoCapability) (err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetIoCapabilityContext(ctx, controllerID, ioCapability)
+}
+
+func (bm BtMgmt) SetIoCapabilityContext(ctx context.Context, controllerID uint16, ioCapability IoCapability) (err error) {
+	_, err = bm.runCmd(ctx, controllerID, CMD_PIN_SET_IO_CAPABILITY, byte(ioCapability))
+	return
+}
+
+// Initiates pairing with the given remote device. The command completes after pairing finished
+// (successful or not), pairing requests arriving in between have to be answered (see RunPairingAgent).
+func (bm BtMgmt) PairDevice(controllerID uint16, device AddressInfo, ioCapability IoCapability) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.PairDeviceContext(ctx, controllerID, device, ioCapability)
+}
+
+func (bm BtMgmt) PairDeviceContext(ctx context.Context, controllerID uint16, device AddressInfo, ioCapability IoCapability) (res *AddressInfo, err error) {
+	params := append(device.toPayload(), byte(ioCapability))
+	payload, err := bm.runCmd(ctx, controllerID, CMD_PAIR_DEVICE, params...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+func (bm BtMgmt) CancelPairDevice(controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.CancelPairDeviceContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) CancelPairDeviceContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_CANCEL_PAIR_DEVICE, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+// Removes all keys of the given device, if disconnect is true an existing connection is terminated
+func (bm BtMgmt) UnpairDevice(controllerID uint16, device AddressInfo, disconnect bool) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.UnpairDeviceContext(ctx, controllerID, device, disconnect)
+}
+
+func (bm BtMgmt) UnpairDeviceContext(ctx context.Context, controllerID uint16, device AddressInfo, disconnect bool) (res *AddressInfo, err error) {
+	var bDisconnect byte
+	if disconnect {
+		bDisconnect = 1
+	}
+	params := append(device.toPayload(), bDisconnect)
+	payload, err := bm.runCmd(ctx, controllerID, CMD_UNPAIR_DEVICE, params...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+func (bm BtMgmt) PinCodeReply(controllerID uint16, device AddressInfo, pinCode string) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.PinCodeReplyContext(ctx, controllerID, device, pinCode)
+}
+
+func (bm BtMgmt) PinCodeReplyContext(ctx context.Context, controllerID uint16, device AddressInfo, pinCode string) (res *AddressInfo, err error) {
+	if len(pinCode) > 16 {
+		return nil, ErrPinCodeLength
+	}
+	params := append(device.toPayload(), byte(len(pinCode)))
+	pin := make([]byte, 16)
+	copy(pin, pinCode)
+	params = append(params, pin...)
+	payload, err := bm.runCmd(ctx, controllerID, CMD_PIN_CODE_REPLY, params...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+func (bm BtMgmt) PinCodeNegativeReply(controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.PinCodeNegativeReplyContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) PinCodeNegativeReplyContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_PIN_CODE_NEGATIVE_REPLY, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+func (bm BtMgmt) UserConfirmationReply(controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.UserConfirmationReplyContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) UserConfirmationReplyContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_CONFIRM_REPLY, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+func (bm BtMgmt) UserConfirmationNegativeReply(controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.UserConfirmationNegativeReplyContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) UserConfirmationNegativeReplyContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_CONFIRM_NEGATIVE_REPLY, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+func (bm BtMgmt) UserPasskeyReply(controllerID uint16, device AddressInfo, passkey uint32) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.UserPasskeyReplyContext(ctx, controllerID, device, passkey)
+}
+
+func (bm BtMgmt) UserPasskeyReplyContext(ctx context.Context, controllerID uint16, device AddressInfo, passkey uint32) (res *AddressInfo, err error) {
+	params := device.toPayload()
+	passkeyBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(passkeyBytes, passkey)
+	params = append(params, passkeyBytes...)
+	payload, err := bm.runCmd(ctx, controllerID, CMD_USER_PASSKEY_REPLY, params...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+func (bm BtMgmt) UserPasskeyNegativeReply(controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.UserPasskeyNegativeReplyContext(ctx, controllerID, device)
+}
+
+func (bm BtMgmt) UserPasskeyNegativeReplyContext(ctx context.Context, controllerID uint16, device AddressInfo) (res *AddressInfo, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_USER_PASSKEY_NEGATIVE_REPLY, device.toPayload()...)
+	if err != nil {
+		return
+	}
+	return parseAddressInfoResult(payload)
+}
+
+// Callbacks for pairing requests received via the management socket, the mgmt counterpart of
+// toolz.Agent1Interface for setups without bluetoothd. Returning an error rejects the request
+// (a negative reply is sent).
+type PairingAgent interface {
+	RequestPinCode(controllerID uint16, device AddressInfo, secure bool) (pinCode string, err error) // legacy pairing, secure indicates that a 16 digit PIN is required
+	RequestConfirmation(controllerID uint16, device AddressInfo, passkey uint32, confirmHint byte) (err error)
+	RequestPasskey(controllerID uint16, device AddressInfo) (passkey uint32, err error)
+	DisplayPasskey(controllerID uint16, device AddressInfo, passkey uint32, entered byte) // no reply needed
+}
+
+// Dispatches PIN Code Request, User Confirmation Request, User Passkey Request and Passkey Notify events
+// of all controllers to the given agent and sends the replies, till ctx is done.
+// The agent callbacks are called from their own go routine, so they are allowed to block (f.e. for user input).
+func (bm BtMgmt) RunPairingAgent(ctx context.Context, agent PairingAgent) (err error) {
+	evts, err := bm.Subscribe(ctx, SubscriptionFilter{
+		EventCodes: []EvtCode{
+			EVT_PIN_CODE_REQUEST,
+			EVT_USER_CONFIRMATION_REQUEST,
+			EVT_USER_PASSKEY_REQUEST,
+			EVT_PASSKEY_NOTIFY,
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	go func() {
+		for evt := range evts {
+			go bm.dispatchPairingRequest(agent, evt)
+		}
+	}()
+	return
+}
+
+func (bm BtMgmt) dispatchPairingRequest(agent PairingAgent, evt TypedEvent) {
+	var err error
+	ctrl := evt.ControllerIdx
+	switch req := evt.Payload.(type) {
+	case *PinCodeRequestEvent:
+		pin, aErr := agent.RequestPinCode(ctrl, req.Address, req.Secure)
+		if aErr != nil {
+			_, err = bm.PinCodeNegativeReply(ctrl, req.Address)
+		} else {
+			_, err = bm.PinCodeReply(ctrl, req.Address, pin)
+		}
+	case *UserConfirmationRequestEvent:
+		if aErr := agent.RequestConfirmation(ctrl, req.Address, req.Value, req.ConfirmHint); aErr != nil {
+			_, err = bm.UserConfirmationNegativeReply(ctrl, req.Address)
+		} else {
+			_, err = bm.UserConfirmationReply(ctrl, req.Address)
+		}
+	case *UserPasskeyRequestEvent:
+		passkey, aErr := agent.RequestPasskey(ctrl, req.Address)
+		if aErr != nil {
+			_, err = bm.UserPasskeyNegativeReply(ctrl, req.Address)
+		} else {
+			_, err = bm.UserPasskeyReply(ctrl, req.Address, passkey)
+		}
+	case *PasskeyNotifyEvent:
+		agent.DisplayPasskey(ctrl, req.Address, req.Passkey, req.Entered)
+	}
+	if err != nil {
+		fmt.Printf("Pairing agent failed to reply to event %#x for %+v: %v\n", evt.EventCode, evt.Payload, err)
+	}
+}
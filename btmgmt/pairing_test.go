@@ -0,0 +1,115 @@
+package btmgmt_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+	"github.com/mame82/mblue-toolz/btmgmt/mgmttest"
+)
+
+// Accepts passkey 123456 and non-secure PIN requests, displayed passkeys are sent to the channel
+type testAgent struct {
+	displayed chan uint32
+}
+
+func (a testAgent) RequestPinCode(controllerID uint16, device btmgmt.AddressInfo, secure bool) (pinCode string, err error) {
+	if secure {
+		return "", errors.New("no 16 digit PIN")
+	}
+	return "0000", nil
+}
+
+func (a testAgent) RequestConfirmation(controllerID uint16, device btmgmt.AddressInfo, passkey uint32, confirmHint byte) (err error) {
+	if passkey != 123456 {
+		return errors.New("passkey mismatch")
+	}
+	return nil
+}
+
+func (a testAgent) RequestPasskey(controllerID uint16, device btmgmt.AddressInfo) (passkey uint32, err error) {
+	return 654321, nil
+}
+
+func (a testAgent) DisplayPasskey(controllerID uint16, device btmgmt.AddressInfo, passkey uint32, entered byte) {
+	a.displayed <- passkey
+}
+
+func TestPairingAgent(t *testing.T) {
+	k, bm := newTestKernel(t)
+	// the fake kernel doesn't pair, the replies only return the address
+	replies := []btmgmt.CmdCode{
+		btmgmt.CMD_PIN_CODE_REPLY, btmgmt.CMD_PIN_CODE_NEGATIVE_REPLY,
+		btmgmt.CMD_CONFIRM_REPLY, btmgmt.CMD_CONFIRM_NEGATIVE_REPLY,
+		btmgmt.CMD_USER_PASSKEY_REPLY, btmgmt.CMD_USER_PASSKEY_NEGATIVE_REPLY,
+	}
+	for _, code := range replies {
+		k.HandleCmd(code, func(req *mgmttest.Request) (btmgmt.CmdStatus, []byte) {
+			return btmgmt.CMD_STATUS_SUCCESS, req.Params[0:7]
+		})
+	}
+
+	agent := testAgent{displayed: make(chan uint32, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := bm.RunPairingAgent(ctx, agent); err != nil {
+		t.Fatal(err)
+	}
+
+	addrPay := []byte{0x14, 0x71, 0xda, 0x7d, 0x1a, 0x00, byte(btmgmt.ADDRESS_TYPE_BR_EDR)}
+	withValue := func(prefix []byte, value uint32) []byte {
+		pay := make([]byte, 4)
+		binary.LittleEndian.PutUint32(pay, value)
+		return append(append(append([]byte{}, addrPay...), prefix...), pay...)
+	}
+	expectReply := func(code btmgmt.CmdCode, params []byte) {
+		t.Helper()
+		waitFor(t, "pairing reply", func() bool { return countCommands(k, code) == 1 })
+		if got := lastParams(k, code); !bytes.Equal(got, params) {
+			t.Errorf("reply %#x: got % x, want % x", code, got, params)
+		}
+	}
+
+	k.EmitEvent(btmgmt.EVT_USER_CONFIRMATION_REQUEST, 0, withValue([]byte{0}, 123456))
+	expectReply(btmgmt.CMD_CONFIRM_REPLY, addrPay)
+	k.EmitEvent(btmgmt.EVT_USER_CONFIRMATION_REQUEST, 0, withValue([]byte{0}, 111111))
+	expectReply(btmgmt.CMD_CONFIRM_NEGATIVE_REPLY, addrPay)
+
+	k.EmitEvent(btmgmt.EVT_USER_PASSKEY_REQUEST, 0, addrPay)
+	expectReply(btmgmt.CMD_USER_PASSKEY_REPLY, withValue(nil, 654321))
+
+	k.EmitEvent(btmgmt.EVT_PIN_CODE_REQUEST, 0, append(append([]byte{}, addrPay...), 0))
+	pin := append(append([]byte{}, addrPay...), 4, '0', '0', '0', '0')
+	expectReply(btmgmt.CMD_PIN_CODE_REPLY, append(pin, make([]byte, 12)...))
+	k.EmitEvent(btmgmt.EVT_PIN_CODE_REQUEST, 0, append(append([]byte{}, addrPay...), 1))
+	expectReply(btmgmt.CMD_PIN_CODE_NEGATIVE_REPLY, addrPay)
+
+	k.EmitEvent(btmgmt.EVT_PASSKEY_NOTIFY, 0, append(withValue(nil, 42), 0))
+	select {
+	case passkey := <-agent.displayed:
+		if passkey != 42 {
+			t.Errorf("displayed passkey %d, want 42", passkey)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("passkey not displayed")
+	}
+	if n := countCommands(k, btmgmt.CMD_USER_PASSKEY_NEGATIVE_REPLY); n != 0 {
+		t.Errorf("%d unexpected negative passkey replies", n)
+	}
+}
+
+func TestPinCodeReplyLength(t *testing.T) {
+	k, bm := newTestKernel(t)
+	dev, _ := btmgmt.NewAddressInfo(net.HardwareAddr{0x00, 0x1a, 0x7d, 0xda, 0x71, 0x14}, btmgmt.ADDRESS_TYPE_BR_EDR)
+	if _, err := bm.PinCodeReply(0, dev, "12345678901234567"); err != btmgmt.ErrPinCodeLength {
+		t.Errorf("17 digit PIN returned %v", err)
+	}
+	if n := countCommands(k, btmgmt.CMD_PIN_CODE_REPLY); n != 0 {
+		t.Errorf("too long PIN sent to the kernel")
+	}
+}
@@ -0,0 +1,104 @@
+package btmgmt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+)
+
+var (
+	ErrInvalidStaticAddress = errors.New("Not a valid static random address")
+)
+
+type PrivacyMode byte
+
+const (
+	PRIVACY_DISABLED PrivacyMode = 0x00
+	PRIVACY_ENABLED  PrivacyMode = 0x01
+	PRIVACY_LIMITED  PrivacyMode = 0x02 // the identity address is used while discoverable
+)
+
+// Returns true for a static random address (two most significant bits set, the remaining 46 bits neither
+// all zero nor all one)
+func IsStaticRandomAddress(addr net.HardwareAddr) bool {
+	if len(addr) != 6 || addr[0]&0xc0 != 0xc0 {
+		return false
+	}
+	allZero, allOne := addr[0]&0x3f == 0, addr[0]&0x3f == 0x3f
+	for _, b := range addr[1:] {
+		allZero = allZero && b == 0x00
+		allOne = allOne && b == 0xff
+	}
+	return !allZero && !allOne
+}
+
+// Returns a new random static address, which should be kept for the lifetime of the device (or at least till
+// the next power cycle)
+func GenerateStaticAddress() (addr net.HardwareAddr, err error) {
+	addr = make(net.HardwareAddr, 6)
+	for !IsStaticRandomAddress(addr) {
+		if _, err = rand.Read(addr); err != nil {
+			return nil, err
+		}
+		addr[0] |= 0xc0
+	}
+	return
+}
+
+// Returns a new random identity resolving key for SetPrivacy. The key has to be stored persistently, otherwise
+// bonded devices can't resolve the private addresses after the next restart.
+func GenerateIRK() (irk [16]byte, err error) {
+	_, err = rand.Read(irk[:])
+	return
+}
+
+// Sets the static random address used by LE only controllers (or if BR/EDR is disabled), the zero address
+// 00:00:00:00:00:00 removes it again. Only allowed while the controller is powered off.
+func (bm BtMgmt) SetStaticAddress(controllerID uint16, addr net.HardwareAddr) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetStaticAddressContext(ctx, controllerID, addr)
+}
+
+func (bm BtMgmt) SetStaticAddressContext(ctx context.Context, controllerID uint16, addr net.HardwareAddr) (currentSettings *ControllerSettings, err error) {
+	if len(addr) != 6 {
+		return nil, ErrInvalidAddress
+	}
+	if !IsStaticRandomAddress(addr) && !bytes.Equal(addr, make([]byte, 6)) {
+		return nil, ErrInvalidStaticAddress
+	}
+	payload, err := bm.runCmd(ctx, controllerID, CMD_SET_STATIC_ADDRESS, copyReverse(addr)...)
+	if err != nil {
+		return
+	}
+	currentSettings = &ControllerSettings{}
+	err = currentSettings.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Enables LE privacy: the kernel generates a new resolvable private address from the given local IRK
+// (see GenerateIRK) for every advertising and connection attempt, after the RPA timeout (15 minutes by
+// default). Only allowed while the controller is powered off. Disabling privacy clears the IRK.
+func (bm BtMgmt) SetPrivacy(controllerID uint16, mode PrivacyMode, irk [16]byte) (currentSettings *ControllerSettings, err error) {
+	ctx, cancel := defaultCmdContext()
+	defer cancel()
+	return bm.SetPrivacyContext(ctx, controllerID, mode, irk)
+}
+
+func (bm BtMgmt) SetPrivacyContext(ctx context.Context, controllerID uint16, mode PrivacyMode, irk [16]byte) (currentSettings *ControllerSettings, err error) {
+	payload, err := bm.runCmd(ctx, controllerID, CMD_SET_PRIVACY, append([]byte{byte(mode)}, irk[:]...)...)
+	if err != nil {
+		return
+	}
+	currentSettings = &ControllerSettings{}
+	err = currentSettings.UpdateFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
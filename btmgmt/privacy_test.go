@@ -0,0 +1,132 @@
+package btmgmt_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+)
+
+func TestIsStaticRandomAddress(t *testing.T) {
+	tests := []struct {
+		addr net.HardwareAddr
+		want bool
+	}{
+		{net.HardwareAddr{0xc0, 0x00, 0x00, 0x00, 0x00, 0x01}, true},
+		{net.HardwareAddr{0xc1, 0x00, 0x00, 0x00, 0x00, 0x00}, true},
+		{net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}, true},
+		{net.HardwareAddr{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff}, true},
+		{net.HardwareAddr{0xc0, 0x00, 0x00, 0x00, 0x00, 0x00}, false}, // random part all zero
+		{net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, false}, // random part all one
+		{net.HardwareAddr{0x40, 0x11, 0x22, 0x33, 0x44, 0x55}, false}, // resolvable private
+		{net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, false}, // non-resolvable private
+		{net.HardwareAddr{0x80, 0x11, 0x22, 0x33, 0x44, 0x55}, false}, // reserved
+		{net.HardwareAddr{0xc0, 0x11, 0x22, 0x33, 0x44}, false},
+	}
+	for _, test := range tests {
+		if got := btmgmt.IsStaticRandomAddress(test.addr); got != test.want {
+			t.Errorf("IsStaticRandomAddress(%v) = %v, want %v", test.addr, got, test.want)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		addr, err := btmgmt.GenerateStaticAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !btmgmt.IsStaticRandomAddress(addr) {
+			t.Fatalf("generated invalid static address %v", addr)
+		}
+	}
+}
+
+func TestSetStaticAddress(t *testing.T) {
+	k, bm := newTestKernel(t)
+	invalid := []net.HardwareAddr{
+		{0xc0, 0x00, 0x00, 0x00, 0x00, 0x00},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0x40, 0x11, 0x22, 0x33, 0x44, 0x55},
+	}
+	for _, addr := range invalid {
+		if _, err := bm.SetStaticAddress(0, addr); err != btmgmt.ErrInvalidStaticAddress {
+			t.Errorf("SetStaticAddress(%v) returned %v", addr, err)
+		}
+	}
+	if _, err := bm.SetStaticAddress(0, net.HardwareAddr{0xc0, 0x11}); err != btmgmt.ErrInvalidAddress {
+		t.Errorf("SetStaticAddress with short address returned %v", err)
+	}
+	if n := countCommands(k, btmgmt.CMD_SET_STATIC_ADDRESS); n != 0 {
+		t.Fatalf("invalid addresses sent to the kernel %d times", n)
+	}
+
+	addr := net.HardwareAddr{0xc1, 0x22, 0x33, 0x44, 0x55, 0x66}
+	settings, err := bm.SetStaticAddress(0, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.StaticAddress {
+		t.Error("static address setting not enabled")
+	}
+	// sent in little endian order
+	if params := lastParams(k, btmgmt.CMD_SET_STATIC_ADDRESS); !bytes.Equal(params, []byte{0x66, 0x55, 0x44, 0x33, 0x22, 0xc1}) {
+		t.Errorf("wrong parameters % x", params)
+	}
+	if ctrl, _ := k.Controller(0); !bytes.Equal(ctrl.StaticAddress, addr) {
+		t.Errorf("kernel has static address %v, want %v", ctrl.StaticAddress, addr)
+	}
+
+	// the zero address removes the static address
+	if settings, err = bm.SetStaticAddress(0, make(net.HardwareAddr, 6)); err != nil {
+		t.Fatal(err)
+	}
+	if settings.StaticAddress {
+		t.Error("static address setting not disabled")
+	}
+	if ctrl, _ := k.Controller(0); ctrl.StaticAddress != nil {
+		t.Errorf("static address %v not removed", ctrl.StaticAddress)
+	}
+}
+
+func TestSetPrivacy(t *testing.T) {
+	k, bm := newTestKernel(t)
+	irk, err := btmgmt.GenerateIRK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, err := bm.SetPrivacy(0, btmgmt.PRIVACY_LIMITED, irk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.Privacy {
+		t.Error("privacy setting not enabled")
+	}
+	if params := lastParams(k, btmgmt.CMD_SET_PRIVACY); len(params) != 17 || params[0] != 0x02 || !bytes.Equal(params[1:], irk[:]) {
+		t.Errorf("wrong parameters % x", params)
+	}
+	if ctrl, _ := k.Controller(0); ctrl.LocalIRK != irk {
+		t.Error("kernel didn't store the IRK")
+	}
+
+	// only allowed while powered off
+	if _, err = bm.SetPowered(0, true); err != nil {
+		t.Fatal(err)
+	}
+	_, err = bm.SetPrivacy(0, btmgmt.PRIVACY_DISABLED, [16]byte{})
+	var mErr *btmgmt.MgmtError
+	if !errors.As(err, &mErr) || mErr.Status != btmgmt.CMD_STATUS_REJECTED {
+		t.Errorf("SetPrivacy while powered returned %v", err)
+	}
+	if _, err = bm.SetPowered(0, false); err != nil {
+		t.Fatal(err)
+	}
+	if settings, err = bm.SetPrivacy(0, btmgmt.PRIVACY_DISABLED, [16]byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Privacy {
+		t.Error("privacy setting not disabled")
+	}
+	if ctrl, _ := k.Controller(0); ctrl.LocalIRK != [16]byte{} {
+		t.Error("kernel didn't clear the IRK")
+	}
+}
@@ -0,0 +1,114 @@
+package btmgmt
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mame82/mblue-toolz/bt_rpa"
+)
+
+// Maps resolvable private addresses to identity addresses, based on the IRKs of bonded devices.
+//
+// The kernel itself resolves the addresses of devices, whose IRKs have been loaded with
+// LoadIdentityResolvingKeys, thus the resolver is mainly useful for IRKs, which haven't been handed to the
+// kernel (f.e. those of devices bonded with another controller or another host).
+type IdentityResolver struct {
+	*sync.Mutex
+	keys []IdentityResolvingKey
+}
+
+func NewIdentityResolver(keys ...IdentityResolvingKey) *IdentityResolver {
+	r := &IdentityResolver{Mutex: &sync.Mutex{}}
+	for _, key := range keys {
+		r.Add(key)
+	}
+	return r
+}
+
+// Adds the IRK, replaces an existing IRK of the same identity address
+func (r *IdentityResolver) Add(key IdentityResolvingKey) {
+	r.Lock()
+	defer r.Unlock()
+	for i, k := range r.keys {
+		if k.Address.Equal(key.Address) {
+			r.keys[i] = key
+			return
+		}
+	}
+	r.keys = append(r.keys, key)
+}
+
+// Removes the IRK of the given identity address
+func (r *IdentityResolver) Remove(identity AddressInfo) {
+	r.Lock()
+	defer r.Unlock()
+	keys := r.keys[:0]
+	for _, k := range r.keys {
+		if !k.Address.Equal(identity) {
+			keys = append(keys, k)
+		}
+	}
+	r.keys = keys
+}
+
+// Returns a copy of all known IRKs
+func (r *IdentityResolver) Keys() []IdentityResolvingKey {
+	r.Lock()
+	defer r.Unlock()
+	return append([]IdentityResolvingKey{}, r.keys...)
+}
+
+// Returns the identity address of a resolvable private address, ok is false if the address isn't a
+// resolvable private address or no IRK resolves it
+func (r *IdentityResolver) Resolve(addr AddressInfo) (identity AddressInfo, ok bool) {
+	if addr.AddressType != ADDRESS_TYPE_LE_RANDOM || !bt_rpa.IsResolvable(addr.Address.Addr) {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	for _, k := range r.keys {
+		if bt_rpa.Resolve(k.Value, addr.Address.Addr) {
+			return k.Address, true
+		}
+	}
+	return
+}
+
+// Returns the identity address of the found device, or its address as reported if it can't be resolved
+func (r *IdentityResolver) ResolveDeviceFound(e *DeviceFoundEvent) AddressInfo {
+	if identity, ok := r.Resolve(e.Address); ok {
+		return identity
+	}
+	return e.Address
+}
+
+func (r *IdentityResolver) updateFromEvent(evt TypedEvent) {
+	switch e := evt.Payload.(type) {
+	case *NewIdentityResolvingKeyEvent:
+		r.Add(e.Key)
+	case *DeviceUnpairedEvent:
+		r.Remove(e.Address)
+	}
+}
+
+// Returns an IdentityResolver seeded with the given keys (f.e. the IdentityResolvingKeys of a KeyStore), which
+// adds the IRKs distributed by newly bonded devices and removes those of unpaired devices, till ctx is done.
+func (bm BtMgmt) TrackIdentityResolvingKeys(ctx context.Context, controllerID uint16, keys []IdentityResolvingKey) (resolver *IdentityResolver, err error) {
+	evts, err := bm.Subscribe(ctx, SubscriptionFilter{
+		ControllerIndices: []uint16{controllerID},
+		EventCodes: []EvtCode{
+			EVT_NEW_IDENTITY_RESOLVING_KEY,
+			EVT_DEVICE_UNPAIRED,
+		},
+	})
+	if err != nil {
+		return
+	}
+	resolver = NewIdentityResolver(keys...)
+	go func() {
+		for evt := range evts {
+			resolver.updateFromEvent(evt)
+		}
+	}()
+	return
+}
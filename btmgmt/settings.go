@@ -0,0 +1,218 @@
+package btmgmt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Bits of the 32 bit settings bitmask, as used by Read Controller Information, New Settings and the results of
+// all Set ... commands changing settings (see "Read Controller Information Command" of mgmt-api.txt)
+type Setting uint32
+
+const (
+	SETTING_POWERED            Setting = 1 << 0
+	SETTING_CONNECTABLE        Setting = 1 << 1
+	SETTING_FAST_CONNECTABLE   Setting = 1 << 2
+	SETTING_DISCOVERABLE       Setting = 1 << 3
+	SETTING_BONDABLE           Setting = 1 << 4
+	SETTING_LINK_SECURITY      Setting = 1 << 5
+	SETTING_SSP                Setting = 1 << 6
+	SETTING_BR_EDR             Setting = 1 << 7
+	SETTING_HIGH_SPEED         Setting = 1 << 8
+	SETTING_LE                 Setting = 1 << 9
+	SETTING_ADVERTISING        Setting = 1 << 10
+	SETTING_SECURE_CONNECTIONS Setting = 1 << 11
+	SETTING_DEBUG_KEYS         Setting = 1 << 12
+	SETTING_PRIVACY            Setting = 1 << 13
+	SETTING_CONFIGURATION      Setting = 1 << 14
+	SETTING_STATIC_ADDRESS     Setting = 1 << 15
+	SETTING_PHY_CONFIGURATION  Setting = 1 << 16
+	SETTING_WIDEBAND_SPEECH    Setting = 1 << 17
+	SETTING_CIS_CENTRAL        Setting = 1 << 18
+	SETTING_CIS_PERIPHERAL     Setting = 1 << 19
+	SETTING_ISO_BROADCASTER    Setting = 1 << 20
+	SETTING_ISO_SYNC_RECEIVER  Setting = 1 << 21
+	SETTING_LL_PRIVACY         Setting = 1 << 22
+	settingsKnownMask          Setting = 1<<23 - 1
+)
+
+var settingNameMap = genSettingNameMap()
+
+func genSettingNameMap() (nMap map[Setting]string) {
+	nMap = make(map[Setting]string)
+	nMap[SETTING_POWERED] = "Powered"
+	nMap[SETTING_CONNECTABLE] = "Connectable"
+	nMap[SETTING_FAST_CONNECTABLE] = "Fast Connectable"
+	nMap[SETTING_DISCOVERABLE] = "Discoverable"
+	nMap[SETTING_BONDABLE] = "Bondable"
+	nMap[SETTING_LINK_SECURITY] = "Link Level Security"
+	nMap[SETTING_SSP] = "Secure Simple Pairing"
+	nMap[SETTING_BR_EDR] = "BR/EDR"
+	nMap[SETTING_HIGH_SPEED] = "High Speed"
+	nMap[SETTING_LE] = "Low Energy"
+	nMap[SETTING_ADVERTISING] = "Advertising"
+	nMap[SETTING_SECURE_CONNECTIONS] = "Secure Connections"
+	nMap[SETTING_DEBUG_KEYS] = "Debug Keys"
+	nMap[SETTING_PRIVACY] = "Privacy"
+	nMap[SETTING_CONFIGURATION] = "Controller Configuration"
+	nMap[SETTING_STATIC_ADDRESS] = "Static Address"
+	nMap[SETTING_PHY_CONFIGURATION] = "PHY Configuration"
+	nMap[SETTING_WIDEBAND_SPEECH] = "Wideband Speech"
+	nMap[SETTING_CIS_CENTRAL] = "CIS Central"
+	nMap[SETTING_CIS_PERIPHERAL] = "CIS Peripheral"
+	nMap[SETTING_ISO_BROADCASTER] = "Isochronous Broadcaster"
+	nMap[SETTING_ISO_SYNC_RECEIVER] = "Synchronized Receiver"
+	nMap[SETTING_LL_PRIVACY] = "LL Privacy"
+	return nMap
+}
+
+// Names of all set bits, separated by "|" (unknown bits as "Bit n")
+func (s Setting) String() string {
+	var names []string
+	for bit := uint(0); bit < 32; bit++ {
+		if s&(1<<bit) == 0 {
+			continue
+		}
+		if name, exists := settingNameMap[Setting(1<<bit)]; exists {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("Bit %d", bit))
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+type ControllerSettings struct {
+	Powered                 bool
+	Connectable             bool
+	FastConnectable         bool
+	Discoverable            bool
+	Bondable                bool
+	LinkLevelSecurity       bool
+	SecureSimplePairing     bool
+	BrEdr                   bool
+	HighSpeed               bool
+	LowEnergy               bool
+	Advertising             bool
+	SecureConnections       bool
+	DebugKeys               bool
+	Privacy                 bool
+	ControllerConfiguration bool
+	StaticAddress           bool
+	PhyConfiguration        bool
+	WidebandSpeech          bool
+	CisCentral              bool
+	CisPeripheral           bool
+	IsoBroadcaster          bool
+	IsoSyncReceiver         bool
+	LLPrivacy               bool
+
+	UnknownBits Setting // bits not covered by the fields above, kept to encode the settings unchanged
+}
+
+// Fields of the settings in bit order (index is the bit number)
+func (cs *ControllerSettings) fields() []*bool {
+	return []*bool{
+		&cs.Powered,
+		&cs.Connectable,
+		&cs.FastConnectable,
+		&cs.Discoverable,
+		&cs.Bondable,
+		&cs.LinkLevelSecurity,
+		&cs.SecureSimplePairing,
+		&cs.BrEdr,
+		&cs.HighSpeed,
+		&cs.LowEnergy,
+		&cs.Advertising,
+		&cs.SecureConnections,
+		&cs.DebugKeys,
+		&cs.Privacy,
+		&cs.ControllerConfiguration,
+		&cs.StaticAddress,
+		&cs.PhyConfiguration,
+		&cs.WidebandSpeech,
+		&cs.CisCentral,
+		&cs.CisPeripheral,
+		&cs.IsoBroadcaster,
+		&cs.IsoSyncReceiver,
+		&cs.LLPrivacy,
+	}
+}
+
+// Builds the settings from a bitmask (f.e. SETTING_POWERED|SETTING_LE)
+func NewControllerSettings(mask Setting) (cs ControllerSettings) {
+	for bit, field := range cs.fields() {
+		*field = testBit(uint32(mask), uint8(bit))
+	}
+	cs.UnknownBits = mask &^ settingsKnownMask
+	return
+}
+
+// Returns the settings as bitmask
+func (cs ControllerSettings) Mask() (mask Setting) {
+	for bit, field := range cs.fields() {
+		if *field {
+			mask |= 1 << uint(bit)
+		}
+	}
+	return mask | cs.UnknownBits
+}
+
+// Returns true if all given setting bits are set
+func (cs ControllerSettings) Has(settings Setting) bool {
+	return cs.Mask()&settings == settings
+}
+
+func (cs ControllerSettings) String() string {
+	return cs.Mask().String()
+}
+
+func (cs *ControllerSettings) UpdateFromPayload(pay []byte) (err error) {
+	if len(pay) != 4 {
+		return ErrPayloadFormat
+	}
+	*cs = NewControllerSettings(Setting(binary.LittleEndian.Uint32(pay)))
+	return nil
+}
+
+// Encodes the settings to the 4 octets (little endian) used on the wire
+func (cs ControllerSettings) Payload() []byte {
+	pay := make([]byte, 4)
+	binary.LittleEndian.PutUint32(pay, uint32(cs.Mask()))
+	return pay
+}
+
+// Settings changed between two states, see DiffSettings
+type SettingsDiff struct {
+	Enabled  Setting
+	Disabled Setting
+}
+
+// Returns the settings which have been enabled and disabled going from oldSettings to newSettings. Could be fed
+// with the CurrentSettings of the ControllerInformation and those of consecutive New Settings events.
+func DiffSettings(oldSettings ControllerSettings, newSettings ControllerSettings) SettingsDiff {
+	oldMask, newMask := oldSettings.Mask(), newSettings.Mask()
+	return SettingsDiff{
+		Enabled:  newMask &^ oldMask,
+		Disabled: oldMask &^ newMask,
+	}
+}
+
+func (d SettingsDiff) Changed() bool {
+	return d.Enabled != 0 || d.Disabled != 0
+}
+
+// F.e. "+Powered +Connectable -Discoverable", empty if nothing changed
+func (d SettingsDiff) String() string {
+	var changes []string
+	for bit := uint(0); bit < 32; bit++ {
+		if d.Enabled&(1<<bit) != 0 {
+			changes = append(changes, "+"+Setting(1<<bit).String())
+		}
+		if d.Disabled&(1<<bit) != 0 {
+			changes = append(changes, "-"+Setting(1<<bit).String())
+		}
+	}
+	return strings.Join(changes, " ")
+}
@@ -0,0 +1,99 @@
+package btmgmt_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+)
+
+func TestSettingString(t *testing.T) {
+	tests := []struct {
+		setting btmgmt.Setting
+		want    string
+	}{
+		{0, ""},
+		{btmgmt.SETTING_POWERED, "Powered"},
+		{btmgmt.SETTING_SSP, "Secure Simple Pairing"},
+		{btmgmt.SETTING_LE, "Low Energy"},
+		{btmgmt.SETTING_LL_PRIVACY, "LL Privacy"},
+		{btmgmt.SETTING_POWERED | btmgmt.SETTING_BR_EDR | btmgmt.SETTING_LE, "Powered|BR/EDR|Low Energy"},
+		{1 << 23, "Bit 23"},
+		{btmgmt.SETTING_CONNECTABLE | 1<<31, "Connectable|Bit 31"},
+	}
+	for _, test := range tests {
+		if got := test.setting.String(); got != test.want {
+			t.Errorf("Setting(%#x).String() = %q, want %q", uint32(test.setting), got, test.want)
+		}
+	}
+}
+
+func TestControllerSettingsMask(t *testing.T) {
+	tests := []struct {
+		mask    btmgmt.Setting
+		check   func(cs btmgmt.ControllerSettings) bool
+		unknown btmgmt.Setting
+	}{
+		{0, func(cs btmgmt.ControllerSettings) bool { return cs == btmgmt.ControllerSettings{} }, 0},
+		{btmgmt.SETTING_POWERED, func(cs btmgmt.ControllerSettings) bool { return cs.Powered && !cs.Connectable }, 0},
+		{btmgmt.SETTING_STATIC_ADDRESS, func(cs btmgmt.ControllerSettings) bool { return cs.StaticAddress }, 0},
+		{btmgmt.SETTING_LL_PRIVACY, func(cs btmgmt.ControllerSettings) bool { return cs.LLPrivacy }, 0},
+		{btmgmt.SETTING_LE | 1<<23 | 1<<31, func(cs btmgmt.ControllerSettings) bool { return cs.LowEnergy }, 1<<23 | 1<<31},
+		{0xffffffff, func(cs btmgmt.ControllerSettings) bool { return cs.Powered && cs.LLPrivacy }, 0xff800000},
+	}
+	for _, test := range tests {
+		cs := btmgmt.NewControllerSettings(test.mask)
+		if !test.check(cs) {
+			t.Errorf("NewControllerSettings(%#x) = %+v", uint32(test.mask), cs)
+		}
+		if cs.UnknownBits != test.unknown {
+			t.Errorf("NewControllerSettings(%#x) has unknown bits %#x, want %#x", uint32(test.mask), uint32(cs.UnknownBits), uint32(test.unknown))
+		}
+		if mask := cs.Mask(); mask != test.mask {
+			t.Errorf("Mask() = %#x, want %#x", uint32(mask), uint32(test.mask))
+		}
+		if !cs.Has(test.mask) {
+			t.Errorf("settings %#x don't have their own bits", uint32(test.mask))
+		}
+	}
+
+	// wire format is little endian
+	pay := []byte{0xd1, 0x0a, 0x02, 0x80}
+	var cs btmgmt.ControllerSettings
+	if err := cs.UpdateFromPayload(pay); err != nil {
+		t.Fatal(err)
+	}
+	if !cs.Powered || !cs.WidebandSpeech || cs.UnknownBits != 1<<31 || !bytes.Equal(cs.Payload(), pay) {
+		t.Errorf("payload % x decoded as %+v, encoded as % x", pay, cs, cs.Payload())
+	}
+	if err := cs.UpdateFromPayload(pay[:3]); err != btmgmt.ErrPayloadFormat {
+		t.Errorf("3 octets: got %v, want %v", err, btmgmt.ErrPayloadFormat)
+	}
+}
+
+func TestDiffSettings(t *testing.T) {
+	oldSettings := btmgmt.NewControllerSettings(btmgmt.SETTING_POWERED | btmgmt.SETTING_LE | 1<<31)
+	tests := []struct {
+		newMask  btmgmt.Setting
+		enabled  btmgmt.Setting
+		disabled btmgmt.Setting
+		str      string
+	}{
+		{btmgmt.SETTING_POWERED | btmgmt.SETTING_LE | 1<<31, 0, 0, ""},
+		{btmgmt.SETTING_LE | btmgmt.SETTING_DISCOVERABLE | 1<<31, btmgmt.SETTING_DISCOVERABLE, btmgmt.SETTING_POWERED, "-Powered +Discoverable"},
+		{btmgmt.SETTING_POWERED | btmgmt.SETTING_LE, 0, 1 << 31, "-Bit 31"},
+		{0, 0, btmgmt.SETTING_POWERED | btmgmt.SETTING_LE | 1<<31, "-Powered -Low Energy -Bit 31"},
+	}
+	for _, test := range tests {
+		diff := btmgmt.DiffSettings(oldSettings, btmgmt.NewControllerSettings(test.newMask))
+		if diff.Enabled != test.enabled || diff.Disabled != test.disabled {
+			t.Errorf("diff to %#x: %+v", uint32(test.newMask), diff)
+		}
+		if diff.Changed() != (test.enabled|test.disabled != 0) {
+			t.Errorf("diff to %#x: Changed() = %v", uint32(test.newMask), diff.Changed())
+		}
+		if diff.String() != test.str {
+			t.Errorf("diff to %#x: %q, want %q", uint32(test.newMask), diff.String(), test.str)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package btmgmt
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Restricts the events delivered by a subscription. An empty slice matches
+// every controller / every event code.
+type SubscriptionFilter struct {
+	ControllerIndices []uint16
+	EventCodes        []EvtCode
+}
+
+func (f SubscriptionFilter) matches(event Event) bool {
+	if len(f.ControllerIndices) > 0 {
+		found := false
+		for _, idx := range f.ControllerIndices {
+			if idx == event.ControllerIdx {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.EventCodes) > 0 {
+		for _, code := range f.EventCodes {
+			if code == event.EventCode {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Decoded event as delivered to subscribers. Payload holds the typed struct
+// matching the EventCode (f.e. *DeviceConnectedEvent for EVT_DEVICE_CONNECTED)
+type TypedEvent struct {
+	EventCode     EvtCode
+	ControllerIdx uint16
+	Payload       ParsePayload
+}
+
+// EventListener which decodes matching events and queues them for delivery on a channel.
+// The queue assures that Handle() never blocks the event handler loop of the MgmtConnection,
+// even if the subscriber is slow reading from the channel.
+type subscriptionListener struct {
+	ctx    context.Context
+	filter SubscriptionFilter
+
+	mutexQueue *sync.Mutex
+	queue      []TypedEvent
+	notify     chan struct{}
+	out        chan TypedEvent
+}
+
+func (s *subscriptionListener) Filter(event Event) (consume bool) {
+	if s.ctx.Err() != nil {
+		return true // hand over to Handle(), in order to indicate that the listener has finished
+	}
+	return s.filter.matches(event)
+}
+
+func (s *subscriptionListener) Handle(event Event) (finished bool) {
+	if s.ctx.Err() != nil {
+		return true
+	}
+	payload, err := event.Decode()
+	if err != nil {
+		fmt.Printf("Subscription skipping unparsable event %#x: %v\n", event.EventCode, err)
+		return false
+	}
+
+	s.mutexQueue.Lock()
+	s.queue = append(s.queue, TypedEvent{
+		EventCode:     event.EventCode,
+		ControllerIdx: event.ControllerIdx,
+		Payload:       payload,
+	})
+	s.mutexQueue.Unlock()
+
+	// wake up deliver loop, if not already signaled
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return false
+}
+
+// Moves queued events to the out channel, till the context of the subscription ends or the
+// MgmtConnection is closed. The out channel is closed afterwards.
+func (s *subscriptionListener) deliverLoop(m *MgmtConnection) {
+	defer close(s.out)
+	defer m.RemoveListener(s)
+
+	for {
+		s.mutexQueue.Lock()
+		if len(s.queue) == 0 {
+			s.mutexQueue.Unlock()
+			select {
+			case <-s.notify:
+				continue
+			case <-s.ctx.Done():
+				return
+			case <-m.disposeMgmtConnection:
+				return
+			}
+		}
+		evt := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mutexQueue.Unlock()
+
+		select {
+		case s.out <- evt:
+		case <-s.ctx.Done():
+			return
+		case <-m.disposeMgmtConnection:
+			return
+		}
+	}
+}
+
+func newSubscriptionListener(ctx context.Context, filter SubscriptionFilter) *subscriptionListener {
+	return &subscriptionListener{
+		ctx:        ctx,
+		filter:     filter,
+		mutexQueue: &sync.Mutex{},
+		notify:     make(chan struct{}, 1),
+		out:        make(chan TypedEvent),
+	}
+}
+
+// Delivers all events matching the given filter, decoded to their typed payload, on the returned channel.
+// The subscription ends (and the channel is closed) when the given context is done or the connection
+// is closed.
+func (m *MgmtConnection) Subscribe(ctx context.Context, filter SubscriptionFilter) (events <-chan TypedEvent, err error) {
+	l := newSubscriptionListener(ctx, filter)
+	err = m.AddListener(l)
+	if err != nil {
+		return nil, err
+	}
+	go l.deliverLoop(m)
+	return l.out, nil
+}
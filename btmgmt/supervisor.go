@@ -0,0 +1,268 @@
+package btmgmt
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type ConnectionState int
+
+const (
+	CONNECTION_STATE_UP           ConnectionState = 0
+	CONNECTION_STATE_DOWN         ConnectionState = 1
+	CONNECTION_STATE_RECONNECTING ConnectionState = 2
+)
+
+func (cs ConnectionState) String() string {
+	switch cs {
+	case CONNECTION_STATE_UP:
+		return "up"
+	case CONNECTION_STATE_DOWN:
+		return "down"
+	case CONNECTION_STATE_RECONNECTING:
+		return "reconnecting"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	supervisorMinBackoff = 100 * time.Millisecond
+	supervisorMaxBackoff = 10 * time.Second
+)
+
+// Creates a new (connected) MgmtConnection, used by MgmtSupervisor for initial connect and reconnects
+type DialFunc func() (*MgmtConnection, error)
+
+// Source of the MgmtConnection used by BtMgmt, implemented by MgmtConnection itself (fixed connection)
+// and by MgmtSupervisor (connection which is re-established if it dies)
+type mgmtConnectionProvider interface {
+	connection() (*MgmtConnection, error)
+	Subscribe(ctx context.Context, filter SubscriptionFilter) (events <-chan TypedEvent, err error)
+}
+
+func (m *MgmtConnection) connection() (*MgmtConnection, error) {
+	if m.isClosed() {
+		return nil, ErrClosed
+	}
+	return m, nil
+}
+
+// Watches a MgmtConnection and re-establishes it with exponential backoff, if it dies.
+// Commands issued while the connection is down fail with ErrMgmtConnDown, commands pending when the
+// connection dies fail with ErrConnectionLost. Subscriptions created via the supervisor survive reconnects
+// (events emitted while the connection is down are lost, though).
+type MgmtSupervisor struct {
+	*sync.Mutex
+	dial       DialFunc
+	conn       *MgmtConnection // nil while down
+	state      ConnectionState
+	changed    chan struct{} // closed (and replaced) on every state change
+	stateSubs  map[chan ConnectionState]bool
+	closed     chan struct{}
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Dials the initial connection (an error is returned if this fails) and starts supervising it
+func NewMgmtSupervisor(dial DialFunc) (s *MgmtSupervisor, err error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	s = &MgmtSupervisor{
+		Mutex:      &sync.Mutex{},
+		dial:       dial,
+		conn:       conn,
+		state:      CONNECTION_STATE_UP,
+		changed:    make(chan struct{}),
+		stateSubs:  make(map[chan ConnectionState]bool),
+		closed:     make(chan struct{}),
+		minBackoff: supervisorMinBackoff,
+		maxBackoff: supervisorMaxBackoff,
+	}
+	go s.superviseLoop(conn)
+	return s, nil
+}
+
+// returns false if the supervisor has been closed meanwhile
+func (s *MgmtSupervisor) setState(state ConnectionState, conn *MgmtConnection) (ok bool) {
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.closed:
+		return false
+	default:
+	}
+	s.conn = conn
+	s.state = state
+	close(s.changed)
+	s.changed = make(chan struct{})
+	for sub := range s.stateSubs {
+		select {
+		case sub <- state:
+		default:
+			// subscriber is too slow, the state change is dropped for it
+		}
+	}
+	return true
+}
+
+func (s *MgmtSupervisor) superviseLoop(conn *MgmtConnection) {
+	for {
+		select {
+		case <-conn.disposeMgmtConnection:
+		case <-s.closed:
+			return
+		}
+		// Close() closes the connection, too, thus both channels could be ready at this point
+		if !s.setState(CONNECTION_STATE_DOWN, nil) {
+			return
+		}
+
+		backoff := s.minBackoff
+		for {
+			if !s.setState(CONNECTION_STATE_RECONNECTING, nil) {
+				return
+			}
+			var err error
+			conn, err = s.dial()
+			if err == nil {
+				break
+			}
+			select {
+			case <-time.After(backoff):
+			case <-s.closed:
+				return
+			}
+			backoff *= 2
+			if backoff > s.maxBackoff {
+				backoff = s.maxBackoff
+			}
+		}
+
+		if !s.setState(CONNECTION_STATE_UP, conn) {
+			// Close() has been called while dialing
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (s *MgmtSupervisor) connection() (*MgmtConnection, error) {
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.closed:
+		return nil, ErrClosed
+	default:
+	}
+	if s.conn == nil {
+		return nil, ErrMgmtConnDown
+	}
+	return s.conn, nil
+}
+
+func (s *MgmtSupervisor) State() ConnectionState {
+	s.Lock()
+	defer s.Unlock()
+	return s.state
+}
+
+// Delivers the connection state on every change, till ctx is done or the supervisor is closed.
+// Slow readers could miss intermediate states.
+func (s *MgmtSupervisor) SubscribeState(ctx context.Context) <-chan ConnectionState {
+	sub := make(chan ConnectionState, 16)
+	s.Lock()
+	s.stateSubs[sub] = true
+	s.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.closed:
+		}
+		s.Lock()
+		delete(s.stateSubs, sub)
+		close(sub)
+		s.Unlock()
+	}()
+	return sub
+}
+
+// blocks till a living connection is available
+func (s *MgmtSupervisor) waitConnection(ctx context.Context) (conn *MgmtConnection, err error) {
+	for {
+		s.Lock()
+		conn, changed := s.conn, s.changed
+		s.Unlock()
+		if conn != nil && !conn.isClosed() {
+			return conn, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.closed:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// Like MgmtConnection.Subscribe, but the subscription is renewed on every new connection. If the connection
+// is up, the subscription is registered before Subscribe returns (no event arriving afterwards is missed).
+func (s *MgmtSupervisor) Subscribe(ctx context.Context, filter SubscriptionFilter) (events <-chan TypedEvent, err error) {
+	conn, err := s.connection()
+	if err != nil && err != ErrMgmtConnDown {
+		return nil, err
+	}
+	var connEvts <-chan TypedEvent
+	if conn != nil {
+		// fails only if the connection died meanwhile, the subscription is renewed on the next one in this case
+		connEvts, _ = conn.Subscribe(ctx, filter)
+	}
+	out := make(chan TypedEvent)
+	go func() {
+		defer close(out)
+		for {
+			if connEvts != nil {
+				for evt := range connEvts {
+					select {
+					case out <- evt:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			// connection died, wait for the next one
+			conn, wErr := s.waitConnection(ctx)
+			if wErr != nil {
+				return
+			}
+			connEvts, _ = conn.Subscribe(ctx, filter)
+		}
+	}()
+	return out, nil
+}
+
+// Stops supervision and closes the current connection
+func (s *MgmtSupervisor) Close() (err error) {
+	s.Lock()
+	select {
+	case <-s.closed:
+		s.Unlock()
+		return nil
+	default:
+	}
+	close(s.closed)
+	conn := s.conn
+	s.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package btmgmt_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+	"github.com/mame82/mblue-toolz/btmgmt/mgmttest"
+)
+
+func newTestSupervisor(t *testing.T) (k *mgmttest.Kernel, s *btmgmt.MgmtSupervisor, dials func() int) {
+	k = mgmttest.NewKernel()
+	t.Cleanup(k.Close)
+	k.AddController(mgmttest.DefaultController())
+	mutex := &sync.Mutex{}
+	count := 0
+	s, err := btmgmt.NewMgmtSupervisor(func() (*btmgmt.MgmtConnection, error) {
+		mutex.Lock()
+		count++
+		mutex.Unlock()
+		return k.NewMgmtConnection()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return k, s, func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return count
+	}
+}
+
+func waitState(t *testing.T, states <-chan btmgmt.ConnectionState, want btmgmt.ConnectionState) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case state := <-states:
+			if state == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("connection didn't reach state %s", want)
+		}
+	}
+}
+
+// events emitted right after Subscribe returned mustn't be missed
+func TestSupervisorSubscribe(t *testing.T) {
+	k, s, _ := newTestSupervisor(t)
+	bm := btmgmt.NewBtMgmtForSupervisor(s)
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := bm.Subscribe(ctx, btmgmt.SubscriptionFilter{EventCodes: []btmgmt.EvtCode{btmgmt.EVT_CONTROLLER_ERROR}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		k.EmitEvent(btmgmt.EVT_CONTROLLER_ERROR, 0, []byte{byte(i)})
+		select {
+		case evt := <-events:
+			if code := evt.Payload.(*btmgmt.ControllerErrorEvent).ErrorCode; code != byte(i) {
+				t.Fatalf("subscription %d received error code %d", i, code)
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("subscription %d missed the event emitted right after subscribing", i)
+		}
+		cancel()
+	}
+}
+
+func TestSupervisorReconnect(t *testing.T) {
+	k, s, dials := newTestSupervisor(t)
+	bm := btmgmt.NewBtMgmtForSupervisor(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states := s.SubscribeState(ctx)
+	events, err := bm.Subscribe(ctx, btmgmt.SubscriptionFilter{EventCodes: []btmgmt.EvtCode{btmgmt.EVT_CONTROLLER_ERROR}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k.DropConnections()
+	waitState(t, states, btmgmt.CONNECTION_STATE_UP)
+	if n := dials(); n != 2 {
+		t.Errorf("dialed %d times, want 2", n)
+	}
+	if _, err = bm.SetPowered(0, true); err != nil {
+		t.Fatalf("command after reconnect failed: %v", err)
+	}
+
+	// the subscription is renewed asynchronously, after the state changed to up
+	for i := 0; i < 100; i++ {
+		k.EmitEvent(btmgmt.EVT_CONTROLLER_ERROR, 0, []byte{0x01})
+		select {
+		case evt, ok := <-events:
+			if !ok {
+				t.Fatal("subscription ended on reconnect")
+			}
+			if evt.EventCode != btmgmt.EVT_CONTROLLER_ERROR {
+				t.Fatalf("unexpected event %#x", evt.EventCode)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatal("subscription hasn't been renewed after reconnect")
+}
+
+func TestSupervisorPendingCommandFails(t *testing.T) {
+	k, s, _ := newTestSupervisor(t)
+	bm := btmgmt.NewBtMgmtForSupervisor(s)
+	k.SetResponseDelay(time.Second)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := bm.SetPowered(0, true)
+		result <- err
+	}()
+	waitFor(t, "Set Powered to be received", func() bool { return countCommands(k, btmgmt.CMD_SET_POWERED) == 1 })
+	k.DropConnections()
+	select {
+	case err := <-result:
+		if err != btmgmt.ErrConnectionLost {
+			t.Errorf("got %v, want %v", err, btmgmt.ErrConnectionLost)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("pending command didn't fail on connection loss")
+	}
+}
+
+// Close() closes the connection, which mustn't be mistaken for a dying connection
+func TestSupervisorCloseDoesNotRedial(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		_, s, dials := newTestSupervisor(t)
+		s.Close()
+		time.Sleep(2 * time.Millisecond)
+		if n := dials(); n != 1 {
+			t.Fatalf("dialed %d times after Close, want 1", n)
+		}
+		if s.State() != btmgmt.CONNECTION_STATE_UP {
+			t.Fatalf("state changed to %s after Close", s.State())
+		}
+	}
+}
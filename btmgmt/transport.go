@@ -0,0 +1,100 @@
+package btmgmt
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Packet oriented transport of a MgmtConnection. Every Write carries exactly one command packet and every
+// Read returns exactly one event packet. Close has to unblock pending Reads (if possible).
+// The default transport is the HCI control socket (DialHCIControlSocket), btmgmt/mgmttest provides a fake kernel
+// to run MgmtConnection without Bluetooth hardware.
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// Optional interface of a Transport. MgmtConnection calls EventDispatched once an event packet returned by Read
+// has been handed to all listeners (or has been skipped, because it couldn't be parsed). Transports replaying a
+// fixed set of events (like hcimon.ReplayTransport) use it to tell when all events have been processed.
+type DispatchObserver interface {
+	EventDispatched()
+}
+
+type fdTransport struct {
+	sync.Mutex
+	fd      int
+	reading bool
+	closed  bool
+}
+
+// Wraps a file descriptor of a packet oriented socket (f.e. an AF_UNIX SOCK_SEQPACKET socketpair end) into a Transport
+func NewFdTransport(fd int) Transport {
+	return &fdTransport{fd: fd}
+}
+
+func (t *fdTransport) Read(p []byte) (n int, err error) {
+	t.Lock()
+	if t.closed {
+		t.Unlock()
+		return 0, io.EOF
+	}
+	t.reading = true
+	t.Unlock()
+
+	n, err = unix.Read(t.fd, p)
+
+	t.Lock()
+	defer t.Unlock()
+	t.reading = false
+	if t.closed {
+		unix.Close(t.fd) // Close was called during the Read, the fd is released only now
+		return 0, io.EOF
+	}
+	return
+}
+
+func (t *fdTransport) Write(p []byte) (n int, err error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.closed {
+		return 0, ErrClosed
+	}
+	return unix.Write(t.fd, p)
+}
+
+// Closing the fd while a Read is blocked on it would let the Read continue on a reused fd number, thus a pending
+// Read closes the fd once it returns.
+func (t *fdTransport) Close() (err error) {
+	t.Lock()
+	defer t.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	unix.Shutdown(t.fd, unix.SHUT_RDWR) // wakes up blocking Reads, not supported by HCI sockets (error ignored)
+	if t.reading {
+		return nil
+	}
+	return unix.Close(t.fd)
+}
+
+// Opens a raw HCI socket bound to the control channel (mgmt API), not bound to a specific controller
+func DialHCIControlSocket() (t Transport, err error) {
+	//fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.BTPROTO_HCI)
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.BTPROTO_HCI)
+	if err != nil {
+		return nil, ErrSocketOpen
+	}
+	saHciCtrl := unix.SockaddrHCI{
+		Channel: unix.HCI_CHANNEL_CONTROL,
+		Dev:     uint16(INDEX_CONTROLLER_NONE),
+	}
+	err = unix.Bind(fd, &saHciCtrl)
+	if err != nil {
+		unix.Close(fd)
+		return nil, ErrSocketBind
+	}
+	return NewFdTransport(fd), nil
+}
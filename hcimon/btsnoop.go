@@ -0,0 +1,163 @@
+package hcimon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// Details see: RFC 1761 (snoop) and https://fte.com/webhelp/bpa600/Content/Technical_Information/BT_Snoop_File_Format.htm
+
+// Writes frames to a capture file
+type FrameWriter interface {
+	WriteFrame(f *Frame) error
+}
+
+const (
+	BTSNOOP_VERSION          = uint32(1)
+	BTSNOOP_DATALINK_H4      = uint32(1002) // UART (H4) packets of a single controller, f.e. Android HCI snoop logs
+	BTSNOOP_DATALINK_MONITOR = uint32(2001) // frames of the HCI monitor channel, flags carry index and opcode
+)
+
+// packet types of the H4 datalink
+const (
+	h4CommandPkt = 0x01
+	h4ACLPkt     = 0x02
+	h4SCOPkt     = 0x03
+	h4EventPkt   = 0x04
+	h4ISOPkt     = 0x05
+)
+
+var btsnoopMagic = []byte("btsnoop\x00")
+
+// microseconds between the btsnoop epoch (midnight, January 1st, 0 AD) and the unix epoch, value used by BlueZ
+const btsnoopEpochDelta = int64(0x00e03ab44a676000)
+
+func toBtsnoopTimestamp(t time.Time) uint64 {
+	return uint64(t.UnixNano()/1000 + btsnoopEpochDelta)
+}
+
+func fromBtsnoopTimestamp(ts uint64) time.Time {
+	usec := int64(ts) - btsnoopEpochDelta
+	return time.Unix(usec/1000000, (usec%1000000)*1000)
+}
+
+// Writes btsnoop files with monitor datalink (as written by "btmon -w")
+type BtsnoopWriter struct {
+	w io.Writer
+}
+
+// Writes the file header
+func NewBtsnoopWriter(w io.Writer) (bw *BtsnoopWriter, err error) {
+	hdr := make([]byte, 16)
+	copy(hdr[0:8], btsnoopMagic)
+	binary.BigEndian.PutUint32(hdr[8:12], BTSNOOP_VERSION)
+	binary.BigEndian.PutUint32(hdr[12:16], BTSNOOP_DATALINK_MONITOR)
+	_, err = w.Write(hdr)
+	if err != nil {
+		return nil, err
+	}
+	return &BtsnoopWriter{w: w}, nil
+}
+
+func (bw *BtsnoopWriter) WriteFrame(f *Frame) (err error) {
+	rec := make([]byte, 24, 24+len(f.Data))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(f.Data)))                   // original length
+	binary.BigEndian.PutUint32(rec[4:8], uint32(len(f.Data)))                   // included length
+	binary.BigEndian.PutUint32(rec[8:12], uint32(f.Index)<<16|uint32(f.Opcode)) // flags
+	binary.BigEndian.PutUint32(rec[12:16], 0)                                   // cumulative drops
+	binary.BigEndian.PutUint64(rec[16:24], toBtsnoopTimestamp(f.Timestamp))
+	_, err = bw.w.Write(append(rec, f.Data...))
+	return
+}
+
+// Reads btsnoop files with monitor datalink (f.e. written by "btmon -w" or BtsnoopWriter) or H4 datalink.
+// Frames of H4 captures are converted to monitor frames of controller 0.
+type BtsnoopReader struct {
+	r        io.Reader
+	Datalink uint32
+}
+
+// Reads and checks the file header
+func NewBtsnoopReader(r io.Reader) (br *BtsnoopReader, err error) {
+	hdr := make([]byte, 16)
+	_, err = io.ReadFull(r, hdr)
+	if err != nil {
+		return nil, ErrFileFormat
+	}
+	if !bytes.Equal(hdr[0:8], btsnoopMagic) || binary.BigEndian.Uint32(hdr[8:12]) != BTSNOOP_VERSION {
+		return nil, ErrFileFormat
+	}
+	br = &BtsnoopReader{
+		r:        r,
+		Datalink: binary.BigEndian.Uint32(hdr[12:16]),
+	}
+	if br.Datalink != BTSNOOP_DATALINK_MONITOR && br.Datalink != BTSNOOP_DATALINK_H4 {
+		return nil, ErrDatalinkType
+	}
+	return
+}
+
+// Returns io.EOF at the end of the file, records of H4 captures with unknown packet type are skipped
+func (br *BtsnoopReader) ReadFrame() (f *Frame, err error) {
+	for {
+		rec := make([]byte, 24)
+		_, err = io.ReadFull(br.r, rec)
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, ErrFileFormat
+		}
+		data := make([]byte, binary.BigEndian.Uint32(rec[4:8])) // included length
+		_, err = io.ReadFull(br.r, data)
+		if err != nil {
+			return nil, ErrFileFormat
+		}
+		flags := binary.BigEndian.Uint32(rec[8:12])
+		f = &Frame{
+			Timestamp: fromBtsnoopTimestamp(binary.BigEndian.Uint64(rec[16:24])),
+			Data:      data,
+		}
+		if br.Datalink == BTSNOOP_DATALINK_MONITOR {
+			f.Opcode = Opcode(flags & 0xffff)
+			f.Index = uint16(flags >> 16)
+			return f, nil
+		}
+		if h4ToMonitor(f, flags) {
+			return f, nil
+		}
+	}
+}
+
+// flags bit 0: 0 = sent, 1 = received
+func h4ToMonitor(f *Frame, flags uint32) (ok bool) {
+	if len(f.Data) < 1 {
+		return false
+	}
+	received := flags&0x01 != 0
+	pktType := f.Data[0]
+	f.Data = f.Data[1:]
+	switch {
+	case pktType == h4CommandPkt:
+		f.Opcode = OPCODE_COMMAND_PKT
+	case pktType == h4EventPkt:
+		f.Opcode = OPCODE_EVENT_PKT
+	case pktType == h4ACLPkt && received:
+		f.Opcode = OPCODE_ACL_RX_PKT
+	case pktType == h4ACLPkt:
+		f.Opcode = OPCODE_ACL_TX_PKT
+	case pktType == h4SCOPkt && received:
+		f.Opcode = OPCODE_SCO_RX_PKT
+	case pktType == h4SCOPkt:
+		f.Opcode = OPCODE_SCO_TX_PKT
+	case pktType == h4ISOPkt && received:
+		f.Opcode = OPCODE_ISO_RX_PKT
+	case pktType == h4ISOPkt:
+		f.Opcode = OPCODE_ISO_TX_PKT
+	default:
+		return false
+	}
+	return true
+}
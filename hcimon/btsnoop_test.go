@@ -0,0 +1,95 @@
+package hcimon
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func testFrame() *Frame {
+	return &Frame{
+		Opcode:    OPCODE_ACL_RX_PKT,
+		Index:     2,
+		Timestamp: time.Unix(1600000000, 123456000),
+		Data:      []byte{0x40, 0x20, 0x01, 0x00, 0xaa},
+	}
+}
+
+func TestBtsnoopRecord(t *testing.T) {
+	buf := &bytes.Buffer{}
+	bw, err := NewBtsnoopWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bw.WriteFrame(testFrame()); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		'b', 't', 's', 'n', 'o', 'o', 'p', 0, 0, 0, 0, 1, 0, 0, 0x07, 0xd1, // magic, version 1, datalink 2001
+		0, 0, 0, 5, 0, 0, 0, 5, // original and included length
+		0, 2, 0, 5, // flags: index << 16 | opcode
+		0, 0, 0, 0, // cumulative drops
+		0x00, 0xe5, 0xe9, 0xe5, 0x52, 0x0d, 0x42, 0x40, // microseconds since 0 AD
+		0x40, 0x20, 0x01, 0x00, 0xaa,
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("got\n% x\nwant\n% x", buf.Bytes(), want)
+	}
+
+	br, err := NewBtsnoopReader(bytes.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := br.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := testFrame()
+	if f.Opcode != exp.Opcode || f.Index != exp.Index || !f.Timestamp.Equal(exp.Timestamp) || !bytes.Equal(f.Data, exp.Data) {
+		t.Errorf("read %+v, want %+v", f, exp)
+	}
+	if _, err = br.ReadFrame(); err != io.EOF {
+		t.Errorf("got %v at the end of the file, want io.EOF", err)
+	}
+}
+
+func TestBtsnoopReaderH4(t *testing.T) {
+	file := []byte{
+		'b', 't', 's', 'n', 'o', 'o', 'p', 0, 0, 0, 0, 1, 0, 0, 0x03, 0xea, // datalink 1002 (H4)
+		0, 0, 0, 4, 0, 0, 0, 4, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // received
+		0x04, 0x0e, 0x01, 0x05, // event packet
+		0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0xff, 0x00, // unknown packet type, skipped
+		0, 0, 0, 3, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // sent
+		0x02, 0x40, 0x20, // ACL packet
+	}
+	br, err := NewBtsnoopReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		opcode Opcode
+		data   []byte
+	}{
+		{OPCODE_EVENT_PKT, []byte{0x0e, 0x01, 0x05}},
+		{OPCODE_ACL_TX_PKT, []byte{0x40, 0x20}},
+	}
+	for _, w := range want {
+		f, err := br.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Opcode != w.opcode || f.Index != 0 || !bytes.Equal(f.Data, w.data) {
+			t.Errorf("read %+v, want opcode %v data % x", f, w.opcode, w.data)
+		}
+	}
+	if _, err = br.ReadFrame(); err != io.EOF {
+		t.Errorf("got %v at the end of the file, want io.EOF", err)
+	}
+
+	file[15] = 0xeb
+	if _, err = NewBtsnoopReader(bytes.NewReader(file)); err != ErrDatalinkType {
+		t.Errorf("datalink 1003: got %v, want %v", err, ErrDatalinkType)
+	}
+}
@@ -0,0 +1,385 @@
+package hcimon
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+)
+
+// Decodes the frame data according to the opcode. Typed frames are named XxxFrame, frames with unknown opcode
+// fail with ErrUnknownOpcode.
+func (f *Frame) Decode() (typed btmgmt.ParsePayload, err error) {
+	constructor, exists := frameConstructors[f.Opcode]
+	if !exists {
+		return nil, ErrUnknownOpcode
+	}
+	typed = constructor()
+	err = typed.UpdateFromPayload(f.Data)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (f *Frame) String() string {
+	index := "-"
+	if f.Index != INDEX_NONE {
+		index = fmt.Sprintf("hci%d", f.Index)
+	}
+	res := fmt.Sprintf("%s [%s] %s", f.Timestamp.Format("15:04:05.000000"), index, f.Opcode.String())
+	if typed, err := f.Decode(); err == nil {
+		res += fmt.Sprintf(": %v", typed)
+	} else {
+		res += fmt.Sprintf(": % x", f.Data)
+	}
+	return res
+}
+
+var frameConstructors = genFrameConstructors()
+
+func genFrameConstructors() (cMap map[Opcode]func() btmgmt.ParsePayload) {
+	cMap = make(map[Opcode]func() btmgmt.ParsePayload)
+	cMap[OPCODE_NEW_INDEX] = func() btmgmt.ParsePayload { return &NewIndexFrame{} }
+	cMap[OPCODE_DEL_INDEX] = func() btmgmt.ParsePayload { return &NoDataFrame{} }
+	cMap[OPCODE_COMMAND_PKT] = func() btmgmt.ParsePayload { return &HCICommandFrame{} }
+	cMap[OPCODE_EVENT_PKT] = func() btmgmt.ParsePayload { return &HCIEventFrame{} }
+	cMap[OPCODE_ACL_TX_PKT] = func() btmgmt.ParsePayload { return &ACLFrame{} }
+	cMap[OPCODE_ACL_RX_PKT] = func() btmgmt.ParsePayload { return &ACLFrame{} }
+	cMap[OPCODE_SCO_TX_PKT] = func() btmgmt.ParsePayload { return &SCOFrame{} }
+	cMap[OPCODE_SCO_RX_PKT] = func() btmgmt.ParsePayload { return &SCOFrame{} }
+	cMap[OPCODE_OPEN_INDEX] = func() btmgmt.ParsePayload { return &NoDataFrame{} }
+	cMap[OPCODE_CLOSE_INDEX] = func() btmgmt.ParsePayload { return &NoDataFrame{} }
+	cMap[OPCODE_INDEX_INFO] = func() btmgmt.ParsePayload { return &IndexInfoFrame{} }
+	cMap[OPCODE_VENDOR_DIAG] = func() btmgmt.ParsePayload { return &RawFrame{} }
+	cMap[OPCODE_SYSTEM_NOTE] = func() btmgmt.ParsePayload { return &SystemNoteFrame{} }
+	cMap[OPCODE_USER_LOGGING] = func() btmgmt.ParsePayload { return &UserLoggingFrame{} }
+	cMap[OPCODE_CTRL_OPEN] = func() btmgmt.ParsePayload { return &CtrlOpenFrame{} }
+	cMap[OPCODE_CTRL_CLOSE] = func() btmgmt.ParsePayload { return &CtrlCloseFrame{} }
+	cMap[OPCODE_CTRL_COMMAND] = func() btmgmt.ParsePayload { return &CtrlCommandFrame{} }
+	cMap[OPCODE_CTRL_EVENT] = func() btmgmt.ParsePayload { return &CtrlEventFrame{} }
+	cMap[OPCODE_ISO_TX_PKT] = func() btmgmt.ParsePayload { return &ISOFrame{} }
+	cMap[OPCODE_ISO_RX_PKT] = func() btmgmt.ParsePayload { return &ISOFrame{} }
+	return cMap
+}
+
+// Delete Index, Open Index and Close Index frames carry no data
+type NoDataFrame struct{}
+
+func (nd *NoDataFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) != 0 {
+		return ErrFrameFormat
+	}
+	return
+}
+
+func (nd NoDataFrame) String() string {
+	return ""
+}
+
+// Frames which aren't decoded any further (Vendor Diagnostic)
+type RawFrame struct {
+	Data []byte
+}
+
+func (r *RawFrame) UpdateFromPayload(data []byte) (err error) {
+	r.Data = data
+	return
+}
+
+func (r RawFrame) String() string {
+	return fmt.Sprintf("% x", r.Data)
+}
+
+type NewIndexFrame struct {
+	Type    byte // 0x00 primary controller, 0x01 AMP
+	Bus     byte
+	Address btmgmt.Address
+	Name    string // driver name, f.e. "hci0"
+}
+
+func (ni *NewIndexFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) != 16 {
+		return ErrFrameFormat
+	}
+	ni.Type = data[0]
+	ni.Bus = data[1]
+	err = ni.Address.UpdateFromPayload(data[2:8])
+	if err != nil {
+		return
+	}
+	ni.Name = zeroTerminated(data[8:16])
+	return
+}
+
+func (ni NewIndexFrame) String() string {
+	return fmt.Sprintf("type %#02x bus %#02x addr %s name %s", ni.Type, ni.Bus, ni.Address.String(), ni.Name)
+}
+
+type IndexInfoFrame struct {
+	Address      btmgmt.Address
+	Manufacturer uint16
+}
+
+func (ii *IndexInfoFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) != 8 {
+		return ErrFrameFormat
+	}
+	err = ii.Address.UpdateFromPayload(data[0:6])
+	if err != nil {
+		return
+	}
+	ii.Manufacturer = binary.LittleEndian.Uint16(data[6:8])
+	return
+}
+
+func (ii IndexInfoFrame) String() string {
+	return fmt.Sprintf("addr %s manufacturer %d", ii.Address.String(), ii.Manufacturer)
+}
+
+// HCI command sent to a controller
+type HCICommandFrame struct {
+	Opcode uint16
+	Params []byte
+}
+
+func (c *HCICommandFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) < 3 || int(data[2]) != len(data)-3 {
+		return ErrFrameFormat
+	}
+	c.Opcode = binary.LittleEndian.Uint16(data[0:2])
+	c.Params = data[3:]
+	return
+}
+
+// OpCode Group Field
+func (c HCICommandFrame) OGF() uint16 {
+	return c.Opcode >> 10
+}
+
+// OpCode Command Field
+func (c HCICommandFrame) OCF() uint16 {
+	return c.Opcode & 0x03ff
+}
+
+func (c HCICommandFrame) String() string {
+	return fmt.Sprintf("opcode %#04x (ogf %#02x ocf %#04x) params % x", c.Opcode, c.OGF(), c.OCF(), c.Params)
+}
+
+// HCI event received from a controller
+type HCIEventFrame struct {
+	EventCode byte
+	Params    []byte
+}
+
+func (e *HCIEventFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) < 2 || int(data[1]) != len(data)-2 {
+		return ErrFrameFormat
+	}
+	e.EventCode = data[0]
+	e.Params = data[2:]
+	return
+}
+
+func (e HCIEventFrame) String() string {
+	return fmt.Sprintf("event %#02x params % x", e.EventCode, e.Params)
+}
+
+// ACL data, the direction is given by the opcode of the frame
+type ACLFrame struct {
+	Handle         uint16
+	PacketBoundary byte
+	Broadcast      byte
+	Data           []byte
+}
+
+func (a *ACLFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) < 4 || int(binary.LittleEndian.Uint16(data[2:4])) != len(data)-4 {
+		return ErrFrameFormat
+	}
+	handleFlags := binary.LittleEndian.Uint16(data[0:2])
+	a.Handle = handleFlags & 0x0fff
+	a.PacketBoundary = byte(handleFlags>>12) & 0x03
+	a.Broadcast = byte(handleFlags>>14) & 0x03
+	a.Data = data[4:]
+	return
+}
+
+func (a ACLFrame) String() string {
+	return fmt.Sprintf("handle %d flags %#02x dlen %d", a.Handle, a.PacketBoundary|a.Broadcast<<2, len(a.Data))
+}
+
+// SCO data, the direction is given by the opcode of the frame
+type SCOFrame struct {
+	Handle       uint16
+	PacketStatus byte
+	Data         []byte
+}
+
+func (s *SCOFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) < 3 || int(data[2]) != len(data)-3 {
+		return ErrFrameFormat
+	}
+	handleFlags := binary.LittleEndian.Uint16(data[0:2])
+	s.Handle = handleFlags & 0x0fff
+	s.PacketStatus = byte(handleFlags>>12) & 0x03
+	s.Data = data[3:]
+	return
+}
+
+func (s SCOFrame) String() string {
+	return fmt.Sprintf("handle %d status %#02x dlen %d", s.Handle, s.PacketStatus, len(s.Data))
+}
+
+// ISO data, the direction is given by the opcode of the frame
+type ISOFrame struct {
+	Handle         uint16
+	PacketBoundary byte
+	Timestamp      bool // Data starts with a timestamp
+	Data           []byte
+}
+
+func (i *ISOFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) < 4 || int(binary.LittleEndian.Uint16(data[2:4])&0x3fff) != len(data)-4 {
+		return ErrFrameFormat
+	}
+	handleFlags := binary.LittleEndian.Uint16(data[0:2])
+	i.Handle = handleFlags & 0x0fff
+	i.PacketBoundary = byte(handleFlags>>12) & 0x03
+	i.Timestamp = handleFlags&(1<<14) != 0
+	i.Data = data[4:]
+	return
+}
+
+func (i ISOFrame) String() string {
+	return fmt.Sprintf("handle %d flags %#02x dlen %d", i.Handle, i.PacketBoundary, len(i.Data))
+}
+
+type SystemNoteFrame struct {
+	Note string
+}
+
+func (sn *SystemNoteFrame) UpdateFromPayload(data []byte) (err error) {
+	sn.Note = zeroTerminated(data)
+	return
+}
+
+func (sn SystemNoteFrame) String() string {
+	return sn.Note
+}
+
+// Log message of a user space process (f.e. bluetoothd), written to the monitor channel
+type UserLoggingFrame struct {
+	Priority byte // syslog priority
+	Ident    string
+	Message  string
+}
+
+func (ul *UserLoggingFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) < 2 || int(data[1]) > len(data)-2 {
+		return ErrFrameFormat
+	}
+	ul.Priority = data[0]
+	identLen := int(data[1])
+	ul.Ident = zeroTerminated(data[2 : 2+identLen])
+	ul.Message = zeroTerminated(data[2+identLen:])
+	return
+}
+
+func (ul UserLoggingFrame) String() string {
+	return fmt.Sprintf("%s: %s", ul.Ident, ul.Message)
+}
+
+// A management socket (or other HCI control channel user) has been opened
+type CtrlOpenFrame struct {
+	Cookie   uint32 // identifies the socket in subsequent Ctrl frames
+	Format   uint16 // 0x0000 mgmt
+	Version  byte
+	Revision uint16
+	Flags    uint32
+	Ident    string // process name
+}
+
+func (co *CtrlOpenFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) < 14 || int(data[13]) > len(data)-14 {
+		return ErrFrameFormat
+	}
+	co.Cookie = binary.LittleEndian.Uint32(data[0:4])
+	co.Format = binary.LittleEndian.Uint16(data[4:6])
+	co.Version = data[6]
+	co.Revision = binary.LittleEndian.Uint16(data[7:9])
+	co.Flags = binary.LittleEndian.Uint32(data[9:13])
+	co.Ident = zeroTerminated(data[14 : 14+int(data[13])])
+	return
+}
+
+func (co CtrlOpenFrame) String() string {
+	return fmt.Sprintf("socket %#08x format %#04x version %d.%d %s", co.Cookie, co.Format, co.Version, co.Revision, co.Ident)
+}
+
+type CtrlCloseFrame struct {
+	Cookie uint32
+}
+
+func (cc *CtrlCloseFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) != 4 {
+		return ErrFrameFormat
+	}
+	cc.Cookie = binary.LittleEndian.Uint32(data[0:4])
+	return
+}
+
+func (cc CtrlCloseFrame) String() string {
+	return fmt.Sprintf("socket %#08x", cc.Cookie)
+}
+
+// Mgmt command sent by the socket identified by Cookie, the controller index is the one of the Frame
+type CtrlCommandFrame struct {
+	Cookie  uint32
+	CmdCode btmgmt.CmdCode
+	Params  []byte
+}
+
+func (cc *CtrlCommandFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) < 6 {
+		return ErrFrameFormat
+	}
+	cc.Cookie = binary.LittleEndian.Uint32(data[0:4])
+	cc.CmdCode = btmgmt.CmdCode(binary.LittleEndian.Uint16(data[4:6]))
+	cc.Params = data[6:]
+	return
+}
+
+func (cc CtrlCommandFrame) String() string {
+	return fmt.Sprintf("socket %#08x command %#04x params % x", cc.Cookie, uint16(cc.CmdCode), cc.Params)
+}
+
+// Mgmt event received by the socket identified by Cookie, the controller index is the one of the Frame
+type CtrlEventFrame struct {
+	Cookie    uint32
+	EventCode btmgmt.EvtCode
+	Params    []byte
+}
+
+func (ce *CtrlEventFrame) UpdateFromPayload(data []byte) (err error) {
+	if len(data) < 6 {
+		return ErrFrameFormat
+	}
+	ce.Cookie = binary.LittleEndian.Uint32(data[0:4])
+	ce.EventCode = btmgmt.EvtCode(binary.LittleEndian.Uint16(data[4:6]))
+	ce.Params = data[6:]
+	return
+}
+
+func (ce CtrlEventFrame) String() string {
+	return fmt.Sprintf("socket %#08x event %#04x params % x", ce.Cookie, uint16(ce.EventCode), ce.Params)
+}
+
+func zeroTerminated(src []byte) string {
+	for idx, v := range src {
+		if v == 0 {
+			return string(src[:idx])
+		}
+	}
+	return string(src)
+}
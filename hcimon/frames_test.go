@@ -0,0 +1,72 @@
+package hcimon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeCtrlOpen(t *testing.T) {
+	pkt := []byte{
+		0x0e, 0x00, 0xff, 0xff, 18, 0, // monitor header: opcode, index, length
+		0x78, 0x56, 0x34, 0x12, // cookie
+		0x00, 0x00, // format (mgmt)
+		1, 0x16, 0x00, // version, revision
+		0x01, 0x00, 0x00, 0x00, // flags
+		4, 'b', 't', 'd', 0, // ident
+	}
+	f, err := ParseFrame(pkt, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, err := f.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	co, ok := typed.(*CtrlOpenFrame)
+	if !ok {
+		t.Fatalf("decoded as %T", typed)
+	}
+	want := CtrlOpenFrame{Cookie: 0x12345678, Format: 0, Version: 1, Revision: 22, Flags: 1, Ident: "btd"}
+	if *co != want {
+		t.Errorf("got %+v, want %+v", *co, want)
+	}
+	if f.Index != INDEX_NONE {
+		t.Errorf("index %#x", f.Index)
+	}
+
+	// the ident exceeds the frame
+	f.Data[13] = 5
+	if _, err = f.Decode(); err != ErrFrameFormat {
+		t.Errorf("got %v, want %v", err, ErrFrameFormat)
+	}
+	if _, err = ParseFrame(pkt[:len(pkt)-1], time.Now()); err != ErrFrameFormat {
+		t.Errorf("truncated packet: got %v, want %v", err, ErrFrameFormat)
+	}
+}
+
+func TestDecodeUserLogging(t *testing.T) {
+	f := &Frame{
+		Opcode: OPCODE_USER_LOGGING,
+		Index:  INDEX_NONE,
+		Data:   []byte{6, 10, 'b', 'l', 'u', 'e', 't', 'o', 'o', 't', 'h', 0, 'h', 'e', 'l', 'l', 'o', 0},
+	}
+	typed, err := f.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ul, ok := typed.(*UserLoggingFrame)
+	if !ok {
+		t.Fatalf("decoded as %T", typed)
+	}
+	if ul.Priority != 6 || ul.Ident != "bluetooth" || ul.Message != "hello" {
+		t.Errorf("got %+v", *ul)
+	}
+	if s := ul.String(); s != "bluetooth: hello" {
+		t.Errorf("got %q", s)
+	}
+
+	f.Data = []byte{6, 11, 'b'}
+	if _, err = f.Decode(); err != ErrFrameFormat {
+		t.Errorf("got %v, want %v", err, ErrFrameFormat)
+	}
+}
@@ -0,0 +1,212 @@
+// Package hcimon reads the HCI monitor channel of the kernel (the source of btmon), which mirrors all HCI traffic
+// of all controllers plus the mgmt traffic of all management sockets. Captured frames could be written to
+// btsnoop or pcap files (DLT_BLUETOOTH_LINUX_MONITOR), both readable by btmon and Wireshark.
+package hcimon
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Details see: https://git.kernel.org/pub/scm/bluetooth/bluez.git/tree/monitor/control.c
+// and include/net/bluetooth/hci_mon.h of the kernel
+
+var (
+	ErrSocketOpen    = errors.New("Opening monitor socket failed")
+	ErrSocketBind    = errors.New("Binding monitor socket failed")
+	ErrClosed        = errors.New("Monitor has been closed")
+	ErrFrameFormat   = errors.New("Unexpected monitor frame format")
+	ErrUnknownOpcode = errors.New("Unknown monitor opcode")
+	ErrFileFormat    = errors.New("Unexpected capture file format")
+	ErrDatalinkType  = errors.New("Unsupported capture datalink type")
+)
+
+const INDEX_NONE = uint16(0xFFFF) // frames not related to a controller (f.e. system notes)
+
+type Opcode uint16
+
+const (
+	OPCODE_NEW_INDEX    Opcode = 0x00
+	OPCODE_DEL_INDEX    Opcode = 0x01
+	OPCODE_COMMAND_PKT  Opcode = 0x02
+	OPCODE_EVENT_PKT    Opcode = 0x03
+	OPCODE_ACL_TX_PKT   Opcode = 0x04
+	OPCODE_ACL_RX_PKT   Opcode = 0x05
+	OPCODE_SCO_TX_PKT   Opcode = 0x06
+	OPCODE_SCO_RX_PKT   Opcode = 0x07
+	OPCODE_OPEN_INDEX   Opcode = 0x08
+	OPCODE_CLOSE_INDEX  Opcode = 0x09
+	OPCODE_INDEX_INFO   Opcode = 0x0A
+	OPCODE_VENDOR_DIAG  Opcode = 0x0B
+	OPCODE_SYSTEM_NOTE  Opcode = 0x0C
+	OPCODE_USER_LOGGING Opcode = 0x0D
+	OPCODE_CTRL_OPEN    Opcode = 0x0E
+	OPCODE_CTRL_CLOSE   Opcode = 0x0F
+	OPCODE_CTRL_COMMAND Opcode = 0x10
+	OPCODE_CTRL_EVENT   Opcode = 0x11
+	OPCODE_ISO_TX_PKT   Opcode = 0x12
+	OPCODE_ISO_RX_PKT   Opcode = 0x13
+)
+
+func (o Opcode) String() string {
+	if name, exists := opcodeNameMap[o]; exists {
+		return name
+	}
+	return "Unknown"
+}
+
+var opcodeNameMap = genOpcodeNameMap()
+
+func genOpcodeNameMap() (nMap map[Opcode]string) {
+	nMap = make(map[Opcode]string)
+	nMap[OPCODE_NEW_INDEX] = "New Index"
+	nMap[OPCODE_DEL_INDEX] = "Delete Index"
+	nMap[OPCODE_COMMAND_PKT] = "HCI Command"
+	nMap[OPCODE_EVENT_PKT] = "HCI Event"
+	nMap[OPCODE_ACL_TX_PKT] = "ACL Data TX"
+	nMap[OPCODE_ACL_RX_PKT] = "ACL Data RX"
+	nMap[OPCODE_SCO_TX_PKT] = "SCO Data TX"
+	nMap[OPCODE_SCO_RX_PKT] = "SCO Data RX"
+	nMap[OPCODE_OPEN_INDEX] = "Open Index"
+	nMap[OPCODE_CLOSE_INDEX] = "Close Index"
+	nMap[OPCODE_INDEX_INFO] = "Index Info"
+	nMap[OPCODE_VENDOR_DIAG] = "Vendor Diagnostic"
+	nMap[OPCODE_SYSTEM_NOTE] = "System Note"
+	nMap[OPCODE_USER_LOGGING] = "User Logging"
+	nMap[OPCODE_CTRL_OPEN] = "Control Open"
+	nMap[OPCODE_CTRL_CLOSE] = "Control Close"
+	nMap[OPCODE_CTRL_COMMAND] = "Mgmt Command"
+	nMap[OPCODE_CTRL_EVENT] = "Mgmt Event"
+	nMap[OPCODE_ISO_TX_PKT] = "ISO Data TX"
+	nMap[OPCODE_ISO_RX_PKT] = "ISO Data RX"
+	return nMap
+}
+
+// Raw frame of the monitor channel, Data excludes the monitor header. Use Decode to get a typed frame.
+type Frame struct {
+	Opcode    Opcode
+	Index     uint16
+	Timestamp time.Time
+	Data      []byte
+}
+
+// Parses a frame with monitor header (opcode, index, length), as read from the monitor socket
+func ParseFrame(packet []byte, timestamp time.Time) (f *Frame, err error) {
+	if len(packet) < 6 {
+		return nil, ErrFrameFormat
+	}
+	dataLen := int(binary.LittleEndian.Uint16(packet[4:6]))
+	if len(packet)-6 != dataLen {
+		return nil, ErrFrameFormat
+	}
+	return &Frame{
+		Opcode:    Opcode(binary.LittleEndian.Uint16(packet[0:2])),
+		Index:     binary.LittleEndian.Uint16(packet[2:4]),
+		Timestamp: timestamp,
+		Data:      append([]byte{}, packet[6:]...),
+	}, nil
+}
+
+// Frame with monitor header, as read from the monitor socket
+func (f *Frame) toWire() []byte {
+	wire := make([]byte, 6, 6+len(f.Data))
+	binary.LittleEndian.PutUint16(wire[0:2], uint16(f.Opcode))
+	binary.LittleEndian.PutUint16(wire[2:4], f.Index)
+	binary.LittleEndian.PutUint16(wire[4:6], uint16(len(f.Data)))
+	return append(wire, f.Data...)
+}
+
+// Source of frames, implemented by Monitor (live) and BtsnoopReader (capture file)
+type FrameReader interface {
+	ReadFrame() (f *Frame, err error)
+}
+
+// Raw HCI socket bound to the monitor channel, needs CAP_NET_RAW
+type Monitor struct {
+	fd        int
+	rBuf      []byte
+	oob       []byte
+	closeOnce *sync.Once
+}
+
+func Open() (m *Monitor, err error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.BTPROTO_HCI)
+	if err != nil {
+		return nil, ErrSocketOpen
+	}
+	err = unix.Bind(fd, &unix.SockaddrHCI{
+		Dev:     INDEX_NONE,
+		Channel: unix.HCI_CHANNEL_MONITOR,
+	})
+	if err != nil {
+		unix.Close(fd)
+		return nil, ErrSocketBind
+	}
+	// kernel timestamps are more precise than the time of reception, they're optional though
+	unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMP, 1)
+	return &Monitor{
+		fd:        fd,
+		rBuf:      make([]byte, 6+0xffff),
+		oob:       make([]byte, 64),
+		closeOnce: &sync.Once{},
+	}, nil
+}
+
+// Blocks till the next frame arrives. Not safe for concurrent use.
+func (m *Monitor) ReadFrame() (f *Frame, err error) {
+	n, oobn, _, _, err := unix.Recvmsg(m.fd, m.rBuf, m.oob, 0)
+	if err == unix.EBADF {
+		return nil, ErrClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrClosed
+	}
+	return ParseFrame(m.rBuf[:n], parseTimestamp(m.oob[:oobn]))
+}
+
+func parseTimestamp(oob []byte) time.Time {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err == nil {
+		for _, msg := range msgs {
+			// struct timeval has platform dependent size (32 bit ARM vs. 64 bit)
+			if msg.Header.Level == unix.SOL_SOCKET && msg.Header.Type == unix.SCM_TIMESTAMP && len(msg.Data) >= int(unsafe.Sizeof(unix.Timeval{})) {
+				tv := (*unix.Timeval)(unsafe.Pointer(&msg.Data[0]))
+				return time.Unix(tv.Unix())
+			}
+		}
+	}
+	return time.Now()
+}
+
+// Unblocks a pending ReadFrame (if the socket supports shutdown), could be called multiple times
+func (m *Monitor) Close() (err error) {
+	m.closeOnce.Do(func() {
+		unix.Shutdown(m.fd, unix.SHUT_RDWR)
+		err = unix.Close(m.fd)
+	})
+	return
+}
+
+// Writes every frame to the given writers, till reading fails (f.e. because the monitor is closed).
+// ReadFrame errors are returned, write errors abort the capture, too.
+func (m *Monitor) Capture(writers ...FrameWriter) (err error) {
+	for {
+		f, err := m.ReadFrame()
+		if err != nil {
+			return err
+		}
+		for _, w := range writers {
+			if err = w.WriteFrame(f); err != nil {
+				return err
+			}
+		}
+	}
+}
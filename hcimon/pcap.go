@@ -0,0 +1,52 @@
+package hcimon
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Details see: https://wiki.wireshark.org/Development/LibpcapFileFormat and
+// https://www.tcpdump.org/linktypes/LINKTYPE_BLUETOOTH_LINUX_MONITOR.html
+
+const (
+	PCAP_MAGIC                    = uint32(0xa1b2c3d4) // microsecond timestamps
+	DLT_BLUETOOTH_LINUX_MONITOR   = uint32(254)
+	pcapSnapLen                   = uint32(0xffff + 4)
+	pcapMonitorPseudoHeaderLength = 4
+)
+
+// Writes pcap files with DLT_BLUETOOTH_LINUX_MONITOR link type (readable by Wireshark)
+type PcapWriter struct {
+	w io.Writer
+}
+
+// Writes the file header
+func NewPcapWriter(w io.Writer) (pw *PcapWriter, err error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], PCAP_MAGIC)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2) // version 2.4
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	// thiszone and sigfigs stay 0
+	binary.LittleEndian.PutUint32(hdr[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:24], DLT_BLUETOOTH_LINUX_MONITOR)
+	_, err = w.Write(hdr)
+	if err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+func (pw *PcapWriter) WriteFrame(f *Frame) (err error) {
+	pktLen := uint32(pcapMonitorPseudoHeaderLength + len(f.Data))
+	rec := make([]byte, 16+pcapMonitorPseudoHeaderLength, 16+pktLen)
+	usec := f.Timestamp.UnixNano() / 1000
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(usec/1000000))
+	binary.LittleEndian.PutUint32(rec[4:8], uint32(usec%1000000))
+	binary.LittleEndian.PutUint32(rec[8:12], pktLen)  // included length
+	binary.LittleEndian.PutUint32(rec[12:16], pktLen) // original length
+	// pseudo header fields are big endian, in contrast to the monitor header
+	binary.BigEndian.PutUint16(rec[16:18], f.Index)
+	binary.BigEndian.PutUint16(rec[18:20], uint16(f.Opcode))
+	_, err = pw.w.Write(append(rec, f.Data...))
+	return
+}
@@ -0,0 +1,29 @@
+package hcimon
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPcapRecord(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pw, err := NewPcapWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pw.WriteFrame(testFrame()); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0xd4, 0xc3, 0xb2, 0xa1, 2, 0, 4, 0, // magic, version 2.4
+		0, 0, 0, 0, 0, 0, 0, 0, // thiszone, sigfigs
+		0x03, 0x00, 0x01, 0x00, 254, 0, 0, 0, // snaplen, DLT_BLUETOOTH_LINUX_MONITOR
+		0x00, 0x10, 0x5e, 0x5f, 0x40, 0xe2, 0x01, 0x00, // seconds, microseconds
+		9, 0, 0, 0, 9, 0, 0, 0, // included and original length, with pseudo header
+		0x00, 0x02, 0x00, 0x05, // pseudo header: index, opcode (big endian)
+		0x40, 0x20, 0x01, 0x00, 0xaa,
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got\n% x\nwant\n% x", buf.Bytes(), want)
+	}
+}
@@ -0,0 +1,200 @@
+package hcimon
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+)
+
+type ReplayOptions struct {
+	// Only events received by the mgmt socket with this cookie are replayed (a capture contains the events of
+	// every mgmt socket). 0 selects the socket of the first Mgmt Event frame.
+	Cookie uint32
+	// Keeps the time between events of the capture, events are replayed as fast as possible otherwise
+	Realtime bool
+}
+
+// btmgmt.Transport, which replays the mgmt events of a capture (f.e. a BtsnoopReader) into a MgmtConnection:
+//
+//	rt := hcimon.NewReplayTransport(reader, hcimon.ReplayOptions{})
+//	conn := btmgmt.NewMgmtConnectionWithTransport(rt)
+//	... add listeners / subscriptions
+//	rt.Start()
+//	<-rt.Done()
+//
+// Commands written to the transport are recorded, but not answered. Results of recorded commands are part of the
+// replayed events, though (Command Complete / Command Status of the selected socket).
+// Done relies on the MgmtConnection reporting dispatched events (see btmgmt.DispatchObserver), other readers of
+// the transport have to call EventDispatched for every packet read.
+type ReplayTransport struct {
+	*sync.Mutex
+	frames    FrameReader
+	opts      ReplayOptions
+	started   chan struct{}
+	startOnce *sync.Once
+	done      chan struct{}
+	doneOnce  *sync.Once
+	closed    chan struct{}
+	closeOnce *sync.Once
+	lastTs    time.Time
+	err       error
+	commands  [][]byte
+	finished  bool // the capture has been read completely (or reading failed)
+	returned  int  // event packets returned by Read
+	handled   int  // event packets dispatched by the reader
+}
+
+func NewReplayTransport(frames FrameReader, opts ReplayOptions) (rt *ReplayTransport) {
+	return &ReplayTransport{
+		Mutex:     &sync.Mutex{},
+		frames:    frames,
+		opts:      opts,
+		started:   make(chan struct{}),
+		startOnce: &sync.Once{},
+		done:      make(chan struct{}),
+		doneOnce:  &sync.Once{},
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+// Starts replaying, Read blocks till then (gives the chance to register listeners first)
+func (rt *ReplayTransport) Start() {
+	rt.startOnce.Do(func() { close(rt.started) })
+}
+
+// Closed once all events have been dispatched to the listeners of the MgmtConnection (or reading the capture
+// failed, see Err)
+func (rt *ReplayTransport) Done() <-chan struct{} {
+	return rt.done
+}
+
+// Error which stopped reading the capture (nil if the capture has been replayed completely)
+func (rt *ReplayTransport) Err() error {
+	rt.Lock()
+	defer rt.Unlock()
+	return rt.err
+}
+
+// Command packets written to the transport
+func (rt *ReplayTransport) Commands() [][]byte {
+	rt.Lock()
+	defer rt.Unlock()
+	return append([][]byte{}, rt.commands...)
+}
+
+// Returns the next mgmt event packet of the capture. Once all events are replayed, Read blocks till the transport
+// is closed (the MgmtConnection would be closed otherwise).
+func (rt *ReplayTransport) Read(p []byte) (n int, err error) {
+	select {
+	case <-rt.started:
+	case <-rt.closed:
+		return 0, io.EOF
+	}
+	for {
+		f, rErr := rt.frames.ReadFrame()
+		if rErr != nil {
+			rt.Lock()
+			if rErr != io.EOF {
+				rt.err = rErr
+			}
+			rt.finished = true
+			rt.checkDone()
+			rt.Unlock()
+			<-rt.closed
+			return 0, io.EOF
+		}
+		pkt, ok := rt.selectEvent(f)
+		if !ok {
+			continue
+		}
+		if !rt.wait(f.Timestamp) {
+			return 0, io.EOF
+		}
+		rt.Lock()
+		rt.returned++
+		rt.Unlock()
+		return copy(p, pkt), nil
+	}
+}
+
+// Called by the MgmtConnection for every dispatched event packet, see btmgmt.DispatchObserver
+func (rt *ReplayTransport) EventDispatched() {
+	rt.Lock()
+	defer rt.Unlock()
+	rt.handled++
+	rt.checkDone()
+}
+
+// closes done, once the capture has been read completely and every returned event has been dispatched,
+// has to be called with the lock held
+func (rt *ReplayTransport) checkDone() {
+	if rt.finished && rt.handled >= rt.returned {
+		rt.doneOnce.Do(func() { close(rt.done) })
+	}
+}
+
+// returns the frame as mgmt event packet, if it is an event of the replayed socket
+func (rt *ReplayTransport) selectEvent(f *Frame) (pkt []byte, ok bool) {
+	if f.Opcode != OPCODE_CTRL_EVENT {
+		return nil, false
+	}
+	ce := &CtrlEventFrame{}
+	if ce.UpdateFromPayload(f.Data) != nil {
+		return nil, false
+	}
+	if rt.opts.Cookie == 0 {
+		rt.opts.Cookie = ce.Cookie
+	}
+	if ce.Cookie != rt.opts.Cookie {
+		return nil, false
+	}
+	pkt = make([]byte, 6, 6+len(ce.Params))
+	binary.LittleEndian.PutUint16(pkt[0:2], uint16(ce.EventCode))
+	binary.LittleEndian.PutUint16(pkt[2:4], f.Index)
+	binary.LittleEndian.PutUint16(pkt[4:6], uint16(len(ce.Params)))
+	return append(pkt, ce.Params...), true
+}
+
+// sleeps for the time between the last and the current event in realtime mode, false if closed meanwhile
+func (rt *ReplayTransport) wait(ts time.Time) bool {
+	if !rt.opts.Realtime || rt.lastTs.IsZero() {
+		rt.lastTs = ts
+		return true
+	}
+	delay := ts.Sub(rt.lastTs)
+	rt.lastTs = ts
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-rt.closed:
+		return false
+	}
+}
+
+func (rt *ReplayTransport) Write(p []byte) (n int, err error) {
+	select {
+	case <-rt.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	rt.Lock()
+	defer rt.Unlock()
+	rt.commands = append(rt.commands, append([]byte{}, p...))
+	return len(p), nil
+}
+
+func (rt *ReplayTransport) Close() error {
+	rt.closeOnce.Do(func() { close(rt.closed) })
+	return nil
+}
+
+// assure the interfaces are implemented
+var _ btmgmt.Transport = &ReplayTransport{}
+var _ btmgmt.DispatchObserver = &ReplayTransport{}
@@ -0,0 +1,100 @@
+package hcimon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mame82/mblue-toolz/btmgmt"
+)
+
+func ctrlEventFrame(cookie uint32, index uint16, code btmgmt.EvtCode, params ...byte) *Frame {
+	data := make([]byte, 6)
+	binary.LittleEndian.PutUint32(data[0:4], cookie)
+	binary.LittleEndian.PutUint16(data[4:6], uint16(code))
+	return &Frame{Opcode: OPCODE_CTRL_EVENT, Index: index, Timestamp: time.Now(), Data: append(data, params...)}
+}
+
+// records every event handed to it
+type recordingListener struct {
+	*sync.Mutex
+	events []btmgmt.Event
+}
+
+func (l *recordingListener) Filter(event btmgmt.Event) bool { return true }
+
+func (l *recordingListener) Handle(event btmgmt.Event) bool {
+	l.Lock()
+	defer l.Unlock()
+	l.events = append(l.events, event)
+	return false
+}
+
+func (l *recordingListener) codes() (codes []btmgmt.EvtCode) {
+	l.Lock()
+	defer l.Unlock()
+	for _, e := range l.events {
+		codes = append(codes, e.EventCode)
+	}
+	return
+}
+
+func TestReplayDeliversAllEventsBeforeDone(t *testing.T) {
+	capture := &bytes.Buffer{}
+	bw, err := NewBtsnoopWriter(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := []*Frame{
+		{Opcode: OPCODE_SYSTEM_NOTE, Index: INDEX_NONE, Timestamp: time.Now(), Data: []byte("note\x00")},
+		ctrlEventFrame(7, 0, btmgmt.EVT_NEW_SETTINGS, 0x01, 0, 0, 0),
+		ctrlEventFrame(8, 0, btmgmt.EVT_NEW_SETTINGS, 0x01, 0, 0, 0), // other socket, not replayed
+		ctrlEventFrame(7, 0, btmgmt.EVT_DISCOVERING, 0x07, 0x01),
+		ctrlEventFrame(7, 1, btmgmt.EVT_INDEX_REMOVED),
+	}
+	expected := []btmgmt.EvtCode{btmgmt.EVT_NEW_SETTINGS, btmgmt.EVT_DISCOVERING, btmgmt.EVT_INDEX_REMOVED}
+	for i := 0; i < 50; i++ {
+		f := ctrlEventFrame(7, 0, btmgmt.EVT_CONTROLLER_ERROR, byte(i))
+		frames = append(frames, f)
+		expected = append(expected, btmgmt.EVT_CONTROLLER_ERROR)
+	}
+	for _, f := range frames {
+		if err = bw.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	br, err := NewBtsnoopReader(bytes.NewReader(capture.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := NewReplayTransport(br, ReplayOptions{})
+	conn := btmgmt.NewMgmtConnectionWithTransport(rt)
+	defer conn.Close()
+	listener := &recordingListener{Mutex: &sync.Mutex{}}
+	if err = conn.AddListener(listener); err != nil {
+		t.Fatal(err)
+	}
+	rt.Start()
+	select {
+	case <-rt.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay didn't finish")
+	}
+	if rt.Err() != nil {
+		t.Fatal(rt.Err())
+	}
+
+	// no waiting, every event has to be dispatched once Done is closed
+	got := listener.codes()
+	if len(got) != len(expected) {
+		t.Fatalf("%d events dispatched before Done, want %d", len(got), len(expected))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("event %d: got %#x, want %#x", i, got[i], expected[i])
+		}
+	}
+}
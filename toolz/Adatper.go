@@ -3,6 +3,7 @@ package toolz
 import (
 	"errors"
 	"github.com/godbus/dbus"
+	"github.com/mame82/mblue-toolz/bt_cod"
 	"github.com/mame82/mblue-toolz/dbusHelper"
 	"log"
 	"net"
@@ -128,12 +129,12 @@ func (a *Adapter1) GetAlias() (res string, err error) {
 	return val.Value().(string), nil
 }
 
-func (a *Adapter1) GetClass() (res uint32, err error) {
+func (a *Adapter1) GetClass() (res bt_cod.CoD, err error) {
 	val, err := a.c.GetProperty(PropAdapterClass)
 	if err != nil {
 		return
 	}
-	return val.Value().(uint32), nil
+	return bt_cod.CoD(val.Value().(uint32)), nil
 }
 
 func (a *Adapter1) GetPowered() (res bool, err error) {
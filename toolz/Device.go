@@ -2,9 +2,12 @@ package toolz
 
 import (
 	"github.com/godbus/dbus"
+	"github.com/mame82/mblue-toolz/bt_ad"
+	"github.com/mame82/mblue-toolz/bt_cod"
 	"github.com/mame82/mblue-toolz/dbusHelper"
 	"errors"
 	"net"
+	"sort"
 )
 
 const DBusNameDevice1Interface = "org.bluez.Device1"
@@ -31,7 +34,7 @@ const (
 	PropDeviceServiceData      = "ServiceData"      //readonly, optional, map[string][]byte ??
 	PropDeviceServicesResolved = "ServicesResolved" //readonly, bool
 	PropDeviceAdvertisingFlags = "AdvertisingFlags" //readonly, experimental, []byte
-	PropDeviceAdvertisingData  = "AdvertisingData"  //readonly, experimental, map[uint8][]byte ???
+	PropDeviceAdvertisingData  = "AdvertisingData"  //readonly, experimental, map[uint8][]byte -> bt_ad.AdvertisingData
 )
 
 var (
@@ -178,6 +181,45 @@ func (d *Device1) GetPaired() (res bool, err error) {
 	return
 }
 
+// Class of Device, only present for BR/EDR devices
+func (d *Device1) GetClass() (res bt_cod.CoD, err error) {
+	val, err := d.c.GetProperty(PropDeviceClass)
+	if err != nil {
+		return
+	}
+	class,ok := val.Value().(uint32)
+	if !ok {
+		return res, ePropertyTypeCast
+	}
+	return bt_cod.CoD(class), nil
+}
+
+// AD structures of the last advertisement, which aren't handled by other properties (f.e. URI or
+// mesh related types). One field per AD type, sorted by type.
+func (d *Device1) GetAdvertisingData() (res *bt_ad.AdvertisingData, err error) {
+	val, err := d.c.GetProperty(PropDeviceAdvertisingData)
+	if err != nil {
+		return
+	}
+	adMap,ok := val.Value().(map[byte]dbus.Variant)
+	if !ok {
+		return res, ePropertyTypeCast
+	}
+	types := make([]int, 0, len(adMap))
+	for t := range adMap {
+		types = append(types, int(t))
+	}
+	sort.Ints(types)
+	res = bt_ad.New()
+	for _,t := range types {
+		data,ok := adMap[byte(t)].Value().([]byte)
+		if !ok {
+			return nil, ePropertyTypeCast
+		}
+		res.Add(bt_ad.ADType(t), data)
+	}
+	return
+}
 
 func (d *Device1) GetAlias() (res string, err error) {
 	val, err := d.c.GetProperty(PropDeviceAlias)
@@ -0,0 +1,101 @@
+package toolz
+
+import (
+	"github.com/godbus/dbus"
+	"github.com/mame82/mblue-toolz/bt_ad"
+)
+
+const DBusNameLEAdvertisement1Interface = "org.bluez.LEAdvertisement1"
+
+const (
+	PropLEAdvertisementType             = "Type"             //string, "broadcast" or "peripheral"
+	PropLEAdvertisementServiceUUIDs     = "ServiceUUIDs"     //optional, []string
+	PropLEAdvertisementSolicitUUIDs     = "SolicitUUIDs"     //optional, []string
+	PropLEAdvertisementManufacturerData = "ManufacturerData" //optional, map[uint16]dbus.Variant ([]byte)
+	PropLEAdvertisementServiceData      = "ServiceData"      //optional, map[string]dbus.Variant ([]byte)
+	PropLEAdvertisementData             = "Data"             //optional, experimental, map[byte]dbus.Variant ([]byte)
+	PropLEAdvertisementIncludes         = "Includes"         //optional, []string
+	PropLEAdvertisementLocalName        = "LocalName"        //optional, string
+	PropLEAdvertisementAppearance       = "Appearance"       //optional, uint16
+)
+
+type LEAdvertisementType string
+
+const (
+	LE_ADVERTISEMENT_TYPE_BROADCAST  LEAdvertisementType = "broadcast"
+	LE_ADVERTISEMENT_TYPE_PERIPHERAL LEAdvertisementType = "peripheral"
+)
+
+// appends the UUIDs of a list with the given UUID length (wire order) in 128 bit string representation
+func appendUUIDs(uuids []string, list []byte, uuidLen int) []string {
+	for off := 0; off+uuidLen <= len(list); off += uuidLen {
+		uuids = append(uuids, bt_ad.UUIDFromPayload(list[off:off+uuidLen]))
+	}
+	return uuids
+}
+
+// Converts advertising data (f.e. a bt_beacon frame) to the properties of a LEAdvertisement1 object, which has to
+// be exported on the bus and registered with LEAdvertisingManager1. The flags are dropped, as BlueZ adds them
+// itself, a TX power field is replaced by the "tx-power" include (BlueZ inserts the current TX power). AD types
+// without a dedicated property end up in the experimental Data property.
+func LEAdvertisementProperties(advType LEAdvertisementType, ad *bt_ad.AdvertisingData) (props map[string]dbus.Variant) {
+	props = map[string]dbus.Variant{
+		PropLEAdvertisementType: dbus.MakeVariant(string(advType)),
+	}
+	var solicitUUIDs []string
+	var includes []string
+	data := make(map[byte]dbus.Variant)
+	for _, f := range ad.Fields {
+		switch f.Type {
+		case bt_ad.AD_FLAGS, bt_ad.AD_UUID16_INCOMPLETE, bt_ad.AD_UUID16_COMPLETE, bt_ad.AD_UUID32_INCOMPLETE,
+			bt_ad.AD_UUID32_COMPLETE, bt_ad.AD_UUID128_INCOMPLETE, bt_ad.AD_UUID128_COMPLETE,
+			bt_ad.AD_MANUFACTURER_DATA, bt_ad.AD_SERVICE_DATA_UUID16, bt_ad.AD_SERVICE_DATA_UUID32,
+			bt_ad.AD_SERVICE_DATA_UUID128, bt_ad.AD_NAME_SHORT, bt_ad.AD_NAME_COMPLETE, bt_ad.AD_APPEARANCE:
+			// handled below, by the getters of AdvertisingData
+		case bt_ad.AD_SOLICIT_UUID16:
+			solicitUUIDs = appendUUIDs(solicitUUIDs, f.Data, 2)
+		case bt_ad.AD_SOLICIT_UUID32:
+			solicitUUIDs = appendUUIDs(solicitUUIDs, f.Data, 4)
+		case bt_ad.AD_SOLICIT_UUID128:
+			solicitUUIDs = appendUUIDs(solicitUUIDs, f.Data, 16)
+		case bt_ad.AD_TX_POWER:
+			includes = append(includes, "tx-power")
+		default:
+			data[byte(f.Type)] = dbus.MakeVariant(f.Data)
+		}
+	}
+
+	if uuids := ad.UUIDs(); len(uuids) > 0 {
+		props[PropLEAdvertisementServiceUUIDs] = dbus.MakeVariant(uuids)
+	}
+	if len(solicitUUIDs) > 0 {
+		props[PropLEAdvertisementSolicitUUIDs] = dbus.MakeVariant(solicitUUIDs)
+	}
+	if md := ad.ManufacturerData(); len(md) > 0 {
+		variants := make(map[uint16]dbus.Variant)
+		for companyID, d := range md {
+			variants[companyID] = dbus.MakeVariant(d)
+		}
+		props[PropLEAdvertisementManufacturerData] = dbus.MakeVariant(variants)
+	}
+	if sd := ad.ServiceData(); len(sd) > 0 {
+		variants := make(map[string]dbus.Variant)
+		for uuid, d := range sd {
+			variants[uuid] = dbus.MakeVariant(d)
+		}
+		props[PropLEAdvertisementServiceData] = dbus.MakeVariant(variants)
+	}
+	if name, exists := ad.Name(); exists {
+		props[PropLEAdvertisementLocalName] = dbus.MakeVariant(name)
+	}
+	if appearance, exists := ad.Appearance(); exists {
+		props[PropLEAdvertisementAppearance] = dbus.MakeVariant(appearance)
+	}
+	if len(includes) > 0 {
+		props[PropLEAdvertisementIncludes] = dbus.MakeVariant(includes)
+	}
+	if len(data) > 0 {
+		props[PropLEAdvertisementData] = dbus.MakeVariant(data)
+	}
+	return
+}